@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/ewancrowle/porter/internal/accesslog"
+	"github.com/ewancrowle/porter/internal/acl"
 	"github.com/ewancrowle/porter/internal/api"
 	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/geoip"
+	"github.com/ewancrowle/porter/internal/health"
 	"github.com/ewancrowle/porter/internal/relay"
 	"github.com/ewancrowle/porter/internal/strategy"
 	"github.com/ewancrowle/porter/internal/sync"
@@ -35,46 +42,144 @@ func main() {
 		manager.Register(strategy.StrategyAgones, agones)
 	}
 
+	geo := strategy.NewGeoIPStrategy()
+	var geoDB *geoip.Database
+	if cfg.GeoIP.Enabled {
+		geoDB, err = geoip.NewDatabase(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load GeoIP databases: %v", err)
+		}
+		geo.SetLocator(geoDB)
+		manager.Register(strategy.StrategyGeoIP, geo)
+	}
+
 	// 3. Load initial routes from config
 	for _, r := range cfg.Routes {
 		switch strategy.StrategyType(r.Type) {
 		case strategy.StrategySimple:
-			simple.UpdateRoute(r.FQDN, r.Target)
-			log.Printf("Loaded route from config: %s -> %s (simple)", r.FQDN, r.Target)
+			route := strategy.Route{
+				FQDN:      r.FQDN,
+				Type:      strategy.StrategySimple,
+				Target:    r.Target,
+				Algorithm: strategy.Algorithm(r.Algorithm),
+			}
+			for _, t := range r.Targets {
+				route.Targets = append(route.Targets, strategy.WeightedTarget{Address: t.Address, Weight: t.Weight})
+			}
+			if err := simple.SetRoute(route); err != nil {
+				log.Printf("Warning: skipping route %s from config: %v", r.FQDN, err)
+				continue
+			}
+			if len(route.Targets) > 0 {
+				log.Printf("Loaded route from config: %s -> %d targets (simple, %s)", r.FQDN, len(route.Targets), r.Algorithm)
+			} else {
+				log.Printf("Loaded route from config: %s -> %s (simple)", r.FQDN, r.Target)
+			}
 		case strategy.StrategyAgones:
 			agones.UpdateRoute(r.FQDN, r.Target)
 			log.Printf("Loaded route from config: %s -> %s (agones)", r.FQDN, r.Target)
+		case strategy.StrategyGeoIP:
+			route, err := geoRoute(r)
+			if err == nil {
+				err = geo.SetRoute(route)
+			}
+			if err != nil {
+				log.Printf("Warning: skipping route %s from config: %v", r.FQDN, err)
+				continue
+			}
+			log.Printf("Loaded route from config: %s -> %d locations, default %s (geoip)", r.FQDN,
+				len(route.Geo.ASNs)+len(route.Geo.Countries)+len(route.Geo.Continents), r.Target)
 		default:
 			log.Printf("Warning: unknown strategy type %s for FQDN %s", r.Type, r.FQDN)
 		}
 	}
 
+	policy, err := acl.FromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load access policy: %v", err)
+	}
+
 	// 4. Initialize Redis sync
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	redisSync := sync.NewRedisSync(cfg, simple, agones)
+	redisSync.SetGeoIPStrategy(geo)
+	redisSync.SetAccessPolicy(policy)
 	if redisSync != nil {
-		if err := redisSync.LoadInitialRoutes(ctx); err != nil {
-			log.Printf("Warning: Failed to load initial routes from Redis: %v", err)
+		// Readiness fails until the routes are loaded, so keep retrying
+		// rather than serving without them.
+		go func() {
+			for {
+				err := redisSync.LoadInitialRoutes(ctx)
+				if err == nil {
+					return
+				}
+				log.Printf("Warning: Failed to load initial routes from Redis, retrying in 5s: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}()
+	}
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		redisSync.Subscribe(ctx)
+	}()
+
+	// 5. Start backend health checks
+	var checker *health.Checker
+	if cfg.Health.Enabled {
+		checker, err = health.NewChecker(cfg, simple, geo)
+		if err != nil {
+			log.Fatalf("Failed to initialize health checks: %v", err)
 		}
-		go redisSync.Subscribe(ctx)
+		simple.SetHealthChecker(checker)
+		geo.SetHealthChecker(checker)
+		go checker.Run(ctx)
+	}
+	if geoDB != nil {
+		go geoDB.Run(ctx)
 	}
 
-	// 4. Initialize and start UDP Relay
+	// 6. Initialize and start UDP Relay
 	engine, err := relay.NewRelay(cfg, manager)
 	if err != nil {
 		log.Fatalf("Failed to initialize UDP relay: %v", err)
 	}
+	simple.SetSessionCounter(engine)
+	if directory := redisSync.SessionDirectory(); directory != nil {
+		engine.SetSessionDirectory(directory)
+	}
+
+	accessLog, err := accesslog.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize access log: %v", err)
+	}
+	engine.SetAccessLog(accessLog)
+	engine.SetAccessPolicy(policy)
 
+	// The relay has its own context so it can stop before Redis and the
+	// health checks, which keep routes current while it drains.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+
+	relayDone := make(chan struct{})
 	go func() {
-		if err := engine.Start(ctx); err != nil {
+		defer close(relayDone)
+		if err := engine.Start(relayCtx); err != nil {
 			log.Fatalf("UDP relay error: %v", err)
 		}
 	}()
 
-	// 5. Initialize and start API Server
-	server := api.NewServer(cfg, simple, agones, redisSync)
+	// 7. Initialize and start API Server
+	server := api.NewServer(cfg, simple, agones, redisSync, checker)
+	server.SetRelay(engine)
+	server.SetAccessPolicy(policy)
+	server.SetGeoIPStrategy(geo)
 	go func() {
 		log.Printf("API Server listening on :%d", cfg.API.Port)
 		if err := server.Start(); err != nil {
@@ -87,6 +192,63 @@ func main() {
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
-	log.Println("Shutting down Porter...")
+	// 8. Stop accepting new connections and let existing sessions finish.
+	// A second signal skips the rest of the drain.
+	log.Printf("Shutting down Porter, draining sessions for up to %s...", cfg.UDP.DrainTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.UDP.DrainTimeout)
+	go func() {
+		select {
+		case <-stop:
+			log.Println("Received second signal, skipping drain")
+			cancelDrain()
+		case <-drainCtx.Done():
+		}
+	}()
+	if err := engine.Drain(drainCtx); err != nil {
+		log.Printf("Drain incomplete, closing %d remaining sessions", engine.Status().ActiveSessions)
+	}
+	cancelDrain()
+
+	// 9. Shut down the relay, API, Redis and Agones, in that order
+	stopRelay()
+	<-relayDone
+	if err := accessLog.Close(); err != nil {
+		log.Printf("Error closing access log: %v", err)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down API server: %v", err)
+	}
+
 	cancel()
+	<-subscribed
+	if err := redisSync.Close(); err != nil {
+		log.Printf("Error closing Redis client: %v", err)
+	}
+
+	if err := agones.Close(); err != nil {
+		log.Printf("Error closing Agones connection: %v", err)
+	}
+
+	log.Println("Porter stopped")
+}
+
+// geoRoute converts a geoip route from the config file, whose ASN keys are
+// strings.
+func geoRoute(r config.Route) (strategy.Route, error) {
+	geo := &strategy.GeoTargets{
+		ASNs:       make(map[uint32]string, len(r.Geo.ASNs)),
+		Countries:  r.Geo.Countries,
+		Continents: r.Geo.Continents,
+	}
+	for key, target := range r.Geo.ASNs {
+		asn, err := strconv.ParseUint(key, 10, 32)
+		if err != nil {
+			return strategy.Route{}, fmt.Errorf("invalid ASN %q", key)
+		}
+		geo.ASNs[uint32(asn)] = target
+	}
+	return strategy.Route{FQDN: r.FQDN, Type: strategy.StrategyGeoIP, Target: r.Target, Geo: geo}, nil
 }
@@ -0,0 +1,137 @@
+// Package accesslog writes structured JSON records of relay sessions.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+)
+
+const (
+	EventSessionStart = "session_start"
+	EventSessionEnd   = "session_end"
+)
+
+// Record is one access log line. Start records carry zero counters.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Client   string    `json:"client"`
+	SNI      string    `json:"sni"`
+	DCID     string    `json:"dcid"` // Hex-encoded original DCID of the client
+	Strategy string    `json:"strategy"`
+	Target   string    `json:"target"`
+
+	BytesIn    int64   `json:"bytes_in"` // Client to backend
+	BytesOut   int64   `json:"bytes_out"`
+	PacketsIn  int64   `json:"packets_in"`
+	PacketsOut int64   `json:"packets_out"`
+	Duration   float64 `json:"duration_seconds"`
+	Migrations int64   `json:"migrations"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// Logger writes records to a sink. A nil Logger discards everything.
+type Logger struct {
+	sampleRate float64
+
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// New opens the sink configured under access_log. It returns nil if access
+// logging is disabled.
+func New(cfg *config.Config) (*Logger, error) {
+	c := cfg.AccessLog
+	if !c.Enabled {
+		return nil, nil
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return nil, fmt.Errorf("access_log.sample_rate must be greater than 0 and at most 1")
+	}
+
+	var w io.WriteCloser
+	var err error
+	switch c.Output {
+	case "", "stdout":
+		w = nopCloser{os.Stdout}
+	case "file":
+		w, err = openRotatingFile(c.File.Path, int64(c.File.MaxSizeMB)<<20, c.File.MaxBackups)
+	case "syslog":
+		w, err = dialSyslog(c.Address)
+	case "udp":
+		if c.Address == "" {
+			return nil, fmt.Errorf("access_log.address is required for the udp output")
+		}
+		w, err = net.Dial("udp", c.Address)
+	default:
+		return nil, fmt.Errorf("unknown access_log.output %q", c.Output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+
+	return NewLogger(w, c.SampleRate), nil
+}
+
+// NewLogger returns a logger writing one JSON record per Write to w, sampling
+// sessions at the given rate.
+func NewLogger(w io.WriteCloser, sampleRate float64) *Logger {
+	return &Logger{w: w, sampleRate: sampleRate}
+}
+
+// Sample decides whether a new session is logged. Both of its records are
+// written or neither, so sampled start and end records always pair up.
+func (l *Logger) Sample() bool {
+	if l == nil {
+		return false
+	}
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+// Log writes the record. Write errors are logged and otherwise ignored, so a
+// failing sink never affects relaying.
+func (l *Logger) Log(rec Record) {
+	if l == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Failed to encode access log record: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(data); err != nil {
+		log.Printf("Failed to write access log record: %v", err)
+	}
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
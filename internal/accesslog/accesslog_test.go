@@ -0,0 +1,120 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error { return nil }
+
+func TestLog(t *testing.T) {
+	var buf bufferCloser
+	l := NewLogger(&buf, 1)
+	if !l.Sample() {
+		t.Error("Expected a sample rate of 1 to log every session")
+	}
+
+	l.Log(Record{Event: EventSessionEnd, Client: "203.0.113.7:5000", BytesIn: 1200, Reason: "idle timeout"})
+	l.Log(Record{Event: EventSessionStart})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("Invalid JSON record: %v", err)
+	}
+	if rec.Event != EventSessionEnd || rec.BytesIn != 1200 || rec.Reason != "idle timeout" || rec.Time.IsZero() {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+
+	var nilLogger *Logger
+	if nilLogger.Sample() {
+		t.Error("Expected a nil logger not to sample")
+	}
+	nilLogger.Log(Record{})
+}
+
+func TestSample(t *testing.T) {
+	l := NewLogger(&bufferCloser{}, 0.25)
+	sampled := 0
+	for range 10000 {
+		if l.Sample() {
+			sampled++
+		}
+	}
+	if sampled < 2000 || sampled > 3000 {
+		t.Errorf("Sampled %d of 10000 sessions at a rate of 0.25", sampled)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for range 4 {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	// Each file holds one 60 byte line; the oldest beyond two backups is gone.
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if !bytes.Equal(data, line) {
+			t.Errorf("%s = %q, want one line", name, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third backup, got %v", err)
+	}
+}
+
+func TestRotatingFileRenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 100, 1)
+	if err != nil {
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	// A directory in place of the backup makes the rename fail.
+	if err := os.Mkdir(path+".1", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path+".1", "keep"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for range 3 {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat(line, 3)) {
+		t.Errorf("%s = %q, want every line kept in the original file", path, data)
+	}
+}
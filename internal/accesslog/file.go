@@ -0,0 +1,102 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// rotatingFile appends to a file and rotates it once it would exceed
+// maxSize, keeping maxBackups old files as path.1 (newest) to path.N.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if path == "" {
+		return nil, errors.New("access_log.file.path is required for the file output")
+	}
+	if maxSize <= 0 {
+		return nil, errors.New("access_log.file.max_size_mb must be positive")
+	}
+
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would push the file past maxSize.
+// Records are never split across files. If rotation fails, p is appended to
+// the current file and rotation is tried again on the next write.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			log.Printf("Failed to rotate access log %s: %v", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to the first backup and starts a new one. The file
+// at path is reopened even if the backups could not be moved.
+func (r *rotatingFile) rotate() error {
+	err := r.f.Close()
+	if err == nil {
+		err = r.shiftBackups()
+	}
+	if openErr := r.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// shiftBackups renames path.N-1 to path.N, dropping the oldest, then path
+// to path.1, or removes path if no backups are kept.
+func (r *rotatingFile) shiftBackups() error {
+	if r.maxBackups <= 0 {
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(backupName(r.path, i), backupName(r.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(r.path, backupName(r.path, 1))
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+
+package accesslog
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// dialSyslog connects to the syslog daemon at addr over UDP, or to the local
+// daemon if addr is empty.
+func dialSyslog(addr string) (io.WriteCloser, error) {
+	network := "udp"
+	if addr == "" {
+		network = ""
+	}
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "porter")
+}
@@ -0,0 +1,192 @@
+// Package acl implements CIDR allow and deny lists for new connections.
+package acl
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+// Rules are allow and deny lists of CIDRs or single IPs. An address matched
+// by both lists is judged by the most specific match, with deny winning ties,
+// so "deny 10.0.0.0/8, allow 10.1.0.0/16" admits only 10.1.0.0/16 out of
+// 10.0.0.0/8. An address matched by neither is allowed unless there is an
+// allow list.
+type Rules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// ruleSet is the parsed form of Rules.
+type ruleSet struct {
+	rules       Rules
+	allow, deny []netip.Prefix
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		var prefix netip.Prefix
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, err
+			}
+			prefix = p.Masked()
+		} else {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() {
+			return nil, fmt.Errorf("%s: use the IPv4 form", v)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func compile(rules Rules) (*ruleSet, error) {
+	allow, err := parsePrefixes(rules.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow entry: %w", err)
+	}
+	deny, err := parsePrefixes(rules.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny entry: %w", err)
+	}
+	return &ruleSet{rules: rules, allow: allow, deny: deny}, nil
+}
+
+// longestMatch returns the prefix length of the most specific entry
+// containing ip, or -1 if none does.
+func longestMatch(prefixes []netip.Prefix, ip netip.Addr) int {
+	best := -1
+	for _, p := range prefixes {
+		if p.Bits() > best && p.Contains(ip) {
+			best = p.Bits()
+		}
+	}
+	return best
+}
+
+func (s *ruleSet) allows(ip netip.Addr) bool {
+	if s == nil {
+		return true
+	}
+	ip = ip.Unmap()
+	allow, deny := longestMatch(s.allow, ip), longestMatch(s.deny, ip)
+	if deny >= 0 && deny >= allow {
+		return false
+	}
+	return allow >= 0 || len(s.allow) == 0
+}
+
+// Policy holds the global rules, checked for every new connection before its
+// Initial is decrypted, and per-route rules keyed by FQDN pattern, checked
+// once the SNI is known.
+type Policy struct {
+	mu     sync.RWMutex
+	global *ruleSet // nil allows everything
+
+	routes *strategy.PatternTable[*ruleSet]
+}
+
+func NewPolicy() *Policy {
+	return &Policy{routes: strategy.NewPatternTable[*ruleSet]()}
+}
+
+// FromConfig builds a policy from the acl section and the allow and deny
+// lists of the configured routes.
+func FromConfig(cfg *config.Config) (*Policy, error) {
+	p := NewPolicy()
+	if err := p.SetGlobal(Rules{Allow: cfg.ACL.Allow, Deny: cfg.ACL.Deny}); err != nil {
+		return nil, fmt.Errorf("acl: %w", err)
+	}
+	for _, route := range cfg.Routes {
+		if len(route.Allow) == 0 && len(route.Deny) == 0 {
+			continue
+		}
+		if err := p.SetRoute(route.FQDN, Rules{Allow: route.Allow, Deny: route.Deny}); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.FQDN, err)
+		}
+	}
+	return p, nil
+}
+
+// SetGlobal replaces the global rules.
+func (p *Policy) SetGlobal(rules Rules) error {
+	set, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.global = set
+	return nil
+}
+
+// Global returns the global rules.
+func (p *Policy) Global() Rules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.global == nil {
+		return Rules{}
+	}
+	return p.global.rules
+}
+
+// SetRoute replaces the rules of an FQDN pattern.
+func (p *Policy) SetRoute(fqdn string, rules Rules) error {
+	if err := strategy.ValidatePattern(fqdn); err != nil {
+		return err
+	}
+	set, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	p.routes.Set(fqdn, set)
+	return nil
+}
+
+// RemoveRoute deletes the rules of an FQDN pattern and reports whether they
+// existed.
+func (p *Policy) RemoveRoute(fqdn string) bool {
+	return p.routes.Delete(fqdn)
+}
+
+// Routes returns a snapshot of the per-route rules.
+func (p *Policy) Routes() map[string]Rules {
+	entries := p.routes.Entries()
+	routes := make(map[string]Rules, len(entries))
+	for fqdn, set := range entries {
+		routes[fqdn] = set.rules
+	}
+	return routes
+}
+
+// AllowGlobal reports whether the global rules admit a new connection from ip.
+func (p *Policy) AllowGlobal(ip netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.global.allows(ip)
+}
+
+// AllowRoute reports whether the rules of the most specific pattern matching
+// sni admit a new connection from ip.
+func (p *Policy) AllowRoute(sni string, ip netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+	set, _, ok := p.routes.Match(sni)
+	return !ok || set.allows(ip)
+}
@@ -0,0 +1,92 @@
+package acl
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/ewancrowle/porter/internal/config"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		ip    string
+		want  bool
+	}{
+		{"empty", Rules{}, "192.0.2.1", true},
+		{"denied", Rules{Deny: []string{"192.0.2.0/24"}}, "192.0.2.1", false},
+		{"not denied", Rules{Deny: []string{"192.0.2.0/24"}}, "198.51.100.1", true},
+		{"allowed", Rules{Allow: []string{"10.0.0.0/8"}}, "10.1.2.3", true},
+		{"not allowed", Rules{Allow: []string{"10.0.0.0/8"}}, "192.0.2.1", false},
+		{"single IP", Rules{Allow: []string{"192.0.2.7"}}, "192.0.2.7", true},
+		{"mapped IPv4", Rules{Deny: []string{"192.0.2.0/24"}}, "::ffff:192.0.2.1", false},
+		{"IPv6", Rules{Allow: []string{"2001:db8::/32"}}, "2001:db8::1", true},
+		{"specific allow in deny", Rules{Allow: []string{"10.1.0.0/16"}, Deny: []string{"10.0.0.0/8"}}, "10.1.2.3", true},
+		{"outside specific allow", Rules{Allow: []string{"10.1.0.0/16"}, Deny: []string{"10.0.0.0/8"}}, "10.2.0.1", false},
+		{"specific deny in allow", Rules{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}}, "10.1.2.3", false},
+		{"tie", Rules{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/8"}}, "10.1.2.3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := compile(tt.rules)
+			if err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			if got := set.allows(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("allows(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "::ffff:10.0.0.0/104"} {
+		if _, err := compile(Rules{Deny: []string{bad}}); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.ACL.Deny = []string{"203.0.113.0/24"}
+	cfg.Routes = []config.Route{
+		{FQDN: "staging.example.com", Allow: []string{"10.0.0.0/8"}},
+		{FQDN: "game.example.com"},
+	}
+	p, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatalf("FromConfig() error = %v", err)
+	}
+
+	office := netip.MustParseAddr("10.1.2.3")
+	public := netip.MustParseAddr("198.51.100.1")
+	blocked := netip.MustParseAddr("203.0.113.9")
+
+	if p.AllowGlobal(blocked) || !p.AllowGlobal(public) {
+		t.Error("Unexpected global decision")
+	}
+	if !p.AllowRoute("staging.example.com", office) || p.AllowRoute("staging.example.com", public) {
+		t.Error("Expected staging to admit only the office range")
+	}
+	if !p.AllowRoute("game.example.com", public) {
+		t.Error("Expected routes without rules to admit everyone")
+	}
+	if len(p.Routes()) != 1 {
+		t.Errorf("Routes() = %v, want only staging", p.Routes())
+	}
+
+	if err := p.SetRoute("*.example.com", Rules{Deny: []string{"0.0.0.0/0"}}); err != nil {
+		t.Fatal(err)
+	}
+	if p.AllowRoute("other.example.com", office) || !p.AllowRoute("staging.example.com", office) {
+		t.Error("Expected the most specific pattern's rules to apply")
+	}
+	if !p.RemoveRoute("*.example.com") || !p.AllowRoute("other.example.com", office) {
+		t.Error("Expected removing the rules to admit everyone again")
+	}
+
+	var nilPolicy *Policy
+	if !nilPolicy.AllowGlobal(blocked) || !nilPolicy.AllowRoute("x", blocked) {
+		t.Error("Expected a nil policy to allow everything")
+	}
+}
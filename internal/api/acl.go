@@ -0,0 +1,81 @@
+package api
+
+import (
+	"github.com/ewancrowle/porter/internal/acl"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/gofiber/fiber/v2"
+)
+
+type aclResponse struct {
+	Global acl.Rules            `json:"global"`
+	Routes map[string]acl.Rules `json:"routes"`
+}
+
+func (s *Server) handleGetACL(c *fiber.Ctx) error {
+	if s.acl == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Access control is disabled"})
+	}
+	return c.JSON(aclResponse{Global: s.acl.Global(), Routes: s.acl.Routes()})
+}
+
+func (s *Server) handleSetGlobalACL(c *fiber.Ctx) error {
+	if s.acl == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Access control is disabled"})
+	}
+
+	var rules acl.Rules
+	if err := c.BodyParser(&rules); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := s.acl.SetGlobal(rules); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Publish to Redis for sync
+	if s.sync != nil {
+		if err := s.sync.PublishACL(c.Context(), "", rules); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to sync access rules"})
+		}
+	}
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+func (s *Server) handleSetRouteACL(c *fiber.Ctx) error {
+	if s.acl == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Access control is disabled"})
+	}
+
+	fqdn := strategy.NormalizePattern(c.Params("fqdn"))
+	var rules acl.Rules
+	if err := c.BodyParser(&rules); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := s.acl.SetRoute(fqdn, rules); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if s.sync != nil {
+		if err := s.sync.PublishACL(c.Context(), fqdn, rules); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to sync access rules"})
+		}
+	}
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+func (s *Server) handleDeleteRouteACL(c *fiber.Ctx) error {
+	if s.acl == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Access control is disabled"})
+	}
+
+	fqdn := strategy.NormalizePattern(c.Params("fqdn"))
+	if !s.acl.RemoveRoute(fqdn) {
+		return c.Status(404).JSON(fiber.Map{"error": "Access rules not found"})
+	}
+
+	if s.sync != nil {
+		if err := s.sync.PublishACLDelete(c.Context(), fqdn); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to sync access rules deletion"})
+		}
+	}
+	return c.JSON(fiber.Map{"status": "ok"})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ewancrowle/porter/internal/acl"
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+func TestACLEndpoints(t *testing.T) {
+	s := NewServer(&config.Config{}, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+	policy := acl.NewPolicy()
+	s.SetAccessPolicy(policy)
+
+	request := func(method, path, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := request("PUT", "/acl/routes/staging.example.com", `{"allow":["10.0.0.0/8"]}`); code != 200 {
+		t.Fatalf("Expected status 200 setting route rules, got %d", code)
+	}
+	if code := request("PUT", "/acl/global", `{"deny":["not-a-cidr"]}`); code != 400 {
+		t.Errorf("Expected status 400 for an invalid CIDR, got %d", code)
+	}
+	if code := request("PUT", "/acl/global", `{"deny":["203.0.113.0/24"]}`); code != 200 {
+		t.Fatalf("Expected status 200 setting global rules, got %d", code)
+	}
+
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/acl", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var body aclResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Global.Deny) != 1 || len(body.Routes["staging.example.com"].Allow) != 1 {
+		t.Errorf("Unexpected policy %+v", body)
+	}
+
+	if code := request("DELETE", "/acl/routes/staging.example.com", ""); code != 200 {
+		t.Errorf("Expected status 200 deleting route rules, got %d", code)
+	}
+	if code := request("DELETE", "/acl/routes/staging.example.com", ""); code != 404 {
+		t.Errorf("Expected status 404 deleting missing rules, got %d", code)
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Scope string
+
+const (
+	ScopeRoutesRead  Scope = "routes:read"
+	ScopeRoutesWrite Scope = "routes:write"
+	ScopeAllocate    Scope = "allocate"
+	ScopeMetricsRead Scope = "metrics:read"
+	ScopeACLRead     Scope = "acl:read"
+	ScopeACLWrite    Scope = "acl:write"
+)
+
+// Principal is an authenticated API client and the scopes it was granted.
+type Principal struct {
+	Name   string
+	Scopes []Scope
+}
+
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the client making a request. It returns a nil
+// Principal if the request carries no credentials it understands, and an
+// error if it carries credentials that are invalid.
+type Authenticator interface {
+	Authenticate(c *fiber.Ctx) (*Principal, error)
+}
+
+func toScopes(values []string) []Scope {
+	scopes := make([]Scope, 0, len(values))
+	for _, v := range values {
+		scopes = append(scopes, Scope(v))
+	}
+	return scopes
+}
+
+// TokenAuthenticator accepts static bearer tokens from the Authorization header.
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]*Principal
+}
+
+func NewTokenAuthenticator() *TokenAuthenticator {
+	return &TokenAuthenticator{
+		tokens: make(map[[sha256.Size]byte]*Principal),
+	}
+}
+
+func (a *TokenAuthenticator) AddToken(token string, principal *Principal) {
+	a.tokens[sha256.Sum256([]byte(token))] = principal
+}
+
+func (a *TokenAuthenticator) Authenticate(c *fiber.Ctx) (*Principal, error) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if header == "" {
+		return nil, nil
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, errors.New("unsupported authorization scheme")
+	}
+
+	// Compare digests in constant time so lookups do not leak token prefixes.
+	sum := sha256.Sum256([]byte(token))
+	var match *Principal
+	for digest, principal := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], sum[:]) == 1 {
+			match = principal
+		}
+	}
+	if match == nil {
+		return nil, errors.New("invalid token")
+	}
+	return match, nil
+}
+
+// CertAuthenticator maps verified TLS client certificates to principals by
+// their subject common name.
+type CertAuthenticator struct {
+	clients map[string]*Principal
+}
+
+func NewCertAuthenticator() *CertAuthenticator {
+	return &CertAuthenticator{
+		clients: make(map[string]*Principal),
+	}
+}
+
+func (a *CertAuthenticator) AddClient(commonName string, principal *Principal) {
+	a.clients[commonName] = principal
+}
+
+// Authenticate returns the principal of a verified client certificate, or nil
+// if there is none or its common name is not listed.
+func (a *CertAuthenticator) Authenticate(c *fiber.Ctx) (*Principal, error) {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	// Certificates this authenticator does not know may still present other
+	// credentials, such as a bearer token.
+	return a.clients[state.VerifiedChains[0][0].Subject.CommonName], nil
+}
+
+// checkAuthConfig rejects credentials that would be ignored because
+// authentication is disabled, leaving every endpoint open.
+func checkAuthConfig(cfg *config.Config) error {
+	if !cfg.API.Auth.Enabled && (len(cfg.API.Auth.Tokens) > 0 || len(cfg.API.TLS.Clients) > 0) {
+		return errors.New("api.auth.tokens and api.tls.clients require api.auth.enabled")
+	}
+	return nil
+}
+
+// newAuthenticators builds the configured authenticators, in the order they
+// are consulted.
+func newAuthenticators(cfg *config.Config) []Authenticator {
+	var authenticators []Authenticator
+
+	if len(cfg.API.TLS.Clients) > 0 {
+		certs := NewCertAuthenticator()
+		for _, client := range cfg.API.TLS.Clients {
+			certs.AddClient(client.CommonName, &Principal{Name: client.CommonName, Scopes: toScopes(client.Scopes)})
+		}
+		authenticators = append(authenticators, certs)
+	}
+
+	if len(cfg.API.Auth.Tokens) > 0 {
+		tokens := NewTokenAuthenticator()
+		for _, t := range cfg.API.Auth.Tokens {
+			tokens.AddToken(t.Token, &Principal{Name: t.Name, Scopes: toScopes(t.Scopes)})
+		}
+		authenticators = append(authenticators, tokens)
+	}
+
+	return authenticators
+}
+
+// require returns middleware that rejects requests whose principal lacks the
+// given scope. It allows everything when authentication is disabled.
+func (s *Server) require(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !s.cfg.API.Auth.Enabled {
+			return c.Next()
+		}
+
+		for _, authenticator := range s.authenticators {
+			principal, err := authenticator.Authenticate(c)
+			if err != nil {
+				return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+			}
+			if principal == nil {
+				continue
+			}
+			if !principal.HasScope(scope) {
+				return c.Status(403).JSON(fiber.Map{"error": fmt.Sprintf("Missing scope %s", scope)})
+			}
+			c.Locals("principal", principal)
+			return c.Next()
+		}
+
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+}
+
+// tlsConfig builds the server TLS configuration, verifying client
+// certificates against the configured CA when one is set.
+func tlsConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.API.TLS.CertFile, cfg.API.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API certificate: %w", err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.API.TLS.ClientCAFile != "" {
+		caBytes, err := os.ReadFile(cfg.API.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.API.TLS.RequireClientCert {
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsCfg, nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/valyala/fasthttp"
+)
+
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.API.Auth.Enabled = true
+	cfg.API.Auth.Tokens = []config.APIToken{
+		{Name: "reader", Token: "read-token", Scopes: []string{"routes:read"}},
+		{Name: "admin", Token: "admin-token", Scopes: []string{"routes:read", "routes:write"}},
+	}
+
+	return NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+}
+
+func TestAuthScopes(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no token", "GET", "/routes", "", 401},
+		{"invalid token", "GET", "/routes", "wrong", 401},
+		{"read allowed", "GET", "/routes", "read-token", 200},
+		{"write denied for reader", "POST", "/routes", "read-token", 403},
+		{"write allowed for admin", "POST", "/routes", "admin-token", 200},
+		{"allocate denied for admin", "POST", "/allocate", "admin-token", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"fqdn":"a.example.com","type":"simple","target":"1.2.3.4:5000"}`)
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := s.app.Test(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	s := NewServer(&config.Config{}, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/routes", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200 with auth disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthConfigRequiresEnabled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.TLS.Clients = []config.APIClient{{CommonName: "lobby-server", Scopes: []string{"allocate"}}}
+	s := NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+	if err := s.Start(); err == nil {
+		t.Error("Expected client certificates without api.auth.enabled to be rejected")
+	}
+
+	cfg = &config.Config{}
+	cfg.API.Auth.Tokens = []config.APIToken{{Name: "ops", Token: "t", Scopes: []string{"routes:read"}}}
+	if err := checkAuthConfig(cfg); err == nil {
+		t.Error("Expected tokens without api.auth.enabled to be rejected")
+	}
+}
+
+// tlsConn is a connection whose TLS handshake verified a client certificate.
+type tlsConn struct {
+	net.Conn
+	state tls.ConnectionState
+}
+
+func (c *tlsConn) Handshake() error                     { return nil }
+func (c *tlsConn) ConnectionState() tls.ConnectionState { return c.state }
+
+func TestAuthClientCertificates(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.Auth.Enabled = true
+	cfg.API.Auth.Tokens = []config.APIToken{{Name: "reader", Token: "read-token", Scopes: []string{"routes:read"}}}
+	cfg.API.TLS.Clients = []config.APIClient{
+		{CommonName: "dashboard", Scopes: []string{"routes:read"}},
+		{CommonName: "lobby-server", Scopes: []string{"allocate"}},
+	}
+	s := NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+	handler := s.app.Handler()
+
+	tests := []struct {
+		name       string
+		commonName string
+		token      string
+		want       int
+	}{
+		{"known client", "dashboard", "", 200},
+		{"unknown client", "stranger", "", 401},
+		{"unknown client with token", "stranger", "read-token", 200},
+		{"client lacking scope", "lobby-server", "", 403},
+		{"unverified connection with token", "", "read-token", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			conn := &tlsConn{Conn: server}
+			if tt.commonName != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.commonName}}
+				conn.state.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+
+			var ctx fasthttp.RequestCtx
+			ctx.Init2(conn, nil, false)
+			ctx.Request.Header.SetMethod("GET")
+			ctx.Request.SetRequestURI("/routes")
+			if tt.token != "" {
+				ctx.Request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			handler(&ctx)
+			if got := ctx.Response.StatusCode(); got != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
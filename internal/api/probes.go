@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkTimeout bounds each readiness check so a hung dependency fails the
+// probe instead of stalling it.
+const checkTimeout = 2 * time.Second
+
+// checkResult is the outcome of one readiness check.
+type checkResult struct {
+	Status string `json:"status"` // "pass" or "fail"
+	Error  string `json:"error,omitempty"`
+}
+
+// handleHealth is the liveness probe. It only shows that the process is
+// responsive; dependency failures belong in readiness, where they take the
+// instance out of rotation instead of restarting it.
+func (s *Server) handleHealth(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// handleReady reports whether this instance should receive new connections,
+// along with every readiness check. It fails while the relay is starting up or
+// draining, so load balancers stop sending new players here while existing
+// sessions finish.
+func (s *Server) handleReady(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	checks := make(map[string]checkResult)
+	ready := true
+	add := func(name string, err error) {
+		if err != nil {
+			checks[name] = checkResult{Status: "fail", Error: err.Error()}
+			ready = false
+			return
+		}
+		checks[name] = checkResult{Status: "pass"}
+	}
+
+	resp := fiber.Map{"checks": checks}
+	draining := false
+	if s.relay != nil {
+		status := s.relay.Status()
+		resp["relay"] = status
+
+		var err error
+		switch {
+		case status.Draining:
+			err = errors.New("relay is draining")
+			draining = true
+		case !status.Listening:
+			err = errors.New("UDP listener is not bound")
+		}
+		add("udp_listener", err)
+	}
+	if s.sync != nil {
+		add("redis", s.sync.Check(ctx))
+	}
+	if s.cfg.Agones.Enabled {
+		add("agones", s.agones.Check())
+	}
+
+	var err error
+	if s.sync != nil && !s.sync.RoutesLoaded() {
+		err = errors.New("initial route load has not finished")
+	}
+	add("routes", err)
+
+	switch {
+	case draining:
+		resp["status"] = "draining"
+	case !ready:
+		resp["status"] = "not_ready"
+	default:
+		resp["status"] = "ready"
+		return c.JSON(resp)
+	}
+	return c.Status(503).JSON(resp)
+}
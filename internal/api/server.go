@@ -1,24 +1,50 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"log"
+	"sort"
 
+	"github.com/ewancrowle/porter/internal/acl"
 	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/health"
+	"github.com/ewancrowle/porter/internal/relay"
 	"github.com/ewancrowle/porter/internal/strategy"
 	"github.com/ewancrowle/porter/internal/sync"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// routeSync persists route and access policy changes and publishes them to
+// the other instances. It is implemented by sync.RedisSync.
+type routeSync interface {
+	PublishUpdate(ctx context.Context, route strategy.Route) error
+	PublishDelete(ctx context.Context, route strategy.Route) error
+	PublishACL(ctx context.Context, fqdn string, rules acl.Rules) error
+	PublishACLDelete(ctx context.Context, fqdn string) error
+	Check(ctx context.Context) error
+	RoutesLoaded() bool
+}
+
 type Server struct {
 	app    *fiber.App
 	cfg    *config.Config
 	simple *strategy.SimpleStrategy
 	agones *strategy.AgonesStrategy
-	sync   *sync.RedisSync
+	geoip  *strategy.GeoIPStrategy
+	sync   routeSync // nil unless Redis is enabled
+	health *health.Checker
+	relay  *relay.Relay
+	acl    *acl.Policy
+
+	authenticators []Authenticator
 }
 
-func NewServer(cfg *config.Config, simple *strategy.SimpleStrategy, agones *strategy.AgonesStrategy, redisSync *sync.RedisSync) *Server {
+func NewServer(cfg *config.Config, simple *strategy.SimpleStrategy, agones *strategy.AgonesStrategy, redisSync *sync.RedisSync, checker *health.Checker) *Server {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 	})
@@ -32,20 +58,79 @@ func NewServer(cfg *config.Config, simple *strategy.SimpleStrategy, agones *stra
 		cfg:    cfg,
 		simple: simple,
 		agones: agones,
-		sync:   redisSync,
+		health: checker,
+
+		authenticators: newAuthenticators(cfg),
+	}
+	if redisSync != nil {
+		s.sync = redisSync
+	}
+
+	if cfg.API.Auth.Enabled && len(s.authenticators) == 0 {
+		log.Printf("Warning: API authentication is enabled but no tokens or client certificates are configured")
 	}
 
 	s.setupRoutes()
 	return s
 }
 
+// SetRelay makes /readyz report the relay's listening and draining state.
+func (s *Server) SetRelay(r *relay.Relay) {
+	s.relay = r
+}
+
+// SetAccessPolicy enables the /acl endpoints for viewing and changing the
+// access policy.
+func (s *Server) SetAccessPolicy(policy *acl.Policy) {
+	s.acl = policy
+}
+
+// SetGeoIPStrategy lets the routes endpoints manage geoip routes.
+func (s *Server) SetGeoIPStrategy(geoip *strategy.GeoIPStrategy) {
+	s.geoip = geoip
+}
+
 func (s *Server) setupRoutes() {
-	s.app.Post("/routes", s.handleUpdateRoute)
-	s.app.Post("/allocate", s.handleAgonesAllocation)
+	s.app.Get("/healthz", s.handleHealth)
+	s.app.Get("/readyz", s.handleReady)
+	s.app.Get("/routes", s.require(ScopeRoutesRead), s.handleListRoutes)
+	s.app.Get("/routes/:fqdn", s.require(ScopeRoutesRead), s.handleGetRoute)
+	s.app.Post("/routes", s.require(ScopeRoutesWrite), s.handleUpdateRoute)
+	s.app.Delete("/routes/:fqdn", s.require(ScopeRoutesWrite), s.handleDeleteRoute)
+	s.app.Get("/backends", s.require(ScopeRoutesRead), s.handleListBackends)
+	s.app.Get("/acl", s.require(ScopeACLRead), s.handleGetACL)
+	s.app.Put("/acl/global", s.require(ScopeACLWrite), s.handleSetGlobalACL)
+	s.app.Put("/acl/routes/:fqdn", s.require(ScopeACLWrite), s.handleSetRouteACL)
+	s.app.Delete("/acl/routes/:fqdn", s.require(ScopeACLWrite), s.handleDeleteRouteACL)
+	s.app.Post("/allocate", s.require(ScopeAllocate), s.handleAgonesAllocation)
+	s.app.Get("/metrics", s.require(ScopeMetricsRead), adaptor.HTTPHandler(promhttp.Handler()))
 }
 
 func (s *Server) Start() error {
-	return s.app.Listen(fmt.Sprintf(":%d", s.cfg.API.Port))
+	if err := checkAuthConfig(s.cfg); err != nil {
+		return err
+	}
+
+	addr := fmt.Sprintf(":%d", s.cfg.API.Port)
+	if !s.cfg.API.TLS.Enabled {
+		return s.app.Listen(addr)
+	}
+
+	tlsCfg, err := tlsConfig(s.cfg)
+	if err != nil {
+		return err
+	}
+	ln, err := tls.Listen("tcp", addr, tlsCfg)
+	if err != nil {
+		return err
+	}
+	return s.app.Listener(ln)
+}
+
+// Shutdown stops accepting API requests and waits for in-flight requests to
+// finish or the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.app.ShutdownWithContext(ctx)
 }
 
 func (s *Server) handleUpdateRoute(c *fiber.Ctx) error {
@@ -53,14 +138,25 @@ func (s *Server) handleUpdateRoute(c *fiber.Ctx) error {
 	if err := c.BodyParser(&route); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	// Store the same form the route tables and deletes use.
+	route.FQDN = strategy.NormalizePattern(route.FQDN)
 
 	if route.Type == strategy.StrategySimple {
-		s.simple.UpdateRoute(route.FQDN, route.Target)
+		if err := s.simple.SetRoute(route); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 	} else if route.Type == strategy.StrategyAgones {
 		if !s.cfg.Agones.Enabled {
 			return c.Status(400).JSON(fiber.Map{"error": "Agones is disabled"})
 		}
 		s.agones.UpdateRoute(route.FQDN, route.Target)
+	} else if route.Type == strategy.StrategyGeoIP {
+		if s.geoip == nil || !s.cfg.GeoIP.Enabled {
+			return c.Status(400).JSON(fiber.Map{"error": "GeoIP is disabled"})
+		}
+		if err := s.geoip.SetRoute(route); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 	} else {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid strategy type"})
 	}
@@ -75,6 +171,87 @@ func (s *Server) handleUpdateRoute(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"status": "ok"})
 }
 
+// routes returns every route known to this instance, ordered by FQDN and
+// then by strategy type.
+func (s *Server) routes() []strategy.Route {
+	routes := s.simple.Routes()
+	routes = append(routes, s.agones.Routes()...)
+	if s.geoip != nil {
+		routes = append(routes, s.geoip.Routes()...)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].FQDN != routes[j].FQDN {
+			return routes[i].FQDN < routes[j].FQDN
+		}
+		return routes[i].Type < routes[j].Type
+	})
+	return routes
+}
+
+func (s *Server) handleListRoutes(c *fiber.Ctx) error {
+	return c.JSON(s.routes())
+}
+
+// handleListBackends reports the health check state of every routed target.
+func (s *Server) handleListBackends(c *fiber.Ctx) error {
+	if s.health == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Health checking is disabled"})
+	}
+	return c.JSON(s.health.Statuses())
+}
+
+func (s *Server) handleGetRoute(c *fiber.Ctx) error {
+	fqdn := strategy.NormalizePattern(c.Params("fqdn"))
+
+	var matches []strategy.Route
+	for _, route := range s.routes() {
+		if strategy.NormalizePattern(route.FQDN) == fqdn {
+			matches = append(matches, route)
+		}
+	}
+
+	if len(matches) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Route not found"})
+	}
+	return c.JSON(matches)
+}
+
+func (s *Server) handleDeleteRoute(c *fiber.Ctx) error {
+	fqdn := strategy.NormalizePattern(c.Params("fqdn"))
+	routeType := strategy.StrategyType(c.Query("type"))
+
+	var removed []strategy.Route
+	for _, route := range s.routes() {
+		if strategy.NormalizePattern(route.FQDN) != fqdn || (routeType != "" && route.Type != routeType) {
+			continue
+		}
+
+		if route.Type == strategy.StrategySimple {
+			s.simple.RemoveRoute(route.FQDN)
+		} else if route.Type == strategy.StrategyAgones {
+			s.agones.RemoveRoute(route.FQDN)
+		} else if route.Type == strategy.StrategyGeoIP {
+			s.geoip.RemoveRoute(route.FQDN)
+		}
+		removed = append(removed, route)
+	}
+
+	if len(removed) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Route not found"})
+	}
+
+	// Publish to Redis for sync
+	if s.sync != nil {
+		for _, route := range removed {
+			if err := s.sync.PublishDelete(c.Context(), route); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to sync route deletion"})
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{"status": "ok", "removed": removed})
+}
+
 func (s *Server) handleAgonesAllocation(c *fiber.Ctx) error {
 	if !s.cfg.Agones.Enabled {
 		return c.Status(400).JSON(fiber.Map{"error": "Agones is disabled"})
@@ -99,7 +276,7 @@ func (s *Server) handleAgonesAllocation(c *fiber.Ctx) error {
 	}
 
 	// Create an FQDN for the game server
-	fqdn := fmt.Sprintf("%s.%s", gsName, req.Domain)
+	fqdn := strategy.NormalizePattern(fmt.Sprintf("%s.%s", gsName, req.Domain))
 
 	// Update simple strategy with the new route
 	s.simple.UpdateRoute(fqdn, target)
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ewancrowle/porter/internal/acl"
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/relay"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func getReady(t *testing.T, s *Server) (int, readyResponse) {
+	t.Helper()
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var body readyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestReady(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.Auth.Enabled = true
+	s := NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+
+	code, body := getReady(t, s)
+	if code != 200 || body.Status != "ready" || body.Checks["routes"].Status != "pass" {
+		t.Errorf("Got %d %+v without a relay, want 200 ready", code, body)
+	}
+
+	engine, err := relay.NewRelay(cfg, strategy.NewStrategyManager())
+	if err != nil {
+		t.Fatalf("NewRelay() error = %v", err)
+	}
+	s.SetRelay(engine)
+
+	// The relay has not started listening.
+	code, body = getReady(t, s)
+	if check := body.Checks["udp_listener"]; code != 503 || body.Status != "not_ready" || check.Status != "fail" || check.Error == "" {
+		t.Errorf("Got %d %+v before listening, want 503 not_ready with a failing udp_listener", code, body)
+	}
+
+	if err := engine.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if code, body = getReady(t, s); code != 503 || body.Status != "draining" {
+		t.Errorf("Got %d %+v while draining, want 503 draining", code, body)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := NewServer(&config.Config{}, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/healthz", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestUpdateGeoIPRoute(t *testing.T) {
+	cfg := &config.Config{}
+	s := NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+	s.SetGeoIPStrategy(strategy.NewGeoIPStrategy())
+
+	post := func(body string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/routes", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	route := `{"fqdn":"play.example.com","type":"geoip","target":"10.0.0.5:7777","geo":{"continents":{"EU":"10.0.1.5:7777"},"asns":{"64500":"10.0.2.5:7777"}}}`
+	if code := post(route); code != 400 {
+		t.Errorf("Expected status 400 with GeoIP disabled, got %d", code)
+	}
+
+	cfg.GeoIP.Enabled = true
+	if code := post(route); code != 200 {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if code := post(`{"fqdn":"bad.example.com","type":"geoip","geo":{"continents":{"XX":"10.0.1.5:7777"}}}`); code != 400 {
+		t.Errorf("Expected status 400 for an unknown continent, got %d", code)
+	}
+
+	routes := s.routes()
+	if len(routes) != 1 || routes[0].Geo == nil || routes[0].Geo.ASNs[64500] != "10.0.2.5:7777" {
+		t.Errorf("routes() = %+v, want the geoip route", routes)
+	}
+}
+
+func TestUpdateRouteNormalizesFQDN(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Agones.Enabled = true
+	s := NewServer(cfg, strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy(), nil, nil)
+
+	for _, body := range []string{
+		`{"fqdn":"Game.Example.com.","type":"simple","target":"10.0.0.5:7777"}`,
+		`{"fqdn":"Match.Example.com","type":"agones","target":"fleet"}`,
+	} {
+		req := httptest.NewRequest("POST", "/routes", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if resp, err := s.app.Test(req); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("POST %s failed: %v", body, err)
+		}
+	}
+
+	routes := s.routes()
+	if len(routes) != 2 || routes[0].FQDN != "game.example.com" || routes[1].FQDN != "match.example.com" {
+		t.Errorf("routes() = %+v, want normalized FQDNs", routes)
+	}
+
+	resp, err := s.app.Test(httptest.NewRequest("DELETE", "/routes/MATCH.example.com", nil))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	if routes := s.routes(); len(routes) != 1 {
+		t.Errorf("routes() after delete = %+v", routes)
+	}
+}
+
+// stubSync records the route changes the server publishes.
+type stubSync struct {
+	updates []strategy.Route
+	deletes []strategy.Route
+}
+
+func (s *stubSync) PublishUpdate(ctx context.Context, route strategy.Route) error {
+	s.updates = append(s.updates, route)
+	return nil
+}
+
+func (s *stubSync) PublishDelete(ctx context.Context, route strategy.Route) error {
+	s.deletes = append(s.deletes, route)
+	return nil
+}
+
+func (s *stubSync) PublishACL(ctx context.Context, fqdn string, rules acl.Rules) error { return nil }
+func (s *stubSync) PublishACLDelete(ctx context.Context, fqdn string) error            { return nil }
+func (s *stubSync) Check(ctx context.Context) error                                    { return nil }
+func (s *stubSync) RoutesLoaded() bool                                                 { return true }
+
+func TestRouteEndpoints(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Agones.Enabled = true
+	simple, agones := strategy.NewSimpleStrategy(), strategy.NewAgonesStrategy()
+	simple.UpdateRoute("game.example.com", "10.0.0.5:7777")
+	simple.UpdateRoute("lobby.example.com", "10.0.0.6:7777")
+	agones.UpdateRoute("game.example.com", "fleet")
+	s := NewServer(cfg, simple, agones, nil, nil)
+	stub := &stubSync{}
+	s.sync = stub
+
+	get := func(path string) (int, []strategy.Route) {
+		t.Helper()
+		resp, err := s.app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		var routes []strategy.Route
+		if resp.StatusCode == 200 {
+			if err := json.NewDecoder(resp.Body).Decode(&routes); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, routes
+	}
+	del := func(path string) int {
+		t.Helper()
+		resp, err := s.app.Test(httptest.NewRequest("DELETE", path, nil))
+		if err != nil {
+			t.Fatalf("DELETE %s failed: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	code, routes := get("/routes")
+	if code != 200 || len(routes) != 3 ||
+		routes[0].FQDN != "game.example.com" || routes[0].Type != strategy.StrategyAgones ||
+		routes[1].FQDN != "game.example.com" || routes[1].Type != strategy.StrategySimple ||
+		routes[2].FQDN != "lobby.example.com" {
+		t.Errorf("GET /routes = %d, %+v, want both game routes, then lobby", code, routes)
+	}
+
+	code, routes = get("/routes/Game.Example.com")
+	if code != 200 || len(routes) != 2 {
+		t.Errorf("GET /routes/Game.Example.com = %d, %+v, want both game routes", code, routes)
+	}
+	if code, _ := get("/routes/unknown.example.com"); code != 404 {
+		t.Errorf("GET of an unknown route = %d, want 404", code)
+	}
+
+	if code := del("/routes/unknown.example.com"); code != 404 {
+		t.Errorf("DELETE of an unknown route = %d, want 404", code)
+	}
+	if len(stub.deletes) != 0 {
+		t.Errorf("Published %+v for an unknown route", stub.deletes)
+	}
+
+	if code := del("/routes/game.example.com?type=agones"); code != 200 {
+		t.Fatalf("DELETE of the agones route = %d, want 200", code)
+	}
+	if len(agones.Routes()) != 0 {
+		t.Errorf("Agones routes after delete = %+v", agones.Routes())
+	}
+	if len(stub.deletes) != 1 || stub.deletes[0].Type != strategy.StrategyAgones || stub.deletes[0].FQDN != "game.example.com" {
+		t.Errorf("Published deletes = %+v, want the agones route", stub.deletes)
+	}
+
+	if code := del("/routes/game.example.com"); code != 200 {
+		t.Fatalf("DELETE of the simple route = %d, want 200", code)
+	}
+	if len(stub.deletes) != 2 || stub.deletes[1].Type != strategy.StrategySimple {
+		t.Errorf("Published deletes = %+v, want the simple route too", stub.deletes)
+	}
+	if code, routes := get("/routes"); code != 200 || len(routes) != 1 || routes[0].FQDN != "lobby.example.com" {
+		t.Errorf("GET /routes after delete = %d, %+v, want only lobby", code, routes)
+	}
+	if code := del("/routes/game.example.com"); code != 404 {
+		t.Errorf("Second DELETE = %d, want 404", code)
+	}
+}
@@ -1,24 +1,97 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	UDP struct {
-		Port        int  `mapstructure:"port"`
-		LogRequests bool `mapstructure:"log_requests"`
+		Port         int           `mapstructure:"port"`
+		LogRequests  bool          `mapstructure:"log_requests"`
+		IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
+		ReapInterval time.Duration `mapstructure:"reap_interval"`
+		CIDLength    int           `mapstructure:"cid_length"`
+
+		HandshakeTimeout    time.Duration `mapstructure:"handshake_timeout"`
+		HandshakeBufferSize int           `mapstructure:"handshake_buffer_size"`
+		MaxPendingBytes     int64         `mapstructure:"max_pending_bytes"`
+
+		MaxDatagramSize int `mapstructure:"max_datagram_size"`
+
+		Listeners int  `mapstructure:"listeners"`
+		Workers   int  `mapstructure:"workers"`
+		QueueSize int  `mapstructure:"queue_size"`
+		BatchSize int  `mapstructure:"batch_size"`
+		GSO       bool `mapstructure:"gso"`
+		GRO       bool `mapstructure:"gro"`
+
+		DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+
+		RateLimit struct {
+			PerIP     RateLimit `mapstructure:"per_ip"`
+			PerPrefix struct {
+				RateLimit  `mapstructure:",squash"`
+				IPv4Prefix int `mapstructure:"ipv4_prefix"`
+				IPv6Prefix int `mapstructure:"ipv6_prefix"`
+			} `mapstructure:"per_prefix"`
+			Global            RateLimit `mapstructure:"global"`
+			MaxSessionsPerIP  int       `mapstructure:"max_sessions_per_ip"`
+			MaxTrackedSources int       `mapstructure:"max_tracked_sources"`
+		} `mapstructure:"rate_limit"`
+
+		Retry struct {
+			Threshold     float64       `mapstructure:"threshold"` // New connections per second, 0 to never retry for load
+			Key           string        `mapstructure:"key"`       // Hex-encoded token key, random if empty
+			TokenLifetime time.Duration `mapstructure:"token_lifetime"`
+		} `mapstructure:"retry"`
 	} `mapstructure:"udp"`
 	API struct {
 		Port        int  `mapstructure:"port"`
 		LogRequests bool `mapstructure:"log_requests"`
+		Auth        struct {
+			Enabled bool       `mapstructure:"enabled"`
+			Tokens  []APIToken `mapstructure:"tokens"`
+		} `mapstructure:"auth"`
+		TLS struct {
+			Enabled           bool        `mapstructure:"enabled"`
+			CertFile          string      `mapstructure:"cert_file"`
+			KeyFile           string      `mapstructure:"key_file"`
+			ClientCAFile      string      `mapstructure:"client_ca_file"`
+			RequireClientCert bool        `mapstructure:"require_client_cert"`
+			Clients           []APIClient `mapstructure:"clients"`
+		} `mapstructure:"tls"`
 	} `mapstructure:"api"`
+	QUICLB struct {
+		Enabled bool           `mapstructure:"enabled"`
+		Configs []QUICLBConfig `mapstructure:"configs"`
+	} `mapstructure:"quic_lb"`
+	Health struct {
+		Enabled            bool          `mapstructure:"enabled"`
+		Interval           time.Duration `mapstructure:"interval"`
+		Timeout            time.Duration `mapstructure:"timeout"`
+		HealthyThreshold   int           `mapstructure:"healthy_threshold"`
+		UnhealthyThreshold int           `mapstructure:"unhealthy_threshold"`
+		Probes             []string      `mapstructure:"probes"`
+		HTTP               struct {
+			Port   int    `mapstructure:"port"`
+			Path   string `mapstructure:"path"`
+			Scheme string `mapstructure:"scheme"`
+		} `mapstructure:"http"`
+	} `mapstructure:"health"`
 	Redis struct {
 		Enabled  bool   `mapstructure:"enabled"`
 		Address  string `mapstructure:"address"`
 		Password string `mapstructure:"password"`
 		DB       int    `mapstructure:"db"`
 		Channel  string `mapstructure:"channel"`
+		// Channel for access policy updates
+		ACLChannel string `mapstructure:"acl_channel"`
+		Sessions   struct {
+			Enabled bool          `mapstructure:"enabled"`
+			TTL     time.Duration `mapstructure:"ttl"`
+		} `mapstructure:"sessions"`
 	} `mapstructure:"redis"`
 	Agones struct {
 		Enabled             bool   `mapstructure:"enabled"`
@@ -27,11 +100,93 @@ type Config struct {
 		AllocatorClientCert string `mapstructure:"allocator_client_cert"`
 		AllocatorClientKey  string `mapstructure:"allocator_client_key"`
 	} `mapstructure:"agones"`
-	Routes []struct {
-		FQDN   string `mapstructure:"fqdn"`
-		Type   string `mapstructure:"type"`
-		Target string `mapstructure:"target"`
-	} `mapstructure:"routes"`
+	AccessLog struct {
+		Enabled    bool    `mapstructure:"enabled"`
+		Output     string  `mapstructure:"output"` // stdout, file, syslog or udp
+		SampleRate float64 `mapstructure:"sample_rate"`
+		Address    string  `mapstructure:"address"` // syslog or udp sink, host:port
+		File       struct {
+			Path       string `mapstructure:"path"`
+			MaxSizeMB  int    `mapstructure:"max_size_mb"`
+			MaxBackups int    `mapstructure:"max_backups"`
+		} `mapstructure:"file"`
+	} `mapstructure:"access_log"`
+	ACL struct {
+		Allow []string `mapstructure:"allow"`
+		Deny  []string `mapstructure:"deny"`
+	} `mapstructure:"acl"`
+	GeoIP struct {
+		Enabled bool `mapstructure:"enabled"`
+		// MMDB files, e.g. a country and an ASN database
+		Databases      []string      `mapstructure:"databases"`
+		ReloadInterval time.Duration `mapstructure:"reload_interval"`
+	} `mapstructure:"geoip"`
+	Routes []Route `mapstructure:"routes"`
+}
+
+// Route is a route loaded from the config file, along with its per-route
+// relay settings.
+type Route struct {
+	FQDN          string        `mapstructure:"fqdn"`
+	Type          string        `mapstructure:"type"`
+	Target        string        `mapstructure:"target"`
+	Targets       []RouteTarget `mapstructure:"targets"`
+	Algorithm     string        `mapstructure:"algorithm"`
+	IdleTimeout   time.Duration `mapstructure:"idle_timeout"`
+	CIDLength     int           `mapstructure:"cid_length"`
+	ProxyProtocol string        `mapstructure:"proxy_protocol"`
+	Transparent   bool          `mapstructure:"transparent"`
+	MTU           int           `mapstructure:"mtu"`
+	Retry         bool          `mapstructure:"retry"`
+	RetryAware    bool          `mapstructure:"retry_aware"`
+	Allow         []string      `mapstructure:"allow"`
+	Deny          []string      `mapstructure:"deny"`
+	Geo           GeoTargets    `mapstructure:"geo"`
+}
+
+// GeoTargets maps client locations to targets on a geoip route. ASNs are
+// keyed by their number.
+type GeoTargets struct {
+	ASNs       map[string]string `mapstructure:"asns"`
+	Countries  map[string]string `mapstructure:"countries"`
+	Continents map[string]string `mapstructure:"continents"`
+}
+
+// RateLimit is a token bucket refilled at Rate new sessions per second,
+// holding up to Burst. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// RouteTarget is one weighted backend of a simple route.
+type RouteTarget struct {
+	Address string `mapstructure:"address"`
+	Weight  int    `mapstructure:"weight"`
+}
+
+// APIToken grants a static bearer token a set of management API scopes.
+type APIToken struct {
+	Name   string   `mapstructure:"name"`
+	Token  string   `mapstructure:"token"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// APIClient grants a TLS client certificate, identified by its subject
+// common name, a set of management API scopes.
+type APIClient struct {
+	CommonName string   `mapstructure:"common_name"`
+	Scopes     []string `mapstructure:"scopes"`
+}
+
+// QUICLBConfig is one QUIC-LB configuration, selected by the config rotation
+// bits of a connection ID, and the targets its server IDs map to.
+type QUICLBConfig struct {
+	ID             uint8             `mapstructure:"id"`
+	ServerIDLength int               `mapstructure:"server_id_length"`
+	NonceLength    int               `mapstructure:"nonce_length"`
+	Key            string            `mapstructure:"key"`     // Hex-encoded AES-128 key, empty for plaintext CIDs
+	Servers        map[string]string `mapstructure:"servers"` // Hex-encoded server ID -> target
 }
 
 func LoadConfig() (*Config, error) {
@@ -42,12 +197,52 @@ func LoadConfig() (*Config, error) {
 
 	viper.SetDefault("udp.port", 443)
 	viper.SetDefault("udp.log_requests", false)
+	viper.SetDefault("udp.idle_timeout", "5m")
+	viper.SetDefault("udp.reap_interval", "10s")
+	viper.SetDefault("udp.cid_length", 0)
+	viper.SetDefault("udp.handshake_timeout", "5s")
+	viper.SetDefault("udp.handshake_buffer_size", 16384)
+	viper.SetDefault("udp.max_pending_bytes", 16<<20)
+	viper.SetDefault("udp.max_datagram_size", 2048)
+	viper.SetDefault("udp.listeners", 1)
+	viper.SetDefault("udp.workers", 0)
+	viper.SetDefault("udp.queue_size", 1024)
+	viper.SetDefault("udp.batch_size", 32)
+	viper.SetDefault("udp.gso", true)
+	viper.SetDefault("udp.gro", true)
+	viper.SetDefault("udp.drain_timeout", "30s")
+	viper.SetDefault("udp.rate_limit.per_prefix.ipv4_prefix", 24)
+	viper.SetDefault("udp.rate_limit.per_prefix.ipv6_prefix", 48)
+	viper.SetDefault("udp.rate_limit.max_tracked_sources", 100000)
+	viper.SetDefault("udp.retry.threshold", 0)
+	viper.SetDefault("udp.retry.token_lifetime", "10s")
 	viper.SetDefault("api.port", 8080)
 	viper.SetDefault("api.log_requests", false)
+	viper.SetDefault("api.auth.enabled", false)
+	viper.SetDefault("api.tls.enabled", false)
+	viper.SetDefault("quic_lb.enabled", false)
+	viper.SetDefault("health.enabled", false)
+	viper.SetDefault("health.interval", "10s")
+	viper.SetDefault("health.timeout", "2s")
+	viper.SetDefault("health.healthy_threshold", 2)
+	viper.SetDefault("health.unhealthy_threshold", 3)
+	viper.SetDefault("health.probes", []string{"udp", "quic"})
+	viper.SetDefault("health.http.path", "/healthz")
+	viper.SetDefault("health.http.scheme", "http")
 	viper.SetDefault("redis.enabled", false)
 	viper.SetDefault("redis.channel", "porter_routes")
+	viper.SetDefault("redis.acl_channel", "porter_acl")
+	viper.SetDefault("redis.sessions.enabled", false)
+	viper.SetDefault("redis.sessions.ttl", "10m")
 	viper.SetDefault("agones.enabled", false)
 	viper.SetDefault("agones.namespace", "default")
+	viper.SetDefault("access_log.enabled", false)
+	viper.SetDefault("access_log.output", "stdout")
+	viper.SetDefault("access_log.sample_rate", 1.0)
+	viper.SetDefault("access_log.file.path", "porter-access.log")
+	viper.SetDefault("access_log.file.max_size_mb", 100)
+	viper.SetDefault("access_log.file.max_backups", 5)
+	viper.SetDefault("geoip.reload_interval", "1m")
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
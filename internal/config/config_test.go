@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadConfigDefaults(t *testing.T) {
@@ -18,6 +19,10 @@ func TestLoadConfigDefaults(t *testing.T) {
 	if cfg.API.Port != 8080 {
 		t.Errorf("Expected default API port 8080, got %d", cfg.API.Port)
 	}
+
+	if cfg.UDP.IdleTimeout != 5*time.Minute {
+		t.Errorf("Expected default idle timeout 5m, got %s", cfg.UDP.IdleTimeout)
+	}
 }
 
 func TestLoadConfigFile(t *testing.T) {
@@ -58,6 +63,7 @@ routes:
   - fqdn: "test.example.com"
     type: "simple"
     target: "1.2.3.4:5678"
+    idle_timeout: 30s
   - fqdn: "agones.example.com"
     type: "agones"
     target: "my-fleet"
@@ -80,6 +86,9 @@ routes:
 	if cfg.Routes[0].FQDN != "test.example.com" || cfg.Routes[0].Target != "1.2.3.4:5678" || cfg.Routes[0].Type != "simple" {
 		t.Errorf("Unexpected route 0: %+v", cfg.Routes[0])
 	}
+	if cfg.Routes[0].IdleTimeout != 30*time.Second {
+		t.Errorf("Expected route idle timeout 30s, got %s", cfg.Routes[0].IdleTimeout)
+	}
 	if cfg.Routes[1].FQDN != "agones.example.com" || cfg.Routes[1].Target != "my-fleet" || cfg.Routes[1].Type != "agones" {
 		t.Errorf("Unexpected route 1: %+v", cfg.Routes[1])
 	}
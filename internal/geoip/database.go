@@ -0,0 +1,171 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+// Database locates clients using one or more MMDB files, such as a country
+// database and an ASN database, and reloads each file when it changes.
+type Database struct {
+	interval time.Duration
+
+	mu    sync.RWMutex
+	files []*dbFile
+}
+
+type dbFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	reader  *Reader
+}
+
+func NewDatabase(cfg *config.Config) (*Database, error) {
+	if len(cfg.GeoIP.Databases) == 0 {
+		return nil, errors.New("geoip.databases is empty")
+	}
+
+	d := &Database{interval: cfg.GeoIP.ReloadInterval}
+	for _, path := range cfg.GeoIP.Databases {
+		f, err := openFile(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded GeoIP database %s (%s)", path, f.reader.DatabaseType())
+		d.files = append(d.files, f)
+	}
+	return d, nil
+}
+
+func openFile(path string) (*dbFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &dbFile{path: path, modTime: info.ModTime(), size: info.Size(), reader: reader}, nil
+}
+
+// Run reloads changed database files every reload interval until ctx is
+// cancelled.
+func (d *Database) Run(ctx context.Context) {
+	if d.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Reload()
+		}
+	}
+}
+
+// Reload reopens every file whose modification time or size changed. A file
+// that fails to load keeps being served from its previous version.
+func (d *Database) Reload() {
+	d.mu.RLock()
+	files := d.files
+	d.mu.RUnlock()
+
+	updated := make([]*dbFile, len(files))
+	changed := false
+	for i, f := range files {
+		updated[i] = f
+		info, err := os.Stat(f.path)
+		if err != nil {
+			log.Printf("Warning: keeping previous GeoIP database %s: %v", f.path, err)
+			continue
+		}
+		if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+			continue
+		}
+
+		reloaded, err := openFile(f.path)
+		if err != nil {
+			log.Printf("Warning: keeping previous GeoIP database %s: %v", f.path, err)
+			continue
+		}
+		log.Printf("Reloaded GeoIP database %s (%s)", f.path, reloaded.reader.DatabaseType())
+		updated[i] = reloaded
+		changed = true
+	}
+
+	if changed {
+		d.mu.Lock()
+		d.files = updated
+		d.mu.Unlock()
+	}
+}
+
+// Locate looks ip up in every database. For each field, the first database
+// that knows it wins.
+func (d *Database) Locate(ip netip.Addr) strategy.GeoLocation {
+	d.mu.RLock()
+	files := d.files
+	d.mu.RUnlock()
+
+	var loc strategy.GeoLocation
+	for _, f := range files {
+		record, ok, err := f.reader.Lookup(ip)
+		if err != nil {
+			log.Printf("GeoIP lookup of %s in %s failed: %v", ip, f.path, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		fill(&loc, record)
+	}
+	return loc
+}
+
+// fill sets the unset fields of loc from a GeoIP2/GeoLite2 Country, City or
+// ASN record.
+func fill(loc *strategy.GeoLocation, record any) {
+	m, ok := record.(map[string]any)
+	if !ok {
+		return
+	}
+	if loc.Country == "" {
+		loc.Country = isoCode(m["country"])
+	}
+	if loc.Country == "" {
+		loc.Country = isoCode(m["registered_country"])
+	}
+	if loc.Continent == "" {
+		if continent, ok := m["continent"].(map[string]any); ok {
+			loc.Continent, _ = continent["code"].(string)
+		}
+	}
+	if loc.ASN == 0 {
+		if asn, ok := m["autonomous_system_number"].(uint64); ok && asn <= 1<<32-1 {
+			loc.ASN = uint32(asn)
+		}
+	}
+}
+
+func isoCode(v any) string {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ""
+	}
+	code, _ := m["iso_code"].(string)
+	return code
+}
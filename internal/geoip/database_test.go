@@ -0,0 +1,68 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+func TestDatabase(t *testing.T) {
+	dir := t.TempDir()
+	countryPath := filepath.Join(dir, "country.mmdb")
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	if err := os.WriteFile(countryPath, buildMMDB(t, 24, "Test-Country", countryNetworks), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	asnNetworks := []testNetwork{{"81.2.69.0/24", map[string]any{"autonomous_system_number": uint32(20712)}}}
+	if err := os.WriteFile(asnPath, buildMMDB(t, 24, "Test-ASN", asnNetworks), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.GeoIP.Databases = []string{countryPath, asnPath}
+	d, err := NewDatabase(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+
+	want := strategy.GeoLocation{Country: "GB", Continent: "EU", ASN: 20712}
+	if got := d.Locate(netip.MustParseAddr("81.2.69.1")); got != want {
+		t.Errorf("Locate() = %+v, want %+v", got, want)
+	}
+
+	// A changed file is picked up on reload.
+	moved := []testNetwork{{"81.2.69.0/24", map[string]any{
+		"country":   map[string]any{"iso_code": "DE"},
+		"continent": map[string]any{"code": "EU"},
+	}}}
+	if err := os.WriteFile(countryPath, buildMMDB(t, 24, "Test-Country", moved), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(countryPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	d.Reload()
+	if got := d.Locate(netip.MustParseAddr("81.2.69.1")); got.Country != "DE" {
+		t.Errorf("Locate() after reload = %+v, want DE", got)
+	}
+
+	// A broken file keeps the previous version.
+	if err := os.WriteFile(countryPath, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.Reload()
+	if got := d.Locate(netip.MustParseAddr("81.2.69.1")); got.Country != "DE" {
+		t.Errorf("Locate() after a failed reload = %+v, want DE", got)
+	}
+
+	cfg.GeoIP.Databases = []string{filepath.Join(dir, "missing.mmdb")}
+	if _, err := NewDatabase(cfg); err == nil {
+		t.Error("Expected a missing database to fail")
+	}
+}
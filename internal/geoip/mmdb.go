@@ -0,0 +1,342 @@
+// Package geoip looks up client locations in MaxMind DB (MMDB) files, such as
+// the GeoLite2 Country and ASN databases.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the run of zero bytes between the search tree and
+// the data section.
+const dataSectionSeparator = 16
+
+// maxDecodeDepth bounds nesting in the data section, so a corrupt file
+// cannot recurse without limit.
+const maxDecodeDepth = 32
+
+// maxPreallocated caps the capacity reserved for a map or array from the size
+// it declares, which a corrupt file can set far beyond its contents.
+const maxPreallocated = 1024
+
+var errCorrupt = errors.New("invalid MMDB data")
+
+// Reader looks up records in an MMDB file held in memory. The format is
+// described at https://maxmind.github.io/MaxMind-DB/.
+type Reader struct {
+	buf        []byte
+	data       []byte // Data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	dbType     string
+}
+
+// Open reads an MMDB file.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// FromBytes parses an MMDB file's contents.
+func FromBytes(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("MMDB metadata not found")
+	}
+	metaDecoder := decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := metaDecoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MMDB metadata: %w", err)
+	}
+	meta, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid MMDB metadata")
+	}
+
+	r := &Reader{buf: buf}
+	r.nodeCount, _ = metaUint(meta, "node_count")
+	r.recordSize, _ = metaUint(meta, "record_size")
+	r.ipVersion, _ = metaUint(meta, "ip_version")
+	r.dbType, _ = meta["database_type"].(string)
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MMDB record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported MMDB IP version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, errCorrupt
+	}
+	r.data = buf[treeSize+dataSectionSeparator : start]
+
+	// IPv4 addresses live under ::/96 of IPv6 databases.
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+func metaUint(meta map[string]any, key string) (uint, bool) {
+	v, ok := meta[key].(uint64)
+	return uint(v), ok
+}
+
+// DatabaseType returns the database_type metadata, e.g. "GeoLite2-Country".
+func (r *Reader) DatabaseType() string {
+	return r.dbType
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (r *Reader) record(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.buf[node*8+bit*4:]))
+	}
+}
+
+// Lookup returns the record for the network containing ip, decoded into
+// maps, slices, strings, numbers and booleans. It reports false if the
+// database has no record for ip.
+func (r *Reader) Lookup(ip netip.Addr) (any, bool, error) {
+	ip = ip.Unmap()
+	if ip.Is6() && r.ipVersion == 4 {
+		return nil, false, nil
+	}
+
+	node := uint(0)
+	if ip.Is4() && r.ipVersion == 6 {
+		node = r.ipv4Start
+	}
+	addr := ip.AsSlice()
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, false, nil
+	case node < r.nodeCount:
+		return nil, false, errCorrupt
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type decoder struct {
+	buf []byte
+}
+
+// take returns n bytes at offset.
+func (d *decoder) take(offset, n uint) ([]byte, error) {
+	if offset+n < offset || offset+n > uint(len(d.buf)) {
+		return nil, errCorrupt
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// decode returns the value at offset and the offset following it.
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errCorrupt
+	}
+
+	b, err := d.take(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		n := uint(ctrl>>3)&3 + 1
+		b, err := d.take(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		var pointer uint
+		switch n {
+		case 1:
+			pointer = uint(ctrl&7)<<8 | uint(b[0])
+		case 2:
+			pointer = (uint(ctrl&7)<<16 | uint(beUint(b))) + 2048
+		case 3:
+			pointer = (uint(ctrl&7)<<24 | uint(beUint(b))) + 526336
+		default:
+			pointer = uint(beUint(b))
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, offset + n, err
+	}
+
+	if typ == typeExtended {
+		b, err := d.take(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.take(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + uint(beUint(b))
+		default:
+			size = 65821 + uint(beUint(b))
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, maxPreallocated))
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, maxPreallocated))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err = d.take(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return bytes.Clone(b), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		return beUint(b), offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		return int32(uint32(beUint(b))), offset, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errCorrupt
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported MMDB data type %d", typ)
+	}
+}
@@ -0,0 +1,266 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// encodeControl writes a control byte, and any extended type and size bytes.
+func encodeControl(typ, size int) []byte {
+	var out []byte
+	sizeBits, extra := size, []byte(nil)
+	switch {
+	case size >= 65821:
+		sizeBits, extra = 31, []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+	case size >= 285:
+		sizeBits, extra = 30, []byte{byte((size - 285) >> 8), byte(size - 285)}
+	case size >= 29:
+		sizeBits, extra = 29, []byte{byte(size - 29)}
+	}
+	if typ > 7 {
+		out = append(out, byte(sizeBits), byte(typ-7))
+	} else {
+		out = append(out, byte(typ<<5|sizeBits))
+	}
+	return append(out, extra...)
+}
+
+func encodeValue(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(encodeControl(typeString, len(v)), v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append(encodeControl(typeUint32, 4), b...)
+	case uint64:
+		b := binary.BigEndian.AppendUint64(nil, v)
+		return append(encodeControl(typeUint64, 8), b...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return encodeControl(typeBool, size)
+	case []any:
+		out := encodeControl(typeArray, len(v))
+		for _, e := range v {
+			out = append(out, encodeValue(e)...)
+		}
+		return out
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := encodeControl(typeMap, len(v))
+		for _, k := range keys {
+			out = append(out, encodeValue(k)...)
+			out = append(out, encodeValue(v[k])...)
+		}
+		return out
+	}
+	panic("unsupported test value")
+}
+
+type testNetwork struct {
+	prefix string
+	record map[string]any
+}
+
+type treeNode struct {
+	child [2]*treeNode
+	leaf  bool
+	data  int
+}
+
+// buildMMDB writes an IPv6 database holding the networks. IPv4 networks are
+// stored under ::/96, as MaxMind does.
+func buildMMDB(t testing.TB, recordSize int, dbType string, networks []testNetwork) []byte {
+	t.Helper()
+
+	var data []byte
+	root := &treeNode{}
+	for _, n := range networks {
+		prefix := netip.MustParsePrefix(n.prefix)
+		addr, bits := prefix.Addr().As16(), prefix.Bits()
+		if prefix.Addr().Is4() {
+			v4 := prefix.Addr().As4()
+			addr = [16]byte{}
+			copy(addr[12:], v4[:])
+			bits += 96
+		}
+
+		node := root
+		for i := 0; i < bits; i++ {
+			bit := addr[i/8] >> (7 - i%8) & 1
+			if node.child[bit] == nil {
+				node.child[bit] = &treeNode{}
+			}
+			node = node.child[bit]
+		}
+		node.leaf, node.data = true, len(data)
+		data = append(data, encodeValue(n.record)...)
+	}
+
+	// Number the internal nodes breadth first.
+	var nodes []*treeNode
+	index := map[*treeNode]int{}
+	for queue := []*treeNode{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil && !c.leaf {
+				queue = append(queue, c)
+			}
+		}
+	}
+	count := len(nodes)
+	value := func(c *treeNode) uint32 {
+		switch {
+		case c == nil:
+			return uint32(count)
+		case c.leaf:
+			return uint32(count + dataSectionSeparator + c.data)
+		}
+		return uint32(index[c])
+	}
+
+	var out []byte
+	for _, n := range nodes {
+		left, right := value(n.child[0]), value(n.child[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			out = binary.BigEndian.AppendUint32(out, left)
+			out = binary.BigEndian.AppendUint32(out, right)
+		}
+	}
+	out = append(out, make([]byte, dataSectionSeparator)...)
+	out = append(out, data...)
+	out = append(out, metadataMarker...)
+	return append(out, encodeValue(map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint32(recordSize),
+		"ip_version":                  uint32(6),
+		"database_type":               dbType,
+		"binary_format_major_version": uint32(2),
+		"languages":                   []any{"en"},
+	})...)
+}
+
+var countryNetworks = []testNetwork{
+	{"81.2.69.0/24", map[string]any{
+		"country":   map[string]any{"iso_code": "GB"},
+		"continent": map[string]any{"code": "EU"},
+	}},
+	{"2001:db8::/32", map[string]any{
+		"country":    map[string]any{"iso_code": "US"},
+		"continent":  map[string]any{"code": "NA"},
+		"is_anycast": true,
+	}},
+}
+
+func TestReaderLookup(t *testing.T) {
+	for _, size := range []int{24, 28, 32} {
+		r, err := FromBytes(buildMMDB(t, size, "Test-Country", countryNetworks))
+		if err != nil {
+			t.Fatalf("FromBytes() with %d-bit records error = %v", size, err)
+		}
+		if r.DatabaseType() != "Test-Country" {
+			t.Errorf("DatabaseType() = %q", r.DatabaseType())
+		}
+
+		tests := []struct {
+			ip      string
+			country string
+		}{
+			{"81.2.69.160", "GB"},
+			{"::ffff:81.2.69.1", "GB"},
+			{"2001:db8:1::1", "US"},
+			{"81.2.70.1", ""},
+			{"2001:db9::1", ""},
+		}
+		for _, tt := range tests {
+			record, ok, err := r.Lookup(netip.MustParseAddr(tt.ip))
+			if err != nil {
+				t.Fatalf("Lookup(%s) error = %v", tt.ip, err)
+			}
+			if ok != (tt.country != "") {
+				t.Errorf("Lookup(%s) found = %v with %d-bit records", tt.ip, ok, size)
+				continue
+			}
+			if ok && isoCode(record.(map[string]any)["country"]) != tt.country {
+				t.Errorf("Lookup(%s) = %v, want %s", tt.ip, record, tt.country)
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// A string, then a pointer to it.
+	d := decoder{buf: append(encodeValue("abc"), 0x20, 0x00)}
+	value, next, err := d.decode(4, 0)
+	if err != nil || value != "abc" || next != 6 {
+		t.Errorf("decode(pointer) = %v, %d, %v", value, next, err)
+	}
+
+	long := string(make([]byte, 300))
+	for _, v := range []any{long, uint64(1) << 40, []any{"x", true}} {
+		d := decoder{buf: encodeValue(v)}
+		got, _, err := d.decode(0, 0)
+		if err != nil || !reflect.DeepEqual(got, v) {
+			t.Errorf("decode() = %v, %v, want %v", got, err, v)
+		}
+	}
+
+	// Truncated data and pointer loops are rejected.
+	for _, buf := range [][]byte{{0x43, 'a'}, {0x20, 0x00}} {
+		d := decoder{buf: buf}
+		if _, _, err := d.decode(0, 0); err == nil {
+			t.Errorf("Expected decoding % x to fail", buf)
+		}
+	}
+}
+
+func FuzzReaderLookup(f *testing.F) {
+	for _, size := range []int{24, 28, 32} {
+		f.Add(buildMMDB(f, size, "Test-Country", countryNetworks), []byte{81, 2, 69, 160})
+	}
+	f.Add(buildMMDB(f, 24, "Test-Country", countryNetworks), netip.MustParseAddr("2001:db8::1").AsSlice())
+
+	f.Fuzz(func(t *testing.T, db, ip []byte) {
+		r, err := FromBytes(db)
+		if err != nil {
+			return
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			addr = netip.IPv6Unspecified()
+		}
+		_, _, _ = r.Lookup(addr)
+		_, _, _ = r.Lookup(addr.Unmap())
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(encodeValue(countryNetworks[1].record))
+	f.Add(encodeValue([]any{"x", true, uint64(1) << 40}))
+	f.Add(append(encodeValue("abc"), 0x20, 0x00))
+	f.Add([]byte{0xe1, 0x20, 0x00})             // A map claiming one entry, keyed by a pointer to itself
+	f.Add([]byte{0x1f, 0xff, 0xff, 0xff, 0x01}) // An extended type with the largest size
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := decoder{buf: data}
+		if _, next, err := d.decode(0, 0); err == nil && next > uint(len(data)) {
+			t.Fatalf("decode() ended at %d, past the %d byte buffer", next, len(data))
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+)
+
+// TargetLister provides the targets to check. The simple and geoip strategies
+// implement it.
+type TargetLister interface {
+	Targets() []string
+}
+
+// Status is the health state of one target.
+type Status struct {
+	Target              string    `json:"target"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutivePasses   int       `json:"consecutive_passes"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// Checker probes every target on a schedule. A target is marked unhealthy
+// after UnhealthyThreshold consecutive failed checks, and healthy again after
+// HealthyThreshold consecutive passing checks. Targets that have not been
+// checked yet are considered healthy.
+type Checker struct {
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	probes             []Probe
+	targets            []TargetLister
+
+	mu     sync.RWMutex
+	states map[string]*Status
+}
+
+// NewChecker returns a checker for the targets of every lister.
+func NewChecker(cfg *config.Config, targets ...TargetLister) (*Checker, error) {
+	if cfg.Health.Interval <= 0 {
+		return nil, fmt.Errorf("health.interval must be positive, got %v", cfg.Health.Interval)
+	}
+
+	c := &Checker{
+		interval:           cfg.Health.Interval,
+		timeout:            cfg.Health.Timeout,
+		healthyThreshold:   max(cfg.Health.HealthyThreshold, 1),
+		unhealthyThreshold: max(cfg.Health.UnhealthyThreshold, 1),
+		targets:            targets,
+		states:             make(map[string]*Status),
+	}
+
+	for _, name := range cfg.Health.Probes {
+		switch name {
+		case "udp":
+			c.probes = append(c.probes, UDPProbe{})
+		case "quic":
+			c.probes = append(c.probes, QUICProbe{})
+		case "http":
+			c.probes = append(c.probes, &HTTPProbe{
+				Scheme: cfg.Health.HTTP.Scheme,
+				Port:   cfg.Health.HTTP.Port,
+				Path:   cfg.Health.HTTP.Path,
+			})
+		default:
+			return nil, fmt.Errorf("unknown health probe %q", name)
+		}
+	}
+	return c, nil
+}
+
+// Run checks all targets every interval until ctx is cancelled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every current target concurrently and forgets targets that
+// are no longer routed to.
+func (c *Checker) CheckAll(ctx context.Context) {
+	targets := c.allTargets()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.record(target, c.check(ctx, target))
+		}()
+	}
+	wg.Wait()
+
+	c.prune(targets)
+}
+
+// allTargets returns the targets of every lister, without duplicates.
+func (c *Checker) allTargets() []string {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, lister := range c.targets {
+		for _, target := range lister.Targets() {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// check runs every probe against the target, stopping at the first failure.
+func (c *Checker) check(ctx context.Context, target string) error {
+	for _, probe := range c.probes {
+		probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := probe.Check(probeCtx, target)
+		cancel()
+
+		if err != nil {
+			metrics.BackendHealthChecks.WithLabelValues(probe.Name(), "fail").Inc()
+			return fmt.Errorf("%s probe: %w", probe.Name(), err)
+		}
+		metrics.BackendHealthChecks.WithLabelValues(probe.Name(), "pass").Inc()
+	}
+	return nil
+}
+
+func (c *Checker) record(target string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[target]
+	if !ok {
+		state = &Status{Target: target, Healthy: true}
+		c.states[target] = state
+	}
+	state.LastCheck = time.Now()
+
+	if err != nil {
+		state.ConsecutivePasses = 0
+		state.ConsecutiveFailures++
+		state.LastError = err.Error()
+		if state.Healthy && state.ConsecutiveFailures >= c.unhealthyThreshold {
+			state.Healthy = false
+			log.Printf("Backend %s is unhealthy: %v", target, err)
+		}
+	} else {
+		state.ConsecutiveFailures = 0
+		state.ConsecutivePasses++
+		state.LastError = ""
+		if !state.Healthy && state.ConsecutivePasses >= c.healthyThreshold {
+			state.Healthy = true
+			log.Printf("Backend %s is healthy again", target)
+		}
+	}
+
+	healthy := 0.0
+	if state.Healthy {
+		healthy = 1
+	}
+	metrics.BackendHealthy.WithLabelValues(target).Set(healthy)
+}
+
+func (c *Checker) prune(targets []string) {
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for target := range c.states {
+		if !current[target] {
+			delete(c.states, target)
+			metrics.BackendHealthy.DeleteLabelValues(target)
+		}
+	}
+}
+
+// Healthy reports whether the target may receive new connections. It
+// implements strategy.HealthChecker.
+func (c *Checker) Healthy(target string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state, ok := c.states[target]
+	return !ok || state.Healthy
+}
+
+// Statuses returns a snapshot of every checked target, ordered by target.
+func (c *Checker) Statuses() []Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(c.states))
+	for _, state := range c.states {
+		statuses = append(statuses, *state)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Target < statuses[j].Target
+	})
+	return statuses
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+)
+
+type fakeProbe struct {
+	failing map[string]bool
+}
+
+func (p *fakeProbe) Name() string { return "fake" }
+
+func (p *fakeProbe) Check(ctx context.Context, target string) error {
+	if p.failing[target] {
+		return errors.New("down")
+	}
+	return nil
+}
+
+type staticTargets []string
+
+func (s *staticTargets) Targets() []string { return *s }
+
+func TestCheckerThresholds(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Health.Interval = time.Second
+	cfg.Health.Timeout = time.Second
+	cfg.Health.HealthyThreshold = 2
+	cfg.Health.UnhealthyThreshold = 3
+
+	targets := &staticTargets{"a:1", "b:1"}
+	c, err := NewChecker(cfg, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &fakeProbe{failing: map[string]bool{"a:1": true}}
+	c.probes = []Probe{probe}
+
+	if !c.Healthy("a:1") {
+		t.Error("Expected unchecked target to be healthy")
+	}
+
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		c.CheckAll(ctx)
+		if want := i < 3; c.Healthy("a:1") != want {
+			t.Fatalf("After %d failures: healthy = %v, want %v", i, !want, want)
+		}
+	}
+	if !c.Healthy("b:1") {
+		t.Error("Expected passing target to stay healthy")
+	}
+
+	probe.failing["a:1"] = false
+	c.CheckAll(ctx)
+	if c.Healthy("a:1") {
+		t.Error("Expected target to stay unhealthy after one passing check")
+	}
+	c.CheckAll(ctx)
+	if !c.Healthy("a:1") {
+		t.Error("Expected target to recover after two passing checks")
+	}
+
+	statuses := c.Statuses()
+	if len(statuses) != 2 || statuses[0].Target != "a:1" || statuses[0].ConsecutivePasses != 2 {
+		t.Errorf("Statuses() = %+v", statuses)
+	}
+
+	*targets = staticTargets{"b:1"}
+	c.CheckAll(ctx)
+	if statuses := c.Statuses(); len(statuses) != 1 || statuses[0].Target != "b:1" {
+		t.Errorf("Expected removed target to be forgotten, got %+v", statuses)
+	}
+}
+
+func TestNewCheckerUnknownProbe(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Health.Interval = time.Second
+	cfg.Health.Probes = []string{"udp", "icmp"}
+	if _, err := NewChecker(cfg, &staticTargets{}); err == nil {
+		t.Error("Expected an error for an unknown probe")
+	}
+}
+
+func TestNewCheckerInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		cfg := &config.Config{}
+		cfg.Health.Interval = interval
+		if _, err := NewChecker(cfg, &staticTargets{}); err == nil {
+			t.Errorf("Expected an error for interval %v", interval)
+		}
+	}
+}
+
+func TestCheckerListers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Health.Interval = time.Second
+	cfg.Health.Timeout = time.Second
+
+	c, err := NewChecker(cfg, &staticTargets{"a:1", "b:1"}, &staticTargets{"b:1", "c:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.probes = []Probe{&fakeProbe{failing: map[string]bool{"c:1": true}}}
+	c.CheckAll(context.Background())
+
+	statuses := c.Statuses()
+	if len(statuses) != 3 || statuses[2].Target != "c:1" || statuses[2].ConsecutiveFailures != 1 {
+		t.Errorf("Statuses() = %+v, want every lister's targets once", statuses)
+	}
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+// Probe checks one aspect of a target's health. Check must return once ctx
+// is done.
+type Probe interface {
+	Name() string
+	Check(ctx context.Context, target string) error
+}
+
+// UDPProbe sends a single byte to the target and fails only if the host
+// reports the port as unreachable. Silence counts as success, since a QUIC
+// server drops datagrams it cannot parse.
+type UDPProbe struct{}
+
+func (UDPProbe) Name() string { return "udp" }
+
+func (UDPProbe) Check(ctx context.Context, target string) error {
+	conn, err := dialUDP(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil && !isTimeout(err) {
+		return err
+	}
+	return nil
+}
+
+// QUICProbe sends a packet with an unsupported version and expects the
+// target to answer with a Version Negotiation packet.
+type QUICProbe struct{}
+
+func (QUICProbe) Name() string { return "quic" }
+
+func (QUICProbe) Check(ctx context.Context, target string) error {
+	conn, err := dialUDP(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dcid := make([]byte, 8)
+	scid := make([]byte, 8)
+	rand.Read(dcid)
+	rand.Read(scid)
+
+	if _, err := conn.Write(quic.NewVersionProbe(dcid, scid)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if isTimeout(err) {
+				return errors.New("no version negotiation response")
+			}
+			return err
+		}
+		// Ignore anything that is not the answer to this probe.
+		vn, err := quic.ParseVersionNegotiation(buf[:n])
+		if err == nil && bytes.Equal(vn.DCID, scid) {
+			return nil
+		}
+	}
+}
+
+// HTTPProbe requests a path on the target's host and expects a 2xx or 3xx
+// response. A zero Port uses the target's own port number.
+type HTTPProbe struct {
+	Scheme string
+	Port   int
+	Path   string
+	Client *http.Client
+}
+
+func (p *HTTPProbe) Name() string { return "http" }
+
+func (p *HTTPProbe) Check(ctx context.Context, target string) error {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	if p.Port != 0 {
+		port = strconv.Itoa(p.Port)
+	}
+
+	url := fmt.Sprintf("%s://%s%s", p.Scheme, net.JoinHostPort(host, port), p.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP check returned %s", resp.Status)
+	}
+	return nil
+}
+
+func dialUDP(ctx context.Context, target string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", target)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
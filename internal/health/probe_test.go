@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+func probeContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// closedUDPAddr returns a local address with nothing listening on it.
+func closedUDPAddr(t *testing.T) string {
+	conn := listenUDP(t)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func TestUDPProbe(t *testing.T) {
+	open := listenUDP(t)
+	if err := (UDPProbe{}).Check(probeContext(t), open.LocalAddr().String()); err != nil {
+		t.Errorf("Expected silent listener to pass, got %v", err)
+	}
+	if err := (UDPProbe{}).Check(probeContext(t), closedUDPAddr(t)); err == nil {
+		t.Error("Expected closed port to fail")
+	}
+}
+
+func TestQUICProbe(t *testing.T) {
+	server := listenUDP(t)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < quic.MinInitialDatagramSize || binary.BigEndian.Uint32(buf[1:5]) != quic.ProbeVersion {
+				continue
+			}
+			dcid := buf[6 : 6+buf[5]]
+			scid := buf[7+buf[5] : 7+buf[5]+buf[6+buf[5]]]
+
+			// Send a stray packet first, then the real answer.
+			server.WriteToUDP([]byte("noise"), addr)
+			reply := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+			reply = append(reply, scid...)
+			reply = append(reply, byte(len(dcid)))
+			reply = append(reply, dcid...)
+			reply = binary.BigEndian.AppendUint32(reply, quic.Version1)
+			server.WriteToUDP(reply, addr)
+		}
+	}()
+
+	if err := (QUICProbe{}).Check(probeContext(t), server.LocalAddr().String()); err != nil {
+		t.Errorf("Expected QUIC server to pass, got %v", err)
+	}
+
+	silent := listenUDP(t)
+	if err := (QUICProbe{}).Check(probeContext(t), silent.LocalAddr().String()); err == nil {
+		t.Error("Expected silent listener to fail")
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := srv.Listener.Addr().String()
+	if err := (&HTTPProbe{Scheme: "http", Path: "/healthz"}).Check(probeContext(t), target); err != nil {
+		t.Errorf("Expected healthy endpoint to pass, got %v", err)
+	}
+	if err := (&HTTPProbe{Scheme: "http", Path: "/broken"}).Check(probeContext(t), target); err == nil {
+		t.Error("Expected 503 to fail")
+	}
+
+	// The HTTP port can differ from the target's QUIC port.
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	if err := (&HTTPProbe{Scheme: "http", Port: port, Path: "/healthz"}).Check(probeContext(t), "127.0.0.1:1"); err != nil {
+		t.Errorf("Expected port override to be used, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	DirectionClientToBackend = "client_to_backend"
+	DirectionBackendToClient = "backend_to_client"
+)
+
+// Reasons used for RelayPacketDrops.
+const (
+	DropParseError         = "parse_error"
+	DropUnsupportedVersion = "unsupported_version"
+	DropDecryptFailed      = "decrypt_failed"
+	DropMalformedPayload   = "malformed_payload"
+	DropSNINotFound        = "sni_not_found"
+	DropNoSession          = "no_session"
+	DropUnknownCID         = "unknown_cid"
+	DropAmbiguousCID       = "ambiguous_cid"
+	DropNoRoute            = "no_route"
+	DropBackendUnavailable = "backend_unavailable"
+	DropHandshakeBuffer    = "handshake_buffer_full"
+	DropHandshakeTimeout   = "handshake_timeout"
+	DropQueueFull          = "queue_full"
+	DropDraining           = "draining"
+	DropRateLimited        = "rate_limited"
+	DropAccessDenied       = "access_denied"
+	DropInvalidToken       = "invalid_token"
+)
+
+// Reasons used for RelayRetries.
+const (
+	RetryLoad  = "load"
+	RetryRoute = "route"
+)
+
+// Results used for RelayRetryTokens.
+const (
+	TokenValid   = "valid"
+	TokenInvalid = "invalid"
+	TokenExpired = "expired"
+)
+
+// Scopes used for RelayAccessDenied.
+const (
+	ACLGlobal = "global"
+	ACLRoute  = "route"
+)
+
+// Limits used for RelayRateLimited.
+const (
+	LimitIP       = "ip"
+	LimitPrefix   = "prefix"
+	LimitGlobal   = "global"
+	LimitSessions = "sessions"
+)
+
+var (
+	RelayPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_packets_total",
+		Help: "QUIC packets forwarded by the relay, by direction.",
+	}, []string{"direction"})
+
+	RelayBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_bytes_total",
+		Help: "Bytes forwarded by the relay, by direction.",
+	}, []string{"direction"})
+
+	RelayActiveSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "porter_relay_active_sessions",
+		Help: "Sessions currently tracked by the relay.",
+	})
+
+	RelaySessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_sessions_total",
+		Help: "New sessions created by the relay, by matched route pattern and routing strategy.",
+	}, []string{"route", "strategy"})
+
+	RelayPacketDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_packet_drops_total",
+		Help: "Client packets dropped by the relay, by reason.",
+	}, []string{"reason"})
+
+	RelayTruncated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_truncated_total",
+		Help: "Datagrams dropped for exceeding udp.max_datagram_size, by direction.",
+	}, []string{"direction"})
+
+	RelayOversized = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_oversized_total",
+		Help: "Datagrams dropped for exceeding their route's MTU, by direction.",
+	}, []string{"direction"})
+
+	RelayRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_retries_total",
+		Help: "Retry packets sent to validate client addresses, by reason.",
+	}, []string{"reason"})
+
+	RelayRetryTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_retry_tokens_total",
+		Help: "Retry tokens received on new connections, by validation result.",
+	}, []string{"result"})
+
+	RelayRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_rate_limited_total",
+		Help: "Initial packets for new connections dropped by rate limits, by the limit exceeded.",
+	}, []string{"limit"})
+
+	RelayAccessDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_relay_access_denied_total",
+		Help: "New connections rejected by the access policy, by the rules that rejected them.",
+	}, []string{"scope"})
+
+	AgonesAllocationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "porter_agones_allocation_duration_seconds",
+		Help:    "Latency of Agones allocation requests, by result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+
+	AgonesAllocationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_agones_allocation_errors_total",
+		Help: "Failed Agones allocation requests, by fleet.",
+	}, []string{"fleet"})
+
+	BackendHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "porter_backend_healthy",
+		Help: "Whether a backend target is passing its health checks (1) or not (0).",
+	}, []string{"target"})
+
+	BackendHealthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_backend_health_checks_total",
+		Help: "Backend health probes run, by probe and result.",
+	}, []string{"probe", "result"})
+
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "porter_redis_errors_total",
+		Help: "Redis sync errors, by operation.",
+	}, []string{"operation"})
+)
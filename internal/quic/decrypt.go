@@ -12,7 +12,69 @@ import (
 	"golang.org/x/crypto/hkdf"
 )
 
+const (
+	Version1 uint32 = 0x00000001
+	Version2 uint32 = 0x6b3343cf // RFC 9369
+)
+
+// Long header packet types, normalized to their QUIC v1 values regardless of
+// the on-the-wire encoding used by the packet's version.
+const (
+	PacketTypeInitial   byte = 0x00
+	PacketType0RTT      byte = 0x01
+	PacketTypeHandshake byte = 0x02
+	PacketTypeRetry     byte = 0x03
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported QUIC version")
+	ErrVersionNegotiation = errors.New("version negotiation packet")
+)
+
 var quicV1Salt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+var quicV2Salt = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+
+// versionParams holds the version-specific constants needed to parse and
+// decrypt Initial packets.
+type versionParams struct {
+	salt     []byte
+	keyLabel string
+	ivLabel  string
+	hpLabel  string
+	// packetTypes maps the two long header type bits to a normalized packet type.
+	packetTypes [4]byte
+	// Retry integrity tag key and nonce (RFC 9001, Section 5.8)
+	retryKey   []byte
+	retryNonce []byte
+}
+
+var supportedVersions = map[uint32]*versionParams{
+	Version1: {
+		salt:        quicV1Salt,
+		keyLabel:    "quic key",
+		ivLabel:     "quic iv",
+		hpLabel:     "quic hp",
+		packetTypes: [4]byte{PacketTypeInitial, PacketType0RTT, PacketTypeHandshake, PacketTypeRetry},
+		retryKey:    []byte{0xbe, 0x0c, 0x69, 0x0b, 0x9f, 0x66, 0x57, 0x5a, 0x1d, 0x76, 0x6b, 0x54, 0xe3, 0x68, 0xc8, 0x4e},
+		retryNonce:  []byte{0x46, 0x15, 0x99, 0xd3, 0x5d, 0x63, 0x2b, 0xf2, 0x23, 0x98, 0x25, 0xbb},
+	},
+	Version2: {
+		salt:        quicV2Salt,
+		keyLabel:    "quicv2 key",
+		ivLabel:     "quicv2 iv",
+		hpLabel:     "quicv2 hp",
+		packetTypes: [4]byte{PacketTypeRetry, PacketTypeInitial, PacketType0RTT, PacketTypeHandshake},
+		retryKey:    []byte{0x8f, 0xb4, 0xb0, 0x1b, 0x56, 0xac, 0x48, 0xe2, 0x60, 0xfb, 0xcb, 0xce, 0xad, 0x7c, 0xcc, 0x92},
+		retryNonce:  []byte{0xd8, 0x69, 0x69, 0xbc, 0x2d, 0x7c, 0x6d, 0x99, 0x90, 0xef, 0xb0, 0x4a},
+	},
+}
+
+// IsSupportedVersion reports whether Porter can parse and decrypt Initial
+// packets of the given QUIC version.
+func IsSupportedVersion(version uint32) bool {
+	_, ok := supportedVersions[version]
+	return ok
+}
 
 type initialKeys struct {
 	key    []byte
@@ -21,16 +83,21 @@ type initialKeys struct {
 	header cipher.Block
 }
 
-func deriveInitialKeys(destConnID []byte, isServer bool) (*initialKeys, *initialKeys) {
-	initialSecret := hkdf.Extract(sha256.New, destConnID, quicV1Salt)
+func deriveInitialKeys(version uint32, destConnID []byte) (*initialKeys, *initialKeys, error) {
+	params, ok := supportedVersions[version]
+	if !ok {
+		return nil, nil, ErrUnsupportedVersion
+	}
+
+	initialSecret := hkdf.Extract(sha256.New, destConnID, params.salt)
 
 	clientSecret := deriveSecret(initialSecret, "client in", 32)
 	serverSecret := deriveSecret(initialSecret, "server in", 32)
 
-	clientKeys := setupKeys(clientSecret)
-	serverKeys := setupKeys(serverSecret)
+	clientKeys := setupKeys(clientSecret, params)
+	serverKeys := setupKeys(serverSecret, params)
 
-	return clientKeys, serverKeys
+	return clientKeys, serverKeys, nil
 }
 
 func deriveSecret(secret []byte, label string, length int) []byte {
@@ -47,10 +114,10 @@ func deriveSecret(secret []byte, label string, length int) []byte {
 	return out
 }
 
-func setupKeys(secret []byte) *initialKeys {
-	key := deriveSecret(secret, "quic key", 16)
-	iv := deriveSecret(secret, "quic iv", 12)
-	hpSecret := deriveSecret(secret, "quic hp", 16)
+func setupKeys(secret []byte, params *versionParams) *initialKeys {
+	key := deriveSecret(secret, params.keyLabel, 16)
+	iv := deriveSecret(secret, params.ivLabel, 12)
+	hpSecret := deriveSecret(secret, params.hpLabel, 16)
 
 	block, _ := aes.NewCipher(hpSecret)
 
@@ -69,6 +136,7 @@ type ParsedHeader struct {
 	DCID         []byte
 	SCID         []byte
 	PacketNumber int64
+	Token        []byte // Initial packets only
 	Payload      []byte
 	RawHeader    []byte
 	FullLength   int // Full length of the packet including header and payload
@@ -90,15 +158,20 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 		header.Version = binary.BigEndian.Uint32(data[1:5])
 		header.Type = (firstByte & 0x30) >> 4
 
-		// Strictly support Version 1
-		if header.Version != 0x00000001 {
+		// Support QUIC v1 (RFC 9000) and v2 (RFC 9369)
+		params, ok := supportedVersions[header.Version]
+		if !ok {
 			if header.Version == 0x00000000 {
-				return header, errors.New("version negotiation packet")
+				return header, ErrVersionNegotiation
 			}
-			return header, errors.New("unsupported QUIC version")
+			return header, ErrUnsupportedVersion
 		}
+		header.Type = params.packetTypes[header.Type]
 
 		curr := 5
+		if len(data) < curr+1 {
+			return nil, errors.New("insufficient data for DCID length")
+		}
 		dcidLen := int(data[curr])
 		curr++
 		if len(data) < curr+dcidLen {
@@ -107,6 +180,9 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 		header.DCID = data[curr : curr+dcidLen]
 		curr += dcidLen
 
+		if len(data) < curr+1 {
+			return nil, errors.New("insufficient data for SCID length")
+		}
 		scidLen := int(data[curr])
 		curr++
 		if len(data) < curr+scidLen {
@@ -115,7 +191,7 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 		header.SCID = data[curr : curr+scidLen]
 		curr += scidLen
 
-		if header.Type == 0x00 { // Initial
+		if header.Type == PacketTypeInitial {
 			tokenLen, n, err := ReadVarInt(data[curr:])
 			if err != nil {
 				return nil, fmt.Errorf("invalid token length: %v", err)
@@ -124,6 +200,7 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 			if len(data) < curr+int(tokenLen) {
 				return nil, errors.New("insufficient data for token")
 			}
+			header.Token = data[curr : curr+int(tokenLen)]
 			curr += int(tokenLen)
 
 			payloadLen, n, err := ReadVarInt(data[curr:])
@@ -140,7 +217,7 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 			}
 			header.Payload = data[curr : curr+int(payloadLen)]
 			header.FullLength = curr + int(payloadLen)
-		} else if header.Type == 0x01 || header.Type == 0x02 || header.Type == 0x03 {
+		} else if header.Type == PacketType0RTT || header.Type == PacketTypeHandshake || header.Type == PacketTypeRetry {
 			// Handshake, Retry, or 0-RTT also have a length field in many versions
 			// but for now let's at least try to read it if it's there.
 			// RFC 9000: Handshake and 0-RTT also have Length.
@@ -166,35 +243,47 @@ func ParsePacket(data []byte) (*ParsedHeader, error) {
 			header.FullLength = len(data)
 		}
 	} else {
-		// Short Header
-		// We don't know the DCID length here, but usually it's fixed or negotiated.
-		// For the sake of routing, we might need more context.
-		// In a relay, we might assume a certain DCID length or have it from the session.
-		// However, the issue says "Extract the DCID from the incoming packet header".
-		// Short headers don't have a DCID length field.
-		// Standard QUIC uses DCID that was negotiated.
-		// Let's assume we can't fully parse short header without knowing DCID length.
-		header.DCID = data[1 : 1+8] // HEURISTIC: Many implementations use 8 bytes
+		// Short headers do not encode the DCID length, it is only known to
+		// the endpoint that issued the connection ID. Use ShortHeaderDCID once
+		// the length is known.
+		if len(data) < 2 {
+			return nil, errors.New("short header too short")
+		}
 		header.FullLength = len(data)
 	}
 
 	return header, nil
 }
 
+// MaxConnIDLength is the longest connection ID allowed by QUIC version 1.
+const MaxConnIDLength = 20
+
+// ShortHeaderDCID returns the DCID of a short header packet, given the
+// length of the connection IDs issued by the receiving endpoint.
+func ShortHeaderDCID(data []byte, length int) ([]byte, error) {
+	if length < 0 || length > MaxConnIDLength {
+		return nil, fmt.Errorf("invalid connection ID length %d", length)
+	}
+	if len(data) < 1+length || data[0]&0x80 != 0 {
+		return nil, errors.New("not a short header packet with that DCID length")
+	}
+	return data[1 : 1+length], nil
+}
+
 func DecryptInitialPacket(data []byte, dcid []byte) ([]byte, error) {
 	header, err := ParsePacket(data)
 	if err != nil {
 		return nil, err
 	}
-	// Redundant check because ParsePacket already enforces this, but good for safety
-	if header.Version != 0x00000001 {
-		return nil, errors.New("unsupported QUIC version")
-	}
-	if !header.IsLongHeader || header.Type != 0x00 {
+	if !header.IsLongHeader || header.Type != PacketTypeInitial {
 		return nil, errors.New("not an initial packet")
 	}
 
-	clientKeys, _ := deriveInitialKeys(dcid, false)
+	// ParsePacket already rejects unsupported versions, but deriveInitialKeys checks again for safety
+	clientKeys, _, err := deriveInitialKeys(header.Version, dcid)
+	if err != nil {
+		return nil, err
+	}
 
 	// Remove Header Protection
 	// First byte (protected bits) and Packet Number are protected.
@@ -204,7 +293,7 @@ func DecryptInitialPacket(data []byte, dcid []byte) ([]byte, error) {
 	// Sample is taken from the payload. According to RFC 9001, for Initial packets,
 	// the sample starts 4 bytes after the start of the Packet Number field.
 	sampleOffset := pnOffset + 4
-	if len(data) < sampleOffset+16 {
+	if header.FullLength < sampleOffset+16 {
 		return nil, errors.New("packet too short for sample")
 	}
 	sample := data[sampleOffset : sampleOffset+16]
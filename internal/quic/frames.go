@@ -0,0 +1,182 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+)
+
+type FrameType uint64
+
+// Frame types permitted in Initial packets (RFC 9000, Section 12.4).
+const (
+	FrameTypePadding         FrameType = 0x00
+	FrameTypePing            FrameType = 0x01
+	FrameTypeAck             FrameType = 0x02
+	FrameTypeAckECN          FrameType = 0x03
+	FrameTypeCrypto          FrameType = 0x06
+	FrameTypeConnectionClose FrameType = 0x1c
+)
+
+// Frame is a decoded QUIC frame.
+type Frame interface {
+	Type() FrameType
+}
+
+// PaddingFrame represents a run of consecutive PADDING bytes.
+type PaddingFrame struct {
+	Length int
+}
+
+type PingFrame struct{}
+
+type AckRange struct {
+	Gap    uint64
+	Length uint64
+}
+
+type ECNCounts struct {
+	ECT0 uint64
+	ECT1 uint64
+	CE   uint64
+}
+
+type AckFrame struct {
+	LargestAcked  uint64
+	AckDelay      uint64
+	FirstAckRange uint64
+	Ranges        []AckRange
+	ECN           *ECNCounts // Only set for ACK frames of type 0x03
+}
+
+type CryptoFrame struct {
+	Offset uint64
+	Data   []byte
+}
+
+type ConnectionCloseFrame struct {
+	ErrorCode uint64
+	FrameType uint64
+	Reason    string
+}
+
+func (PaddingFrame) Type() FrameType { return FrameTypePadding }
+func (PingFrame) Type() FrameType    { return FrameTypePing }
+func (f AckFrame) Type() FrameType {
+	if f.ECN != nil {
+		return FrameTypeAckECN
+	}
+	return FrameTypeAck
+}
+func (CryptoFrame) Type() FrameType          { return FrameTypeCrypto }
+func (ConnectionCloseFrame) Type() FrameType { return FrameTypeConnectionClose }
+
+// ParseFrames decodes every frame in the decrypted payload of an Initial
+// packet. It fails on truncated frames and on frame types that are not
+// allowed in Initial packets.
+func ParseFrames(payload []byte) ([]Frame, error) {
+	var frames []Frame
+	curr := 0
+	for curr < len(payload) {
+		frame, n, err := ParseFrame(payload[curr:])
+		if err != nil {
+			return frames, fmt.Errorf("frame at offset %d: %w", curr, err)
+		}
+		frames = append(frames, frame)
+		curr += n
+	}
+	return frames, nil
+}
+
+// ParseFrame decodes the frame at the start of data and returns it along
+// with the number of bytes it occupies.
+func ParseFrame(data []byte) (Frame, int, error) {
+	frameType, n, err := ReadVarInt(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid frame type: %v", err)
+	}
+
+	switch FrameType(frameType) {
+	case FrameTypePadding:
+		length := n
+		for length < len(data) && data[length] == 0x00 {
+			length++
+		}
+		return PaddingFrame{Length: length}, length, nil
+	case FrameTypePing:
+		return PingFrame{}, n, nil
+	case FrameTypeAck, FrameTypeAckECN:
+		return parseAckFrame(data, n, FrameType(frameType) == FrameTypeAckECN)
+	case FrameTypeCrypto:
+		offset, cryptoData, length, err := parseCryptoFrame(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return CryptoFrame{Offset: offset, Data: cryptoData}, n + length, nil
+	case FrameTypeConnectionClose:
+		return parseConnectionCloseFrame(data, n)
+	default:
+		return nil, 0, fmt.Errorf("frame type %#x not allowed in Initial packets", frameType)
+	}
+}
+
+// readVarInts reads consecutive variable-length integers starting at curr.
+func readVarInts(data []byte, curr int, vals ...*uint64) (int, error) {
+	for _, v := range vals {
+		val, n, err := ReadVarInt(data[curr:])
+		if err != nil {
+			return 0, err
+		}
+		*v = val
+		curr += n
+	}
+	return curr, nil
+}
+
+func parseAckFrame(data []byte, curr int, hasECN bool) (Frame, int, error) {
+	var frame AckFrame
+	var rangeCount uint64
+
+	curr, err := readVarInts(data, curr, &frame.LargestAcked, &frame.AckDelay, &rangeCount, &frame.FirstAckRange)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid ACK frame: %v", err)
+	}
+
+	// Every range takes at least two bytes, which bounds the loop on bogus counts.
+	if rangeCount > uint64(len(data)-curr)/2 {
+		return nil, 0, errors.New("invalid ACK frame: range count exceeds frame data")
+	}
+	for i := uint64(0); i < rangeCount; i++ {
+		var r AckRange
+		curr, err = readVarInts(data, curr, &r.Gap, &r.Length)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid ACK range: %v", err)
+		}
+		frame.Ranges = append(frame.Ranges, r)
+	}
+
+	if hasECN {
+		frame.ECN = &ECNCounts{}
+		curr, err = readVarInts(data, curr, &frame.ECN.ECT0, &frame.ECN.ECT1, &frame.ECN.CE)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid ACK ECN counts: %v", err)
+		}
+	}
+
+	return frame, curr, nil
+}
+
+func parseConnectionCloseFrame(data []byte, curr int) (Frame, int, error) {
+	var frame ConnectionCloseFrame
+	var reasonLen uint64
+
+	curr, err := readVarInts(data, curr, &frame.ErrorCode, &frame.FrameType, &reasonLen)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid CONNECTION_CLOSE frame: %v", err)
+	}
+	if uint64(len(data)-curr) < reasonLen {
+		return nil, 0, errors.New("CONNECTION_CLOSE reason phrase too short")
+	}
+
+	frame.Reason = string(data[curr : curr+int(reasonLen)])
+	return frame, curr + int(reasonLen), nil
+}
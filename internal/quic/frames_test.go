@@ -0,0 +1,123 @@
+package quic
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestParseFrames(t *testing.T) {
+	payload := []byte{
+		0x01,                                     // PING
+		0x02, 0x06, 0x06, 0x01, 0x06, 0x00, 0x06, // ACK: largest 6, delay 6, 1 range, first 6, gap 0, len 6
+		0x03, 0x05, 0x00, 0x00, 0x01, 0x01, 0x02, 0x03, // ACK with ECN counts
+		0x06, 0x00, 0x03, 'a', 'b', 'c', // CRYPTO offset 0, length 3
+		0x1c, 0x0a, 0x06, 0x02, 'n', 'o', // CONNECTION_CLOSE
+		0x00, 0x00, 0x00, // PADDING
+	}
+
+	frames, err := ParseFrames(payload)
+	if err != nil {
+		t.Fatalf("ParseFrames failed: %v", err)
+	}
+
+	want := []Frame{
+		PingFrame{},
+		AckFrame{LargestAcked: 6, AckDelay: 6, FirstAckRange: 6, Ranges: []AckRange{{Gap: 0, Length: 6}}},
+		AckFrame{LargestAcked: 5, FirstAckRange: 1, ECN: &ECNCounts{ECT0: 1, ECT1: 2, CE: 3}},
+		CryptoFrame{Offset: 0, Data: []byte("abc")},
+		ConnectionCloseFrame{ErrorCode: 0x0a, FrameType: 0x06, Reason: "no"},
+		PaddingFrame{Length: 3},
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("ParseFrames mismatch.\nGot  %+v\nWant %+v", frames, want)
+	}
+	if frames[2].Type() != FrameTypeAckECN {
+		t.Errorf("Expected ACK_ECN type, got %#x", frames[2].Type())
+	}
+}
+
+func TestParseFramesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"truncated CRYPTO", []byte{0x06, 0x00, 0x05, 'a'}},
+		{"truncated ACK", []byte{0x02, 0x01}},
+		{"bogus ACK range count", []byte{0x02, 0x01, 0x00, 0x3f, 0x00}},
+		{"truncated reason", []byte{0x1c, 0x00, 0x00, 0x05, 'a'}},
+		{"STREAM not allowed", []byte{0x08, 0x00}},
+		{"application close not allowed", []byte{0x1d, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFrames(tt.payload); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestExtractSNIIgnoresCryptoTypeInOtherFrames(t *testing.T) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	crypto, _ := hex.DecodeString(rfc9001ClientCrypto)
+
+	// An ACK whose fields are all 0x06 precedes the CRYPTO frame. A byte
+	// scanner would mistake them for CRYPTO frames.
+	frames := append([]byte{0x01, 0x02, 0x06, 0x06, 0x00, 0x06}, crypto...)
+	packet := sealInitialPacket(t, Version1, dcid, 0, frames)
+
+	sni, err := ExtractSNI(packet)
+	if err != nil {
+		t.Fatalf("ExtractSNI failed: %v", err)
+	}
+	if sni != "example.com" {
+		t.Errorf("Expected example.com, got %s", sni)
+	}
+}
+
+func FuzzParseFrames(f *testing.F) {
+	crypto, _ := hex.DecodeString(rfc9001ClientCrypto)
+	f.Add(crypto)
+	f.Add([]byte{0x01, 0x02, 0x06, 0x06, 0x01, 0x06, 0x00, 0x06, 0x00, 0x00})
+	f.Add([]byte{0x03, 0x05, 0x00, 0x00, 0x01, 0x01, 0x02, 0x03})
+	f.Add([]byte{0x1c, 0x0a, 0x06, 0x02, 'n', 'o'})
+	f.Add([]byte{0xc0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames, err := ParseFrames(data)
+		if err != nil {
+			return
+		}
+
+		// A successful parse must account for every byte of the payload.
+		total := 0
+		for _, frame := range frames {
+			_, n, err := ParseFrame(data[total:])
+			if err != nil {
+				t.Fatalf("ParseFrame failed on re-walk: %v", err)
+			}
+			if frame.Type() == FrameTypeCrypto && len(frame.(CryptoFrame).Data) > len(data) {
+				t.Fatalf("CRYPTO data longer than payload")
+			}
+			total += n
+		}
+		if total != len(data) {
+			t.Fatalf("Frames cover %d bytes, payload is %d", total, len(data))
+		}
+	})
+}
+
+func FuzzClientHelloAssembler(f *testing.F) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	f.Add([]byte{0xc3, 0x00, 0x00, 0x00, 0x01, 0x08})
+	f.Add([]byte{0x06, 0x00, 0x04, 0x01, 0x00, 0x00, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = NewClientHelloAssembler(dcid).AddPacket(data)
+		_, _ = ExtractSNIFromClientHello(data)
+		ca := NewCryptoAssembler()
+		_, _ = ca.HandleFrame(data)
+	})
+}
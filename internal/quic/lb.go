@@ -0,0 +1,182 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// QUIC-LB (draft-ietf-quic-load-balancers) routable connection IDs.
+//
+// A routable CID is a first octet followed by an encoded server ID and
+// nonce. The first octet carries the config rotation bits in its three most
+// significant bits and the CID length minus one in the rest. The server ID
+// and nonce are either sent as plaintext, encrypted with a single AES-ECB
+// pass when they are exactly 16 bytes long, or encrypted with a four-pass
+// Feistel network using AES-ECB as the round function otherwise.
+
+// LBUnroutableConfigID marks connection IDs that do not encode a server ID.
+const LBUnroutableConfigID = 7
+
+var ErrUnroutableConnID = errors.New("connection ID is not routable")
+
+// LBConfig is one QUIC-LB configuration, identified by its config rotation
+// bits.
+type LBConfig struct {
+	ID             uint8
+	ServerIDLength int
+	NonceLength    int
+
+	block cipher.Block // nil for plaintext CIDs
+}
+
+// NewLBConfig validates a QUIC-LB configuration. An empty key selects
+// plaintext CIDs, otherwise the key must be 16 bytes.
+func NewLBConfig(id uint8, serverIDLength, nonceLength int, key []byte) (*LBConfig, error) {
+	if id >= LBUnroutableConfigID {
+		return nil, fmt.Errorf("config ID %d is out of range", id)
+	}
+	if serverIDLength < 1 || serverIDLength > 15 {
+		return nil, fmt.Errorf("server ID length %d is out of range", serverIDLength)
+	}
+	if nonceLength < 4 || nonceLength > 18 {
+		return nil, fmt.Errorf("nonce length %d is out of range", nonceLength)
+	}
+	if serverIDLength+nonceLength > MaxConnIDLength-1 {
+		return nil, fmt.Errorf("server ID and nonce exceed %d bytes", MaxConnIDLength-1)
+	}
+
+	c := &LBConfig{ID: id, ServerIDLength: serverIDLength, NonceLength: nonceLength}
+	if len(key) > 0 {
+		if len(key) != 16 {
+			return nil, errors.New("QUIC-LB key must be 16 bytes")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		c.block = block
+	}
+	return c, nil
+}
+
+// LBConfigID returns the config rotation bits of a connection ID.
+func LBConfigID(cid []byte) uint8 {
+	if len(cid) == 0 {
+		return LBUnroutableConfigID
+	}
+	return cid[0] >> 5
+}
+
+// ConnIDLength returns the length of the connection IDs of this config.
+func (c *LBConfig) ConnIDLength() int {
+	return 1 + c.ServerIDLength + c.NonceLength
+}
+
+// EncodeConnID builds a routable connection ID for the server ID.
+func (c *LBConfig) EncodeConnID(serverID, nonce []byte) ([]byte, error) {
+	if len(serverID) != c.ServerIDLength || len(nonce) != c.NonceLength {
+		return nil, errors.New("server ID or nonce has the wrong length")
+	}
+
+	plaintext := append(append([]byte{}, serverID...), nonce...)
+	cid := make([]byte, 0, c.ConnIDLength())
+	cid = append(cid, c.ID<<5|byte(c.ConnIDLength()-1))
+
+	switch {
+	case c.block == nil:
+		return append(cid, plaintext...), nil
+	case len(plaintext) == aes.BlockSize:
+		c.block.Encrypt(plaintext, plaintext)
+		return append(cid, plaintext...), nil
+	default:
+		return append(cid, c.fourPass(plaintext, false)...), nil
+	}
+}
+
+// DecodeServerID extracts the server ID from a connection ID of this config.
+func (c *LBConfig) DecodeServerID(cid []byte) ([]byte, error) {
+	if len(cid) != c.ConnIDLength() {
+		return nil, fmt.Errorf("connection ID is %d bytes, config %d expects %d", len(cid), c.ID, c.ConnIDLength())
+	}
+	if LBConfigID(cid) != c.ID {
+		return nil, ErrUnroutableConnID
+	}
+
+	plaintext := append([]byte{}, cid[1:]...)
+	switch {
+	case c.block == nil:
+	case len(plaintext) == aes.BlockSize:
+		c.block.Decrypt(plaintext, plaintext)
+	default:
+		plaintext = c.fourPass(plaintext, true)
+	}
+	return plaintext[:c.ServerIDLength], nil
+}
+
+// fourPass runs the four-round Feistel network over data. For odd lengths the
+// halves share the middle byte: left owns its high nibble, right its low one.
+func (c *LBConfig) fourPass(data []byte, decrypt bool) []byte {
+	n := len(data)
+	half := (n + 1) / 2
+	odd := n%2 == 1
+
+	left := append([]byte{}, data[:half]...)
+	right := append([]byte{}, data[n-half:]...)
+	if odd {
+		left[half-1] &= 0xf0
+		right[0] &= 0x0f
+	}
+
+	// round returns AES-ECB of the half expanded to a block: the plaintext
+	// length, the pass number, then the half zero-padded.
+	round := func(x []byte, pass byte) []byte {
+		block := make([]byte, aes.BlockSize)
+		block[0] = byte(n)
+		block[1] = pass
+		copy(block[2:], x)
+		c.block.Encrypt(block, block)
+		return block
+	}
+	mixLeft := func(pass byte) {
+		out := round(right, pass)[:half]
+		if odd {
+			out[half-1] &= 0xf0
+		}
+		xorBytes(left, out)
+	}
+	mixRight := func(pass byte) {
+		out := round(left, pass)[:half]
+		if odd {
+			out[0] &= 0x0f
+		}
+		xorBytes(right, out)
+	}
+
+	if decrypt {
+		mixLeft(4)
+		mixRight(3)
+		mixLeft(2)
+		mixRight(1)
+	} else {
+		mixRight(1)
+		mixLeft(2)
+		mixRight(3)
+		mixLeft(4)
+	}
+
+	out := make([]byte, 0, n)
+	if odd {
+		out = append(out, left[:half-1]...)
+		out = append(out, left[half-1]|right[0])
+		return append(out, right[1:]...)
+	}
+	return append(append(out, left...), right...)
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
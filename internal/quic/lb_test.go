@@ -0,0 +1,146 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestLBConfigRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name        string
+		sidLen      int
+		nonceLen    int
+		key         []byte
+		wantVisible bool // server ID appears in the clear
+	}{
+		{"plaintext", 2, 6, nil, true},
+		{"single pass", 8, 8, key, false},
+		{"four pass even", 4, 6, key, false},
+		{"four pass odd", 3, 4, key, false},
+		{"four pass max", 15, 4, key, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewLBConfig(2, tt.sidLen, tt.nonceLen, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 50; i++ {
+				sid := bytes.Repeat([]byte{byte(i + 1)}, tt.sidLen)
+				nonce := bytes.Repeat([]byte{byte(0xf0 - i)}, tt.nonceLen)
+
+				cid, err := cfg.EncodeConnID(sid, nonce)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(cid) != cfg.ConnIDLength() {
+					t.Fatalf("CID length = %d, want %d", len(cid), cfg.ConnIDLength())
+				}
+				if LBConfigID(cid) != 2 || int(cid[0]&0x1f) != len(cid)-1 {
+					t.Fatalf("first octet = %08b", cid[0])
+				}
+				if visible := bytes.Equal(cid[1:1+tt.sidLen], sid); visible != tt.wantVisible {
+					t.Fatalf("server ID visible = %v in %x", visible, cid)
+				}
+
+				got, err := cfg.DecodeServerID(cid)
+				if err != nil || !bytes.Equal(got, sid) {
+					t.Fatalf("DecodeServerID(%x) = %x, %v, want %x", cid, got, err, sid)
+				}
+			}
+		})
+	}
+}
+
+// TestLBConfigDraftVectors checks the test vectors from the appendix of
+// draft-ietf-quic-load-balancers.
+func TestLBConfigDraftVectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       uint8
+		key      string
+		serverID string
+		nonce    string
+		cid      string
+	}{
+		{"plaintext", 0, "", "c4605e", "4504cc4f", "07c4605e4504cc4f"},
+		{"four pass odd short", 0, "8f95f09245765f80256934e50c66207f", "ed793a", "ee080dbf", "074126ee38bf5454"},
+		{"four pass odd long", 2, "8f95f09245765f80256934e50c66207f", "ed793a51d49b8f5fab65", "ee080dbf48", "4fcd3f572d4eefb046fdb51d164efccc"},
+		{"single pass", 4, "8f95f09245765f80256934e50c66207f", "ed793a51d49b8f5f", "ee080dbf48c0d1e5", "904dd2d05a7b0de9b2b9907afb5ecf8cc3"},
+		{"four pass even", 0, "8f95f09245765f80256934e50c66207f", "ed793a51d49b8f5fab", "ee080dbf48c0d1e55d", "12124d1eb8fbb21e4a490ca53cfe21d04ae63a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+			sid, _ := hex.DecodeString(tt.serverID)
+			nonce, _ := hex.DecodeString(tt.nonce)
+			want, _ := hex.DecodeString(tt.cid)
+
+			cfg, err := NewLBConfig(tt.id, len(sid), len(nonce), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cid, err := cfg.EncodeConnID(sid, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cid, want) {
+				t.Errorf("EncodeConnID = %x, want %x", cid, want)
+			}
+			got, err := cfg.DecodeServerID(want)
+			if err != nil || !bytes.Equal(got, sid) {
+				t.Errorf("DecodeServerID(%s) = %x, %v, want %x", tt.cid, got, err, sid)
+			}
+		})
+	}
+}
+
+func TestLBConfigDecodeErrors(t *testing.T) {
+	cfg, err := NewLBConfig(1, 3, 4, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid, _ := cfg.EncodeConnID([]byte{1, 2, 3}, []byte{4, 5, 6, 7})
+
+	if _, err := cfg.DecodeServerID(cid[:len(cid)-1]); err == nil {
+		t.Error("Expected an error for a short CID")
+	}
+
+	other := append([]byte{}, cid...)
+	other[0] = 0xe0 | other[0]&0x1f
+	if _, err := cfg.DecodeServerID(other); err != ErrUnroutableConnID {
+		t.Errorf("DecodeServerID(unroutable) error = %v, want ErrUnroutableConnID", err)
+	}
+
+	wrongKey, _ := NewLBConfig(1, 3, 4, []byte("fedcba9876543210"))
+	if sid, err := wrongKey.DecodeServerID(cid); err == nil && bytes.Equal(sid, []byte{1, 2, 3}) {
+		t.Error("Expected a different key to decode a different server ID")
+	}
+}
+
+func TestNewLBConfigValidation(t *testing.T) {
+	invalid := []struct {
+		id       uint8
+		sidLen   int
+		nonceLen int
+		key      []byte
+	}{
+		{7, 2, 6, nil},
+		{0, 0, 6, nil},
+		{0, 16, 4, nil},
+		{0, 2, 3, nil},
+		{0, 10, 10, nil},
+		{0, 2, 6, []byte("short")},
+	}
+	for _, tt := range invalid {
+		if _, err := NewLBConfig(tt.id, tt.sidLen, tt.nonceLen, tt.key); err == nil {
+			t.Errorf("NewLBConfig(%d, %d, %d, %q) succeeded, want error", tt.id, tt.sidLen, tt.nonceLen, tt.key)
+		}
+	}
+}
@@ -6,67 +6,141 @@ import (
 	"fmt"
 )
 
+// MaxCryptoBufferSize bounds how far into the CRYPTO stream the assembler
+// will buffer data. A ClientHello is far smaller than this even with
+// post-quantum key shares and ECH.
+const MaxCryptoBufferSize = 64 * 1024
+
+// CryptoAssembler reassembles the Initial CRYPTO stream from frames that may
+// arrive at any offset, in any order, across multiple packets.
 type CryptoAssembler struct {
 	buffer []byte
-	offset uint64
+	ranges []byteRange // Received ranges, sorted and non-overlapping
+}
+
+type byteRange struct {
+	start, end uint64
 }
 
 func NewCryptoAssembler() *CryptoAssembler {
 	return &CryptoAssembler{
 		buffer: make([]byte, 0),
-		offset: 0,
 	}
 }
 
-func (ca *CryptoAssembler) HandleFrame(data []byte) ([]byte, error) {
+// parseCryptoFrame decodes the body of a CRYPTO frame following its type,
+// returning its stream offset, its data and the encoded length of the body.
+func parseCryptoFrame(data []byte) (uint64, []byte, int, error) {
 	// A CRYPTO frame:
 	// 0x06 (type)
 	// Offset (variable length integer)
 	// Length (variable length integer)
 	// Data
-	if len(data) < 1 || data[0] != 0x06 {
-		return nil, nil // Not a CRYPTO frame
+	offset, curr, err := ReadVarInt(data)
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("invalid offset in CRYPTO frame: %v", err)
 	}
 
-	curr := 1
-	offset, n, err := ReadVarInt(data[curr:])
+	length, n, err := ReadVarInt(data[curr:])
 	if err != nil {
-		return nil, fmt.Errorf("invalid offset in CRYPTO frame: %v", err)
+		return 0, nil, 0, fmt.Errorf("invalid length in CRYPTO frame: %v", err)
 	}
 	curr += n
 
-	length, n, err := ReadVarInt(data[curr:])
+	if uint64(len(data)-curr) < length {
+		return 0, nil, 0, errors.New("CRYPTO frame data too short")
+	}
+
+	return offset, data[curr : curr+int(length)], curr + int(length), nil
+}
+
+// HandleFrame adds a CRYPTO frame to the stream and returns the contiguous
+// data received so far, starting at offset 0.
+func (ca *CryptoAssembler) HandleFrame(data []byte) ([]byte, error) {
+	if len(data) < 1 || data[0] != 0x06 {
+		return nil, nil // Not a CRYPTO frame
+	}
+
+	frame, _, err := ParseFrame(data)
 	if err != nil {
-		return nil, fmt.Errorf("invalid length in CRYPTO frame: %v", err)
+		return nil, err
 	}
-	curr += n
 
-	if len(data) < curr+int(length) {
-		return nil, errors.New("CRYPTO frame data too short")
+	crypto := frame.(CryptoFrame)
+	if err := ca.Insert(crypto.Offset, crypto.Data); err != nil {
+		return nil, err
 	}
+	return ca.Contiguous(), nil
+}
 
-	frameData := data[curr : curr+int(length)]
+// Insert stores stream data at the given offset. Data overlapping ranges
+// that were already received is ignored in favor of the earlier copy.
+func (ca *CryptoAssembler) Insert(offset uint64, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	end := offset + uint64(len(data))
+	if offset > MaxCryptoBufferSize || end > MaxCryptoBufferSize {
+		return fmt.Errorf("CRYPTO frame exceeds buffer limit: offset %d, length %d", offset, len(data))
+	}
 
-	// Simple assembly: we expect frames to be in order for Initial
-	// If they are not, this simplified assembler might fail.
-	// But usually Initial CRYPTO frames are small and often fit in one packet.
+	if uint64(len(ca.buffer)) < end {
+		ca.buffer = append(ca.buffer, make([]byte, end-uint64(len(ca.buffer)))...)
+	}
 
-	if offset == ca.offset {
-		ca.buffer = append(ca.buffer, frameData...)
-		ca.offset += length
-	} else if offset < ca.offset {
-		// Duplicate or overlap, ignore for simplicity if it doesn't extend
-		end := offset + length
-		if end > ca.offset {
-			overlap := ca.offset - offset
-			ca.buffer = append(ca.buffer, frameData[overlap:]...)
-			ca.offset = end
+	// Copy only the gaps so previously received bytes are never overwritten.
+	pos := offset
+	for _, r := range ca.ranges {
+		if r.end <= pos {
+			continue
+		}
+		if r.start >= end {
+			break
+		}
+		if r.start > pos {
+			copy(ca.buffer[pos:r.start], data[pos-offset:r.start-offset])
 		}
-	} else {
-		return nil, fmt.Errorf("out of order CRYPTO frame: expected %d, got %d", ca.offset, offset)
+		pos = max(pos, r.end)
+	}
+	if pos < end {
+		copy(ca.buffer[pos:end], data[pos-offset:])
 	}
 
-	return ca.buffer, nil
+	ca.addRange(byteRange{start: offset, end: end})
+	return nil
+}
+
+// addRange merges r into the sorted list of received ranges.
+func (ca *CryptoAssembler) addRange(r byteRange) {
+	merged := make([]byteRange, 0, len(ca.ranges)+1)
+	inserted := false
+	for _, existing := range ca.ranges {
+		switch {
+		case existing.end < r.start:
+			merged = append(merged, existing)
+		case existing.start > r.end:
+			if !inserted {
+				merged = append(merged, r)
+				inserted = true
+			}
+			merged = append(merged, existing)
+		default:
+			r.start = min(r.start, existing.start)
+			r.end = max(r.end, existing.end)
+		}
+	}
+	if !inserted {
+		merged = append(merged, r)
+	}
+	ca.ranges = merged
+}
+
+// Contiguous returns the stream data from offset 0 up to the first gap.
+func (ca *CryptoAssembler) Contiguous() []byte {
+	if len(ca.ranges) == 0 || ca.ranges[0].start != 0 {
+		return ca.buffer[:0]
+	}
+	return ca.buffer[:ca.ranges[0].end]
 }
 
 func ReadVarInt(data []byte) (uint64, int, error) {
@@ -91,15 +165,23 @@ func ReadVarInt(data []byte) (uint64, int, error) {
 	return val, length, nil
 }
 
+// ErrClientHelloIncomplete is returned when the ClientHello continues in
+// CRYPTO data that has not been received yet.
+var ErrClientHelloIncomplete = errors.New("ClientHello incomplete")
+
 func ExtractSNIFromClientHello(data []byte) (string, error) {
 	// TLS ClientHello starts after the Handshake header
 	// Handshake Type (1 byte) + Length (3 bytes)
 	if len(data) < 4 {
-		return "", errors.New("too short for TLS Handshake")
+		return "", ErrClientHelloIncomplete
 	}
 	if data[0] != 0x01 { // ClientHello
 		return "", errors.New("not a ClientHello")
 	}
+	helloLen := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data) < 4+helloLen {
+		return "", ErrClientHelloIncomplete
+	}
 
 	curr := 4
 	if len(data) < curr+2 {
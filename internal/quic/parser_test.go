@@ -1,6 +1,7 @@
 package quic
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
 )
@@ -8,7 +9,10 @@ import (
 func TestDeriveInitialKeys(t *testing.T) {
 	// Standard test vector from RFC 9001 Appendix A.1
 	dcid, _ := hex.DecodeString("8394c8f03e515708")
-	clientKeys, _ := deriveInitialKeys(dcid, false)
+	clientKeys, _, err := deriveInitialKeys(Version1, dcid)
+	if err != nil {
+		t.Fatalf("deriveInitialKeys failed: %v", err)
+	}
 
 	if len(clientKeys.key) != 16 {
 		t.Errorf("Expected 16 byte key, got %d", len(clientKeys.key))
@@ -36,6 +40,59 @@ func TestDeriveInitialKeys(t *testing.T) {
 	}
 }
 
+func TestDeriveInitialKeysV2(t *testing.T) {
+	// Test vectors from RFC 9369 Appendix A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	clientKeys, serverKeys, err := deriveInitialKeys(Version2, dcid)
+	if err != nil {
+		t.Fatalf("deriveInitialKeys failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"client key", clientKeys.key, "8b1a0bc121284290a29e0971b5cd045d"},
+		{"client iv", clientKeys.iv, "91f73e2351d8fa91660e909f"},
+		{"client hp", clientKeys.hp, "45b95e15235d6f45a6b19cbcb0294ba9"},
+		{"server key", serverKeys.key, "82db637861d55e1d011f19ea71d5d2a7"},
+		{"server iv", serverKeys.iv, "dd13c276499c0249d3310652"},
+		{"server hp", serverKeys.hp, "edf6d05c83121201b436e16877593c3a"},
+	}
+
+	for _, tt := range tests {
+		if hex.EncodeToString(tt.got) != tt.want {
+			t.Errorf("%s mismatch. Got %x, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestParsePacketV2PacketTypes(t *testing.T) {
+	// QUIC v2 remaps the long header type bits: Initial is 0b01, 0-RTT 0b10,
+	// Handshake 0b11 and Retry 0b00.
+	tests := []struct {
+		firstByte byte
+		want      byte
+	}{
+		{0xd0, PacketTypeInitial},
+		{0xe0, PacketType0RTT},
+		{0xf0, PacketTypeHandshake},
+		{0xc0, PacketTypeRetry},
+	}
+
+	for _, tt := range tests {
+		data := []byte{tt.firstByte, 0x6b, 0x33, 0x43, 0xcf, 0x00, 0x00, 0x00, 0x01, 0x00}
+		header, err := ParsePacket(data)
+		if err != nil {
+			t.Fatalf("ParsePacket(%#x) failed: %v", tt.firstByte, err)
+		}
+		if header.Type != tt.want {
+			t.Errorf("ParsePacket(%#x) type = %d, want %d", tt.firstByte, header.Type, tt.want)
+		}
+	}
+}
+
 func TestParsePacketUnsupportedVersion(t *testing.T) {
 	// Greased version or unsupported version
 	data := []byte{0x80, 0x8d, 0xb3, 0x3e, 0x9b, 0x00}
@@ -119,3 +176,73 @@ func TestReadVarInt(t *testing.T) {
 		})
 	}
 }
+
+func TestCryptoAssemblerOutOfOrder(t *testing.T) {
+	ca := NewCryptoAssembler()
+
+	if err := ca.Insert(6, []byte("world")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if got := ca.Contiguous(); len(got) != 0 {
+		t.Errorf("Expected no contiguous data with a gap at 0, got %q", got)
+	}
+
+	if err := ca.Insert(0, []byte("hel")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if got := string(ca.Contiguous()); got != "hel" {
+		t.Errorf("Expected %q, got %q", "hel", got)
+	}
+
+	// Overlapping data must not overwrite bytes already received.
+	if err := ca.Insert(2, []byte("XXo ")); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if got := string(ca.Contiguous()); got != "helXo world" {
+		t.Errorf("Expected %q, got %q", "helXo world", got)
+	}
+
+	if err := ca.Insert(MaxCryptoBufferSize, []byte{0x01}); err == nil {
+		t.Error("Expected error for data beyond the buffer limit")
+	}
+}
+
+func TestCryptoAssemblerHandleFrame(t *testing.T) {
+	ca := NewCryptoAssembler()
+
+	// CRYPTO frame at offset 3 arrives before the one at offset 0.
+	if got, err := ca.HandleFrame([]byte{0x06, 0x03, 0x02, 'l', 'o'}); err != nil || len(got) != 0 {
+		t.Fatalf("HandleFrame = %q, %v", got, err)
+	}
+	got, err := ca.HandleFrame([]byte{0x06, 0x00, 0x03, 'h', 'e', 'l'})
+	if err != nil {
+		t.Fatalf("HandleFrame failed: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("Expected hello, got %q", got)
+	}
+}
+
+func TestShortHeaderDCID(t *testing.T) {
+	packet := []byte{0x41, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	header, err := ParsePacket(packet)
+	if err != nil {
+		t.Fatalf("ParsePacket() error = %v", err)
+	}
+	if header.IsLongHeader || header.DCID != nil || header.FullLength != len(packet) {
+		t.Errorf("ParsePacket() = %+v, want short header without DCID", header)
+	}
+
+	for _, length := range []int{0, 4, 8} {
+		dcid, err := ShortHeaderDCID(packet, length)
+		if err != nil || !bytes.Equal(dcid, packet[1:1+length]) {
+			t.Errorf("ShortHeaderDCID(%d) = %x, %v", length, dcid, err)
+		}
+	}
+	for _, length := range []int{-1, 10, 21} {
+		if _, err := ShortHeaderDCID(packet, length); err == nil {
+			t.Errorf("ShortHeaderDCID(%d) succeeded, want error", length)
+		}
+	}
+}
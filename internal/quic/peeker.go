@@ -1,57 +1,82 @@
 package quic
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 )
 
-// ExtractSNI attempts to extract the SNI from a QUIC Initial packet.
-func ExtractSNI(data []byte) (string, error) {
+var (
+	ErrDecryptFailed    = errors.New("failed to decrypt Initial packet")
+	ErrMalformedPayload = errors.New("malformed Initial packet payload")
+	ErrInvalidHello     = errors.New("invalid ClientHello")
+)
+
+// ClientHelloAssembler collects the CRYPTO stream of a connection from one or
+// more client Initial packets until the ClientHello is complete. Large
+// ClientHellos (post-quantum key shares, ECH) often span several Initials.
+type ClientHelloAssembler struct {
+	dcid   []byte
+	crypto *CryptoAssembler
+}
+
+// NewClientHelloAssembler creates an assembler for the connection whose client
+// Initial packets carry the given original Destination Connection ID.
+func NewClientHelloAssembler(dcid []byte) *ClientHelloAssembler {
+	return &ClientHelloAssembler{
+		dcid:   bytes.Clone(dcid),
+		crypto: NewCryptoAssembler(),
+	}
+}
+
+// AddPacket decrypts a client Initial packet and feeds its CRYPTO frames into
+// the stream. It returns the SNI once the ClientHello is complete, or
+// ErrClientHelloIncomplete if further packets are needed.
+func (a *ClientHelloAssembler) AddPacket(data []byte) (string, error) {
 	header, err := ParsePacket(data)
 	if err != nil {
 		return "", err
 	}
 
-	if !header.IsLongHeader || header.Type != 0x00 {
+	if !header.IsLongHeader || header.Type != PacketTypeInitial {
 		return "", errors.New("not a QUIC Initial packet")
 	}
 
-	decrypted, err := DecryptInitialPacket(data, header.DCID)
+	decrypted, err := DecryptInitialPacket(data, a.dcid)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt Initial packet: %v", err)
-	}
-
-	assembler := NewCryptoAssembler()
-	// An Initial packet can contain multiple frames. We need to find the CRYPTO frame.
-	// In a real implementation, we'd loop over frames.
-	// For simplicity, let's look for the 0x06 frame type in the decrypted payload.
-
-	curr := 0
-	for curr < len(decrypted) {
-		frameType := decrypted[curr]
-		if frameType == 0x06 {
-			assembled, err := assembler.HandleFrame(decrypted[curr:])
-			if err != nil {
-				// Try to continue searching for other CRYPTO frames if this one was invalid
-				// but usually there's only one relevant one per packet.
-				curr++
-				continue
-			}
-			if assembled != nil {
-				sni, err := ExtractSNIFromClientHello(assembled)
-				if err == nil {
-					return sni, nil
-				}
-				// If SNI not found yet, it might be in the next packet (if assembled is incomplete)
-				// but for Initial we often have it in one.
+		return "", fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+
+	frames, err := ParseFrames(decrypted)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+
+	for _, frame := range frames {
+		if crypto, ok := frame.(CryptoFrame); ok {
+			if err := a.crypto.Insert(crypto.Offset, crypto.Data); err != nil {
+				return "", err
 			}
-			// Move curr forward by frame length?
-			// CRYPTO frame: 0x06 + offset (vint) + length (vint) + data
-			// We can't easily skip it without re-parsing vint.
-			// Let's just curr++ and hope for the best in this simplified parser.
 		}
-		curr++
 	}
 
-	return "", errors.New("SNI not found in decrypted Initial packet")
+	sni, err := ExtractSNIFromClientHello(a.crypto.Contiguous())
+	if err != nil && !errors.Is(err, ErrClientHelloIncomplete) {
+		return "", fmt.Errorf("%w: %v", ErrInvalidHello, err)
+	}
+	return sni, err
+}
+
+// ExtractSNI attempts to extract the SNI from a single QUIC Initial packet.
+func ExtractSNI(data []byte) (string, error) {
+	header, err := ParsePacket(data)
+	if err != nil {
+		return "", err
+	}
+
+	sni, err := NewClientHelloAssembler(header.DCID).AddPacket(data)
+	if errors.Is(err, ErrClientHelloIncomplete) {
+		return "", errors.New("SNI not found in decrypted Initial packet")
+	}
+	return sni, err
 }
@@ -1,11 +1,136 @@
 package quic
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"testing"
 )
 
+// rfc9001ClientCrypto is the CRYPTO frame carrying the ClientHello from
+// RFC 9001 Appendix A.2, which RFC 9369 Appendix A.2 reuses for QUIC v2.
+const rfc9001ClientCrypto = "060040f1010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e868" +
+	"04fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578" +
+	"616d706c652e636f6dff01000100000a00080006001d00170018001000070005" +
+	"04616c706e000500050100000000003300260024001d00209370b2c9caa47fba" +
+	"baf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b000302030400" +
+	"0d0010000e0403050306030203080408050806002d00020101001c0002400100" +
+	"3900320408ffffffffffffffff05048000ffff07048000ffff08011001048000" +
+	"75300901100f088394c8f03e51570806048000ffff"
+
+// sealInitialPacket builds a protected client Initial packet carrying the
+// given frames, padded to 1162 bytes, with a 4-byte packet number.
+func sealInitialPacket(t *testing.T, version uint32, dcid []byte, pn uint32, frames []byte) []byte {
+	t.Helper()
+
+	clientKeys, _, err := deriveInitialKeys(version, dcid)
+	if err != nil {
+		t.Fatalf("deriveInitialKeys failed: %v", err)
+	}
+
+	payload := make([]byte, 1162)
+	copy(payload, frames)
+
+	typeBits := byte(0)
+	for bits, packetType := range supportedVersions[version].packetTypes {
+		if packetType == PacketTypeInitial {
+			typeBits = byte(bits)
+		}
+	}
+
+	header := []byte{0xc0 | typeBits<<4 | 0x03}
+	header = binary.BigEndian.AppendUint32(header, version)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0x00, 0x00) // SCID length, token length
+	header = binary.BigEndian.AppendUint16(header, 0x4000|uint16(4+len(payload)+16))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint32(header, pn)
+
+	block, _ := aes.NewCipher(clientKeys.key)
+	aesgcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(pn))
+	for i := range nonce {
+		nonce[i] ^= clientKeys.iv[i]
+	}
+
+	packet := aesgcm.Seal(header, nonce, payload, header)
+
+	mask := make([]byte, 16)
+	clientKeys.header.Encrypt(mask, packet[pnOffset+4:pnOffset+20])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < 4; i++ {
+		packet[pnOffset+i] ^= mask[i+1]
+	}
+
+	return packet
+}
+
 func TestExtractSNI(t *testing.T) {
-	// We need a REAL encrypted QUIC Initial packet for this to pass now.
-	// Since generating one is complex, let's skip or provide a way to mock decryption.
-	t.Skip("Skipping because it requires a real encrypted QUIC Initial packet")
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	frames, _ := hex.DecodeString(rfc9001ClientCrypto)
+
+	tests := []struct {
+		name    string
+		version uint32
+		prefix  string
+	}{
+		// Leading bytes of the protected packets in RFC 9001 A.2 and RFC 9369 A.2
+		{"v1", Version1, "c000000001088394c8f03e5157080000449e7b9aec34"},
+		{"v2", Version2, "d76b3343cf088394c8f03e5157080000449ea0c95e82"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := sealInitialPacket(t, tt.version, dcid, 2, frames)
+
+			if got := hex.EncodeToString(packet); !strings.HasPrefix(got, tt.prefix) {
+				t.Errorf("Protected packet mismatch. Got %s..., want %s...", got[:len(tt.prefix)], tt.prefix)
+			}
+
+			sni, err := ExtractSNI(packet)
+			if err != nil {
+				t.Fatalf("ExtractSNI failed: %v", err)
+			}
+			if sni != "example.com" {
+				t.Errorf("Expected example.com, got %s", sni)
+			}
+		})
+	}
+}
+
+func TestClientHelloAssemblerMultiPacket(t *testing.T) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	frame, _ := hex.DecodeString(rfc9001ClientCrypto)
+	hello := frame[4:] // Strip frame type, offset and length
+
+	cryptoFrame := func(offset int, data []byte) []byte {
+		f := []byte{0x06, 0x40 | byte(offset>>8), byte(offset), 0x40 | byte(len(data)>>8), byte(len(data))}
+		return append(f, data...)
+	}
+
+	// Deliver the tail of the ClientHello first, with a PING in front, then the head.
+	second := sealInitialPacket(t, Version1, dcid, 1, append([]byte{0x01}, cryptoFrame(100, hello[100:])...))
+	first := sealInitialPacket(t, Version1, dcid, 0, cryptoFrame(0, hello[:100]))
+
+	assembler := NewClientHelloAssembler(dcid)
+	if _, err := assembler.AddPacket(second); !errors.Is(err, ErrClientHelloIncomplete) {
+		t.Fatalf("Expected ErrClientHelloIncomplete after first packet, got %v", err)
+	}
+
+	sni, err := assembler.AddPacket(first)
+	if err != nil {
+		t.Fatalf("AddPacket failed: %v", err)
+	}
+	if sni != "example.com" {
+		t.Errorf("Expected example.com, got %s", sni)
+	}
+
+	if _, err := ExtractSNI(second); err == nil {
+		t.Error("Expected ExtractSNI to fail on a partial ClientHello")
+	}
 }
@@ -0,0 +1,83 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// RetryIntegrityTagSize is the length of the tag ending every Retry packet.
+const RetryIntegrityTagSize = 16
+
+// NewRetry builds a Retry packet (RFC 9000, Section 17.2.5) sent in response
+// to an Initial with destination connection ID odcid. dcid is the client's
+// SCID, scid the connection ID the client must use from now on, and the
+// client echoes token in its next Initial.
+func NewRetry(version uint32, dcid, scid, odcid, token []byte) ([]byte, error) {
+	params, ok := supportedVersions[version]
+	if !ok {
+		return nil, ErrUnsupportedVersion
+	}
+	if len(dcid) > MaxConnIDLength || len(scid) > MaxConnIDLength || len(odcid) > MaxConnIDLength {
+		return nil, errors.New("connection ID too long")
+	}
+
+	var typeBits byte
+	for bits, t := range params.packetTypes {
+		if t == PacketTypeRetry {
+			typeBits = byte(bits)
+		}
+	}
+
+	packet := make([]byte, 0, 7+len(dcid)+len(scid)+len(token)+RetryIntegrityTagSize)
+	packet = append(packet, 0xc0|typeBits<<4|0x0f) // The low four bits are unused
+	packet = binary.BigEndian.AppendUint32(packet, version)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	packet = append(packet, token...)
+
+	tag, err := retryIntegrityTag(params, odcid, packet)
+	if err != nil {
+		return nil, err
+	}
+	return append(packet, tag...), nil
+}
+
+// VerifyRetry reports whether the Retry packet's integrity tag is valid for
+// an Initial sent with destination connection ID odcid.
+func VerifyRetry(version uint32, odcid, packet []byte) bool {
+	params, ok := supportedVersions[version]
+	if !ok || len(packet) < RetryIntegrityTagSize {
+		return false
+	}
+	body := packet[:len(packet)-RetryIntegrityTagSize]
+	tag, err := retryIntegrityTag(params, odcid, body)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(tag, packet[len(body):]) == 1
+}
+
+// retryIntegrityTag computes the tag of a Retry packet (RFC 9001,
+// Section 5.8): AES-128-GCM with a fixed key and nonce over an empty
+// plaintext, authenticating the Retry pseudo-packet.
+func retryIntegrityTag(params *versionParams, odcid, packet []byte) ([]byte, error) {
+	block, err := aes.NewCipher(params.retryKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudo := make([]byte, 0, 1+len(odcid)+len(packet))
+	pseudo = append(pseudo, byte(len(odcid)))
+	pseudo = append(pseudo, odcid...)
+	pseudo = append(pseudo, packet...)
+	return aead.Seal(nil, params.retryNonce, nil, pseudo), nil
+}
@@ -0,0 +1,53 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewRetry(t *testing.T) {
+	// RFC 9001, Appendix A.4 and RFC 9369, Appendix A.4
+	odcid, _ := hex.DecodeString("8394c8f03e515708")
+	scid, _ := hex.DecodeString("f067a5502a4262b5")
+
+	tests := []struct {
+		name    string
+		version uint32
+		want    string
+	}{
+		{"v1", Version1, "ff000000010008f067a5502a4262b5746f6b656e04a265ba2eff4d829058fb3f0f2496ba"},
+		{"v2", Version2, "cf6b3343cf0008f067a5502a4262b5746f6b656ec8646ce8bfe33952d955543665dcc7b6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, err := NewRetry(tt.version, nil, scid, odcid, []byte("token"))
+			if err != nil {
+				t.Fatalf("NewRetry() error = %v", err)
+			}
+			want, _ := hex.DecodeString(tt.want)
+			if !bytes.Equal(packet, want) {
+				t.Errorf("NewRetry() = %x, want %x", packet, want)
+			}
+
+			if !VerifyRetry(tt.version, odcid, packet) {
+				t.Error("VerifyRetry() = false for a valid packet")
+			}
+			if VerifyRetry(tt.version, scid, packet) {
+				t.Error("VerifyRetry() = true for the wrong original DCID")
+			}
+
+			header, err := ParsePacket(packet)
+			if err != nil {
+				t.Fatalf("ParsePacket() error = %v", err)
+			}
+			if header.Type != PacketTypeRetry {
+				t.Errorf("ParsePacket() type = %d, want Retry", header.Type)
+			}
+		})
+	}
+
+	if _, err := NewRetry(0xbabababa, nil, scid, odcid, nil); err == nil {
+		t.Error("Expected an unsupported version to be rejected")
+	}
+}
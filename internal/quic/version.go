@@ -0,0 +1,70 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ProbeVersion is a reserved version (RFC 9000, Section 15) that no server
+// supports, so a live server answers it with a Version Negotiation packet.
+const ProbeVersion uint32 = 0x1a2a3a4a
+
+// MinInitialDatagramSize is the smallest datagram a server must respond to.
+const MinInitialDatagramSize = 1200
+
+// VersionNegotiation is a parsed Version Negotiation packet.
+type VersionNegotiation struct {
+	DCID     []byte
+	SCID     []byte
+	Versions []uint32
+}
+
+// NewVersionProbe builds a long header packet using ProbeVersion, padded to
+// the minimum Initial datagram size. A server replies with a Version
+// Negotiation packet whose DCID is the probe's SCID.
+func NewVersionProbe(dcid, scid []byte) []byte {
+	packet := make([]byte, 0, MinInitialDatagramSize)
+	packet = append(packet, 0xc0)
+	packet = binary.BigEndian.AppendUint32(packet, ProbeVersion)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	return packet[:MinInitialDatagramSize]
+}
+
+// ParseVersionNegotiation parses a Version Negotiation packet.
+func ParseVersionNegotiation(data []byte) (*VersionNegotiation, error) {
+	if len(data) < 7 || data[0]&0x80 == 0 {
+		return nil, errors.New("not a long header packet")
+	}
+	if binary.BigEndian.Uint32(data[1:5]) != 0 {
+		return nil, errors.New("not a version negotiation packet")
+	}
+
+	curr := 5
+	dcidLen := int(data[curr])
+	curr++
+	if len(data) < curr+dcidLen+1 {
+		return nil, errors.New("insufficient data for DCID")
+	}
+	vn := &VersionNegotiation{DCID: data[curr : curr+dcidLen]}
+	curr += dcidLen
+
+	scidLen := int(data[curr])
+	curr++
+	if len(data) < curr+scidLen {
+		return nil, errors.New("insufficient data for SCID")
+	}
+	vn.SCID = data[curr : curr+scidLen]
+	curr += scidLen
+
+	versions := data[curr:]
+	if len(versions) == 0 || len(versions)%4 != 0 {
+		return nil, errors.New("invalid supported versions list")
+	}
+	for i := 0; i < len(versions); i += 4 {
+		vn.Versions = append(vn.Versions, binary.BigEndian.Uint32(versions[i:]))
+	}
+	return vn, nil
+}
@@ -0,0 +1,55 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestVersionProbe(t *testing.T) {
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	scid := []byte{9, 10, 11, 12}
+	probe := NewVersionProbe(dcid, scid)
+
+	if len(probe) != MinInitialDatagramSize {
+		t.Errorf("probe length = %d, want %d", len(probe), MinInitialDatagramSize)
+	}
+	header, err := ParsePacket(probe)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("ParsePacket() error = %v, want ErrUnsupportedVersion", err)
+	}
+	if header.Version != ProbeVersion {
+		t.Errorf("version = %#x, want %#x", header.Version, ProbeVersion)
+	}
+
+	// The reply a server would send: DCID and SCID swapped.
+	reply := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+	reply = append(reply, scid...)
+	reply = append(reply, byte(len(dcid)))
+	reply = append(reply, dcid...)
+	reply = binary.BigEndian.AppendUint32(reply, Version1)
+	reply = binary.BigEndian.AppendUint32(reply, Version2)
+
+	vn, err := ParseVersionNegotiation(reply)
+	if err != nil {
+		t.Fatalf("ParseVersionNegotiation() error = %v", err)
+	}
+	if !bytes.Equal(vn.DCID, scid) || !bytes.Equal(vn.SCID, dcid) {
+		t.Errorf("connection IDs = %x/%x, want %x/%x", vn.DCID, vn.SCID, scid, dcid)
+	}
+	if len(vn.Versions) != 2 || vn.Versions[0] != Version1 || vn.Versions[1] != Version2 {
+		t.Errorf("versions = %x", vn.Versions)
+	}
+
+	for _, bad := range [][]byte{
+		reply[:10],
+		reply[:len(reply)-1],
+		append([]byte{0x80, 0, 0, 0, 1}, reply[5:]...),
+		probe,
+	} {
+		if _, err := ParseVersionNegotiation(bad); err == nil {
+			t.Errorf("ParseVersionNegotiation(%x) succeeded, want error", bad)
+		}
+	}
+}
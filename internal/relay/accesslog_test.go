@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/accesslog"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error { return nil }
+
+func TestSessionAccessLog(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, _ := startServing(t, testServeConfig(false), client, backend)
+
+	var buf bufferCloser
+	r.SetAccessLog(accesslog.NewLogger(&buf, 1))
+
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	packet := append([]byte{0x40}, dcid...)
+	packet = append(packet, make([]byte, 91)...)
+
+	targetAddr := backend.LocalAddr().(*net.UDPAddr)
+	backendConn, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &session{
+		target:      targetAddr.String(),
+		targetAddr:  targetAddr,
+		lastSeen:    time.Now(),
+		srcAddr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		backendConn: backendConn,
+		sni:         "game.example.com",
+		cidLength:   len(dcid),
+	}
+	// The first packet arrives with the Initial, the second after the client
+	// moved to its real address.
+	r.startSession(sess, string(dcid), "simple", [][]byte{packet})
+	if _, err := client.WriteToUDP(packet, r.localAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	readBuf := make([]byte, 2048)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var from net.Addr
+	for range 2 {
+		if _, from, err = backend.ReadFrom(readBuf); err != nil {
+			t.Fatalf("Backend read failed: %v", err)
+		}
+	}
+	if _, err := backend.WriteTo(packet[:50], from); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := client.ReadFrom(readBuf); err != nil {
+		t.Fatalf("Client read failed: %v", err)
+	}
+
+	r.closeSession(sess, "test done")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected start and end records, got %q", buf.String())
+	}
+	var start, end accesslog.Record
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil {
+		t.Fatal(err)
+	}
+
+	if start.Event != accesslog.EventSessionStart || start.DCID != "0102030405060708" || start.SNI != "game.example.com" || start.Strategy != "simple" {
+		t.Errorf("Unexpected start record %+v", start)
+	}
+	want := accesslog.Record{
+		Event:      accesslog.EventSessionEnd,
+		Client:     client.LocalAddr().String(),
+		SNI:        "game.example.com",
+		DCID:       "0102030405060708",
+		Strategy:   "simple",
+		Target:     targetAddr.String(),
+		BytesIn:    200,
+		BytesOut:   50,
+		PacketsIn:  2,
+		PacketsOut: 1,
+		Migrations: 1,
+		Reason:     "test done",
+	}
+	end.Time, end.Duration = time.Time{}, 0
+	if end != want {
+		t.Errorf("End record = %+v, want %+v", end, want)
+	}
+}
@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/acl"
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	policy := acl.NewPolicy()
+	if err := policy.SetGlobal(acl.Rules{Deny: []string{"203.0.113.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.SetRoute("staging.example.com", acl.Rules{Allow: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{cfg: &config.Config{}}
+	r.SetAccessPolicy(policy)
+
+	denied := func(scope string) float64 {
+		return testutil.ToFloat64(metrics.RelayAccessDenied.WithLabelValues(scope))
+	}
+	decryptFailures := func() float64 {
+		return testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropDecryptFailed))
+	}
+
+	// Globally denied clients are dropped before decryption.
+	globalBefore, decryptBefore := denied(metrics.ACLGlobal), decryptFailures()
+	initial, header := testInitial(t, 1)
+	r.handlePacket(&net.UDPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 4433}, netip.Addr{}, initial, header)
+	if got := denied(metrics.ACLGlobal) - globalBefore; got != 1 {
+		t.Errorf("Counted %v global denials, want 1", got)
+	}
+	if decryptFailures() != decryptBefore {
+		t.Error("Expected the denied Initial to be dropped before decryption")
+	}
+
+	// Other clients reach decryption.
+	initial, header = testInitial(t, 2)
+	r.handlePacket(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4433}, netip.Addr{}, initial, header)
+	if decryptFailures() == decryptBefore {
+		t.Error("Expected an allowed client's Initial to be decrypted")
+	}
+
+	// Route rules apply once the SNI is known.
+	routeBefore := denied(metrics.ACLRoute)
+	initial, header = testInitial(t, 3)
+	r.createSession(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4433}, netip.Addr{}, header, "staging.example.com", [][]byte{initial})
+	if got := denied(metrics.ACLRoute) - routeBefore; got != 1 {
+		t.Errorf("Counted %v route denials, want 1", got)
+	}
+	if _, ok := r.sessions.Load(string(header.DCID)); ok {
+		t.Error("Expected no session for a denied client")
+	}
+}
+
+func TestAccessPolicyResumeAndAdopt(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer backend.Close()
+	target := backend.LocalAddr().String()
+
+	policy := acl.NewPolicy()
+	if err := policy.SetGlobal(acl.Rules{Deny: []string{"203.0.113.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.SetRoute("staging.example.com", acl.Rules{Allow: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "00112233445566778899aabbccddeeff"
+	cfg := &config.Config{}
+	cfg.QUICLB.Configs = []config.QUICLBConfig{{
+		ID:             1,
+		ServerIDLength: 2,
+		NonceLength:    5,
+		Key:            key,
+		Servers:        map[string]string{"0a01": target},
+	}}
+	lb, err := newLoadBalancer(cfg.QUICLB.Configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := &memoryDirectory{entries: make(map[string]SessionEntry)}
+	sharedCID := "\x01\x02\x03\x04\x05\x06\x07\x08"
+	directory.entries[sharedCID] = SessionEntry{Session: []byte(sharedCID), Target: target, SNI: "staging.example.com", CIDLength: 8}
+
+	r := &Relay{cfg: cfg, lb: lb, routeOptions: strategy.NewPatternTable[routeOptions]()}
+	r.SetSessionDirectory(directory)
+	r.SetAccessPolicy(policy)
+
+	denied := func(scope string) float64 {
+		return testutil.ToFloat64(metrics.RelayAccessDenied.WithLabelValues(scope))
+	}
+	send := func(src *net.UDPAddr, cid []byte) {
+		packet := shortHeaderPacket(cid)
+		header, err := quic.ParsePacket(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.handlePacket(src, netip.Addr{}, packet, header)
+	}
+
+	rawKey, _ := hex.DecodeString(key)
+	lbCfg, _ := quic.NewLBConfig(1, 2, 5, rawKey)
+	routableCID, _ := lbCfg.EncodeConnID([]byte{0x0a, 0x01}, []byte{1, 2, 3, 4, 5})
+
+	// A globally denied client neither resumes nor adopts a session.
+	globalBefore := denied(metrics.ACLGlobal)
+	blocked := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 4433}
+	send(blocked, routableCID)
+	send(blocked, []byte(sharedCID))
+	if got := denied(metrics.ACLGlobal) - globalBefore; got != 2 {
+		t.Errorf("Counted %v global denials, want 2", got)
+	}
+	if n := directory.lookupCount(); n != 0 {
+		t.Errorf("Looked the directory up %d times for a denied client", n)
+	}
+
+	// A client denied by the route of a shared session cannot adopt it.
+	routeBefore := denied(metrics.ACLRoute)
+	send(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4433}, []byte(sharedCID))
+	if got := denied(metrics.ACLRoute) - routeBefore; got != 1 {
+		t.Errorf("Counted %v route denials, want 1", got)
+	}
+
+	found := false
+	r.sessions.Range(func(_, _ any) bool {
+		found = true
+		return false
+	})
+	if found {
+		t.Error("Expected no session for a denied client")
+	}
+	backend.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _, err := backend.ReadFromUDP(make([]byte, 1500)); err == nil {
+		t.Errorf("Backend received a %d byte datagram from a denied client", n)
+	}
+}
@@ -0,0 +1,350 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"runtime"
+
+	"github.com/ewancrowle/porter/internal/metrics"
+	"golang.org/x/net/ipv4"
+)
+
+// defaultDatagramSize is the largest datagram relayed when
+// udp.max_datagram_size is not set, and maxDatagramSize the largest possible.
+const (
+	defaultDatagramSize = 2048
+	maxDatagramSize     = 65535
+)
+
+// groBufferSize is the size of each listener read buffer when UDP GRO is
+// enabled, so the kernel can coalesce many datagrams into one read.
+const groBufferSize = 65535
+
+// The kernel accepts at most 64 segments in one GSO send, adding up to no
+// more than a maximum size UDP datagram.
+const (
+	maxGSOSegments = 64
+	maxGSOBytes    = 65507
+)
+
+// maxDatagramSize returns the size of the largest datagram relayed, which
+// is also the size of each pooled packet buffer.
+func (r *Relay) maxDatagramSize() int {
+	if r.cfg.UDP.MaxDatagramSize > 0 {
+		return r.cfg.UDP.MaxDatagramSize
+	}
+	return defaultDatagramSize
+}
+
+// getBuffer returns a pooled buffer holding one datagram.
+func (r *Relay) getBuffer() *[]byte {
+	if buf, ok := r.buffers.Get().(*[]byte); ok {
+		return buf
+	}
+	buf := make([]byte, r.maxDatagramSize())
+	return &buf
+}
+
+func (r *Relay) putBuffer(buf *[]byte) {
+	r.buffers.Put(buf)
+}
+
+// batchConn reads and writes batches of datagrams on the listener.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// inPacket is a client datagram in a pooled buffer, queued for a worker.
+type inPacket struct {
+	buf  *[]byte
+	n    int
+	addr *net.UDPAddr
+	dst  netip.Addr // Zero if the kernel did not report it
+}
+
+// outPacket is a backend datagram in a pooled buffer, queued for the client
+// writer.
+type outPacket struct {
+	buf  *[]byte
+	n    int
+	addr *net.UDPAddr
+	sess *session
+}
+
+// startWorkers starts the fixed pool of goroutines that process client
+// datagrams. Each client address is served by a single worker, so its
+// packets are processed in order.
+func (r *Relay) startWorkers() {
+	workers := r.cfg.UDP.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	queueSize := r.cfg.UDP.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+
+	r.workers = make([]chan inPacket, workers)
+	for i := range r.workers {
+		r.workers[i] = make(chan inPacket, queueSize)
+		go r.work(r.workers[i])
+	}
+}
+
+// stopWorkers stops the workers once their queues are drained. It must only
+// be called after the read loop has returned.
+func (r *Relay) stopWorkers() {
+	for _, queue := range r.workers {
+		close(queue)
+	}
+}
+
+func (r *Relay) work(queue <-chan inPacket) {
+	for p := range queue {
+		r.processUDPDatagram(p.addr, p.dst, (*p.buf)[:p.n])
+		r.putBuffer(p.buf)
+	}
+}
+
+// addrHash is FNV-1a over the address, without allocating.
+func addrHash(addr *net.UDPAddr) uint32 {
+	h := uint32(2166136261)
+	for _, b := range addr.IP {
+		h = (h ^ uint32(b)) * 16777619
+	}
+	return (h ^ uint32(addr.Port)) * 16777619
+}
+
+// dispatch copies a client datagram into a pooled buffer and queues it for
+// the worker of its address. The datagram is dropped if that worker is
+// backed up, rather than stalling reads for every other client.
+func (r *Relay) dispatch(addr *net.UDPAddr, dst netip.Addr, data []byte) {
+	buf := r.getBuffer()
+	if len(data) > len(*buf) {
+		r.putBuffer(buf)
+		metrics.RelayTruncated.WithLabelValues(metrics.DirectionClientToBackend).Inc()
+		return
+	}
+	n := copy(*buf, data)
+
+	select {
+	case r.workers[addrHash(addr)%uint32(len(r.workers))] <- inPacket{buf: buf, n: n, addr: addr, dst: dst}:
+	default:
+		r.putBuffer(buf)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropQueueFull).Inc()
+	}
+}
+
+// readLoop reads batches of client datagrams from a listener and splits
+// GRO-coalesced reads back into datagrams for the workers.
+func (r *Relay) readLoop(ctx context.Context, ln *listener) {
+	bufSize, oobSize := r.maxDatagramSize(), 0
+	if ln.gro {
+		bufSize, oobSize = groBufferSize, groOOBSize
+	}
+	if ln.pktInfo {
+		oobSize += pktInfoOOBSize
+	}
+
+	msgs := make([]ipv4.Message, max(r.cfg.UDP.BatchSize, 1))
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, bufSize)}
+		msgs[i].OOB = make([]byte, oobSize)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			n, err := ln.batch.ReadBatch(msgs, 0)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Error reading from UDP: %v", err)
+				continue
+			}
+
+			for _, msg := range msgs[:n] {
+				addr, ok := msg.Addr.(*net.UDPAddr)
+				if !ok {
+					continue
+				}
+				if truncated(msg.Flags) {
+					metrics.RelayTruncated.WithLabelValues(metrics.DirectionClientToBackend).Inc()
+					continue
+				}
+				data := msg.Buffers[0][:msg.N]
+
+				segment := len(data)
+				coalesced, dst := parseOOB(msg.OOB[:msg.NN])
+				if coalesced > 0 {
+					segment = coalesced
+				}
+				for len(data) > 0 {
+					size := min(segment, len(data))
+					r.dispatch(addr, dst, data[:size])
+					data = data[size:]
+				}
+			}
+		}
+	}
+}
+
+// sendToClient queues a backend datagram for the client writer of a
+// listener, which takes ownership of the buffer. Every listener is bound to
+// the same address, so any of them can reply; picking one by client address
+// spreads the writes while keeping each client's datagrams in order.
+func (r *Relay) sendToClient(sess *session, addr *net.UDPAddr, buf *[]byte, n int) {
+	ln := r.listeners[addrHash(addr)%uint32(len(r.listeners))]
+	ln.writeQueue <- outPacket{buf: buf, n: n, addr: addr, sess: sess}
+}
+
+// writeLoop sends backend datagrams to clients through the listener.
+// Datagrams that queue up while a batch is being written go out together in
+// the next one.
+func (r *Relay) writeLoop(ln *listener) {
+	batch := max(r.cfg.UDP.BatchSize, 1)
+	pending := make([]outPacket, 0, batch)
+	w := newClientWriter(r, ln, batch)
+
+	for p := range ln.writeQueue {
+		pending = append(pending[:0], p)
+	drain:
+		for len(pending) < batch {
+			select {
+			case p, ok := <-ln.writeQueue:
+				if !ok {
+					break drain
+				}
+				pending = append(pending, p)
+			default:
+				break drain
+			}
+		}
+
+		w.write(pending)
+		for i := range pending {
+			r.putBuffer(pending[i].buf)
+			pending[i] = outPacket{}
+		}
+	}
+}
+
+// clientWriter turns queued datagrams into listener writes, reusing its
+// buffers across batches.
+type clientWriter struct {
+	r      *Relay
+	ln     *listener
+	msgs   []ipv4.Message
+	groups [][]outPacket // Datagrams of each message
+	bufs   [][]byte
+	oob    []byte
+}
+
+func newClientWriter(r *Relay, ln *listener, batch int) *clientWriter {
+	return &clientWriter{
+		r:      r,
+		ln:     ln,
+		msgs:   make([]ipv4.Message, 0, batch),
+		groups: make([][]outPacket, 0, batch),
+		bufs:   make([][]byte, 0, batch),
+		oob:    make([]byte, batch*gsoOOBSize),
+	}
+}
+
+// write sends the datagrams, each session's run of equally sized datagrams
+// as a single GSO message when the kernel supports it.
+func (w *clientWriter) write(pending []outPacket) {
+	gso := w.ln.gso.Load()
+	w.build(pending, gso)
+
+	for off := 0; off < len(w.msgs); {
+		n, err := w.ln.batch.WriteBatch(w.msgs[off:], 0)
+		n = max(n, 0)
+		w.sent(w.groups[off : off+n])
+		off += n
+		if err == nil {
+			continue
+		}
+
+		if gso && isGSOError(err) {
+			// Typically a device without checksum offload. Resend unsegmented.
+			log.Printf("Disabling UDP GSO after send error: %v", err)
+			w.ln.gso.Store(false)
+			gso = false
+
+			var rest []outPacket
+			for _, group := range w.groups[off:] {
+				rest = append(rest, group...)
+			}
+			w.build(rest, false)
+			off = 0
+			continue
+		}
+
+		failed := w.groups[off][0]
+		log.Printf("Error writing back to client %v: %v", failed.addr, err)
+		w.r.closeSession(failed.sess, fmt.Sprintf("client write failed: %v", err))
+		off++
+	}
+}
+
+// build groups the datagrams into messages.
+func (w *clientWriter) build(pending []outPacket, gso bool) {
+	w.msgs, w.groups, w.bufs = w.msgs[:0], w.groups[:0], w.bufs[:0]
+
+	for start := 0; start < len(pending); {
+		first := pending[start]
+		end, size := start+1, first.n
+		if gso {
+			// Every segment but the last must be exactly as long as the first.
+			for end < len(pending) && end-start < maxGSOSegments {
+				p := pending[end]
+				if p.sess != first.sess || p.addr != first.addr || p.n > first.n ||
+					pending[end-1].n != first.n || size+p.n > maxGSOBytes {
+					break
+				}
+				end++
+				size += p.n
+			}
+		}
+
+		bufStart := len(w.bufs)
+		for _, p := range pending[start:end] {
+			w.bufs = append(w.bufs, (*p.buf)[:p.n])
+		}
+		msg := ipv4.Message{
+			Buffers: w.bufs[bufStart:len(w.bufs):len(w.bufs)],
+			Addr:    first.addr,
+		}
+		if end-start > 1 {
+			i := len(w.msgs)
+			msg.OOB = putGSOSize(w.oob[i*gsoOOBSize:(i+1)*gsoOOBSize], first.n)
+		}
+		w.msgs = append(w.msgs, msg)
+		w.groups = append(w.groups, pending[start:end])
+		start = end
+	}
+}
+
+// sent records the messages of the groups as written.
+func (w *clientWriter) sent(groups [][]outPacket) {
+	for _, group := range groups {
+		bytes := 0
+		for _, p := range group {
+			bytes += p.n
+		}
+		metrics.RelayPackets.WithLabelValues(metrics.DirectionBackendToClient).Add(float64(len(group)))
+		metrics.RelayBytes.WithLabelValues(metrics.DirectionBackendToClient).Add(float64(bytes))
+		if sess := group[0].sess; sess.logged {
+			sess.stats.packetsOut.Add(int64(len(group)))
+			sess.stats.bytesOut.Add(int64(bytes))
+		}
+	}
+}
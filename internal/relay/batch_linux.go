@@ -0,0 +1,112 @@
+package relay
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	groOOBSize = unix.CmsgSpace(4)
+	gsoOOBSize = unix.CmsgSpace(2)
+	// Dual-stack sockets may receive both kinds of packet info.
+	pktInfoOOBSize = unix.CmsgSpace(unix.SizeofInet4Pktinfo) + unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+)
+
+// newBatchConn uses recvmmsg and sendmmsg on the listener.
+func newBatchConn(conn *net.UDPConn) batchConn {
+	return ipv4.NewPacketConn(conn)
+}
+
+// enableGRO asks the kernel to coalesce datagrams of the same flow into one
+// read. It reports whether the kernel supports it.
+func enableGRO(conn *net.UDPConn) bool {
+	return setsockopt(conn, func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_UDP, unix.UDP_GRO, 1)
+	})
+}
+
+// enablePktInfo asks the kernel for the destination address of every
+// datagram, which tells which local address a wildcard listener received it
+// on. It reports whether the kernel provides it.
+func enablePktInfo(conn *net.UDPConn) bool {
+	v4 := setsockopt(conn, func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1)
+	})
+	v6 := setsockopt(conn, func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1)
+	})
+	return v4 || v6
+}
+
+// gsoSupported reports whether the kernel can segment one large send into
+// several datagrams.
+func gsoSupported(conn *net.UDPConn) bool {
+	return setsockopt(conn, func(fd int) error {
+		_, err := unix.GetsockoptInt(fd, unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+		return err
+	})
+}
+
+func setsockopt(conn *net.UDPConn, fn func(fd int) error) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) { sockErr = fn(int(fd)) }); err != nil {
+		return false
+	}
+	return sockErr == nil
+}
+
+// parseOOB returns the size of the datagrams coalesced into a read, or 0 if
+// the read holds a single datagram, and the address the datagrams were sent
+// to, if known.
+func parseOOB(oob []byte) (segment int, dst netip.Addr) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, netip.Addr{}
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.IPPROTO_UDP && msg.Header.Type == unix.UDP_GRO && len(msg.Data) >= 4:
+			segment = int(binary.NativeEndian.Uint32(msg.Data))
+		case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_PKTINFO && len(msg.Data) >= unix.SizeofInet4Pktinfo:
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst = netip.AddrFrom4(info.Addr)
+		case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_PKTINFO && len(msg.Data) >= unix.SizeofInet6Pktinfo && !dst.IsValid():
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst = netip.AddrFrom16(info.Addr).Unmap()
+		}
+	}
+	return segment, dst
+}
+
+// putGSOSize writes the control message asking the kernel to split a send
+// into datagrams of the given size.
+func putGSOSize(oob []byte, size int) []byte {
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], uint16(size))
+	return oob[:unix.CmsgSpace(2)]
+}
+
+// truncated reports whether the kernel cut a received datagram short to fit
+// the buffer.
+func truncated(flags int) bool {
+	return flags&unix.MSG_TRUNC != 0
+}
+
+// isGSOError reports whether a send failed because the device cannot
+// segment it.
+func isGSOError(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
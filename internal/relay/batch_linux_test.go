@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/pkg/proxyproto"
+	"golang.org/x/net/ipv4"
+)
+
+func TestServeSplitsGROReads(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, _ := startServing(t, testServeConfig(true), client, backend)
+	if !gsoSupported(client) {
+		t.Skip("UDP GSO not supported")
+	}
+	if !r.listeners[0].gro {
+		t.Skip("UDP GRO not supported")
+	}
+
+	// On loopback, a GSO send reaches a GRO socket as a single read.
+	const count = 10
+	var buffers [][]byte
+	for i := range count {
+		buffers = append(buffers, sizedPacket(byte(i), 1200))
+	}
+	msg := ipv4.Message{
+		Buffers: buffers,
+		Addr:    r.localAddr(),
+		OOB:     putGSOSize(make([]byte, gsoOOBSize), 1200),
+	}
+	if _, err := ipv4.NewPacketConn(client).WriteBatch([]ipv4.Message{msg}, 0); err != nil {
+		t.Fatalf("GSO send failed: %v", err)
+	}
+
+	buf := make([]byte, 65535)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := range count {
+		n, _, err := backend.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("Backend got %d of %d packets: %v", i, count, err)
+		}
+		if n != 1200 || buf[len(testDCID)+1] != byte(i) {
+			t.Errorf("Packet %d: %d bytes, sequence %d", i, n, buf[len(testDCID)+1])
+		}
+	}
+}
+
+func TestServeProxyDestination(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, sess := startServingOn(t, net.IPv4zero, testServeConfig(false), client, backend)
+	if !r.listeners[0].pktInfo {
+		t.Skip("IP_PKTINFO not supported")
+	}
+	sess.mu.Lock()
+	sess.proxyMode = ProxyProtocolEvery
+	sess.mu.Unlock()
+
+	relayAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: r.localAddr().Port}
+	if _, err := client.WriteToUDP(sizedPacket(0, 1200), relayAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := backend.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := proxyproto.Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("Expected a PROXY header: %v", err)
+	}
+	if header.Destination.String() != relayAddr.String() {
+		t.Errorf("Header destination = %s, want %s rather than the wildcard listen address", header.Destination, relayAddr)
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !linux
+
+package relay
+
+import (
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	groOOBSize     = 0
+	gsoOOBSize     = 0
+	pktInfoOOBSize = 0
+)
+
+// udpBatchConn moves one datagram per call, like x/net does outside Linux,
+// but through the net package so dual-stack listeners accept IPv4 clients.
+type udpBatchConn struct {
+	conn *net.UDPConn
+}
+
+func newBatchConn(conn *net.UDPConn) batchConn {
+	return udpBatchConn{conn: conn}
+}
+
+func (c udpBatchConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, addr, err := c.conn.ReadFromUDP(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N, ms[0].NN, ms[0].Addr = n, 0, addr
+	return 1, nil
+}
+
+func (c udpBatchConn) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, err := c.conn.WriteTo(ms[0].Buffers[0], ms[0].Addr)
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N = n
+	return 1, nil
+}
+
+func enableGRO(conn *net.UDPConn) bool       { return false }
+func gsoSupported(conn *net.UDPConn) bool    { return false }
+func enablePktInfo(conn *net.UDPConn) bool   { return false }
+func putGSOSize(oob []byte, size int) []byte { return nil }
+func isGSOError(err error) bool              { return false }
+
+// parseOOB never finds anything: control messages are only read on Linux.
+func parseOOB(oob []byte) (int, netip.Addr) { return 0, netip.Addr{} }
+
+// truncated always reports false: datagrams cut short by a small buffer are
+// only detected on Linux.
+func truncated(flags int) bool { return false }
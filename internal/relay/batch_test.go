@@ -0,0 +1,320 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testDCID = []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+
+func listenLoopback(tb testing.TB) *net.UDPConn {
+	tb.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatalf("Failed to listen: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testServeConfig(offload bool) *config.Config {
+	cfg := &config.Config{}
+	cfg.UDP.BatchSize = 32
+	cfg.UDP.QueueSize = 1024
+	cfg.UDP.GSO = offload
+	cfg.UDP.GRO = offload
+	return cfg
+}
+
+// startServing runs a relay on loopback with a session for testDCID from
+// client to backend.
+func startServing(tb testing.TB, cfg *config.Config, client, backend *net.UDPConn) (*Relay, *session) {
+	tb.Helper()
+	return startServingOn(tb, net.IPv4(127, 0, 0, 1), cfg, client, backend)
+}
+
+// startServingOn is startServing with the relay listening on ip.
+func startServingOn(tb testing.TB, ip net.IP, cfg *config.Config, client, backend *net.UDPConn) (*Relay, *session) {
+	tb.Helper()
+
+	r := &Relay{
+		cfg:          cfg,
+		listenAddr:   &net.UDPAddr{IP: ip},
+		routeOptions: strategy.NewPatternTable[routeOptions](),
+	}
+	if err := r.listen(); err != nil {
+		tb.Fatalf("listen() error = %v", err)
+	}
+	r.setupIO()
+
+	targetAddr := backend.LocalAddr().(*net.UDPAddr)
+	backendConn, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		tb.Fatalf("Failed to dial backend: %v", err)
+	}
+	sess := &session{
+		target:      targetAddr.String(),
+		targetAddr:  targetAddr,
+		lastSeen:    time.Now(),
+		srcAddr:     client.LocalAddr().(*net.UDPAddr),
+		backendConn: backendConn,
+		cidLength:   len(testDCID),
+	}
+	r.startSession(sess, string(testDCID), "test", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.serve(ctx)
+		close(done)
+	}()
+	tb.Cleanup(func() {
+		cancel()
+		r.closeListeners()
+		<-done
+		r.closeSession(sess, "test done")
+	})
+	return r, sess
+}
+
+func sizedPacket(seq byte, size int) []byte {
+	packet := make([]byte, size)
+	packet[0] = 0x40
+	copy(packet[1:], testDCID)
+	packet[len(testDCID)+1] = seq
+	return packet
+}
+
+func TestServeRelaysBothWays(t *testing.T) {
+	for _, offload := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "offload"}[offload], func(t *testing.T) {
+			client, backend := listenLoopback(t), listenLoopback(t)
+			r, sess := startServing(t, testServeConfig(offload), client, backend)
+			relayAddr := r.localAddr()
+
+			const count = 50
+			for i := range count {
+				if _, err := client.WriteToUDP(sizedPacket(byte(i), 1200), relayAddr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			buf := make([]byte, 2048)
+			backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var sessAddr net.Addr
+			for i := range count {
+				n, from, err := backend.ReadFromUDP(buf)
+				if err != nil {
+					t.Fatalf("Backend got %d of %d packets: %v", i, count, err)
+				}
+				if want := sizedPacket(byte(i), 1200); !bytes.Equal(buf[:n], want) {
+					t.Fatalf("Backend packet %d = %x..., want %x...", i, buf[:12], want[:12])
+				}
+				sessAddr = from
+			}
+			if sessAddr.String() != sess.backendConn.LocalAddr().String() {
+				t.Errorf("Backend saw %v, want session socket %v", sessAddr, sess.backendConn.LocalAddr())
+			}
+
+			// Equal sizes with a shorter last datagram, as GSO sends them.
+			for i := range count {
+				size := 1200
+				if i == count-1 {
+					size = 300
+				}
+				if _, err := backend.WriteTo(sizedPacket(byte(i), size), sessAddr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for i := range count {
+				n, from, err := client.ReadFromUDP(buf)
+				if err != nil {
+					t.Fatalf("Client got %d of %d packets: %v", i, count, err)
+				}
+				if from.Port != relayAddr.Port {
+					t.Errorf("Client packet from %v, want the relay %v", from, relayAddr)
+				}
+				if n != 1200 && n != 300 {
+					t.Errorf("Client packet %d has %d bytes", i, n)
+				}
+			}
+		})
+	}
+}
+
+func TestServeDatagramSizes(t *testing.T) {
+	for _, offload := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "offload"}[offload], func(t *testing.T) {
+			client, backend := listenLoopback(t), listenLoopback(t)
+			cfg := testServeConfig(offload)
+			cfg.UDP.MaxDatagramSize = 9000
+			r, _ := startServing(t, cfg, client, backend)
+			relayAddr := r.localAddr()
+
+			truncatedBefore := testutil.ToFloat64(metrics.RelayTruncated.WithLabelValues(metrics.DirectionClientToBackend))
+			for _, size := range []int{12000, 8000} {
+				if _, err := client.WriteToUDP(sizedPacket(0, size), relayAddr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The oversized datagram is dropped, not truncated and forwarded.
+			buf := make([]byte, 65535)
+			backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, from, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 8000 {
+				t.Fatalf("Backend got %d bytes, want the 8000 byte datagram", n)
+			}
+			truncated := testutil.ToFloat64(metrics.RelayTruncated.WithLabelValues(metrics.DirectionClientToBackend))
+			if truncated != truncatedBefore+1 {
+				t.Errorf("Truncated count rose by %v, want 1", truncated-truncatedBefore)
+			}
+
+			for _, size := range []int{12000, 8000} {
+				if _, err := backend.WriteToUDP(sizedPacket(0, size), from); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if n, _, err = client.ReadFromUDP(buf); err != nil {
+				t.Fatal(err)
+			}
+			if n != 8000 {
+				t.Errorf("Client got %d bytes, want the 8000 byte datagram", n)
+			}
+		})
+	}
+}
+
+func TestClientWriterBuild(t *testing.T) {
+	a, b := &session{}, &session{}
+	addrA, addrB := &net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2}
+
+	var pending []outPacket
+	add := func(sess *session, addr *net.UDPAddr, n int) {
+		buf := make([]byte, n)
+		pending = append(pending, outPacket{buf: &buf, n: n, addr: addr, sess: sess})
+	}
+	add(a, addrA, 1200)
+	add(a, addrA, 1200)
+	add(a, addrA, 1200)
+	add(a, addrA, 800)
+	add(a, addrA, 1200)
+	add(b, addrB, 1200)
+	add(b, addrB, 1300)
+
+	tests := []struct {
+		gso  bool
+		want []int
+	}{
+		{gso: false, want: []int{1, 1, 1, 1, 1, 1, 1}},
+		// A shorter datagram ends a run, and so does a longer one.
+		{gso: true, want: []int{4, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		w := newClientWriter(&Relay{}, &listener{}, len(pending))
+		w.build(pending, tt.gso)
+
+		var got []int
+		for i, group := range w.groups {
+			got = append(got, len(group))
+			if n := len(w.msgs[i].Buffers); n != len(group) {
+				t.Errorf("gso=%t: message %d has %d buffers, want %d", tt.gso, i, n, len(group))
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("gso=%t: groups = %v, want %v", tt.gso, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("gso=%t: groups = %v, want %v", tt.gso, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkRelay measures packets per second through an established session.
+// The sender keeps a window of packets in flight; packets lost to full socket
+// buffers are reported as loss rather than stalling the benchmark.
+func BenchmarkRelay(b *testing.B) {
+	for _, dir := range []string{"client_to_backend", "backend_to_client"} {
+		b.Run(dir, func(b *testing.B) {
+			client, backend := listenLoopback(b), listenLoopback(b)
+			for _, conn := range []*net.UDPConn{client, backend} {
+				conn.SetReadBuffer(8 << 20)
+			}
+			r, sess := startServing(b, testServeConfig(true), client, backend)
+
+			src, dst, to := client, backend, net.Addr(r.localAddr())
+			if dir == "backend_to_client" {
+				src, dst, to = backend, client, sess.backendConn.LocalAddr()
+			}
+			benchmarkPump(b, src, dst, to)
+		})
+	}
+}
+
+func benchmarkPump(b *testing.B, src, dst *net.UDPConn, to net.Addr) {
+	var received atomic.Int64
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			if _, _, err := dst.ReadFrom(buf); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	}()
+
+	const window = 256
+	packet := sizedPacket(0, 1200)
+	skipped := int64(0)
+
+	// settle waits until at most limit packets are in flight. Packets that
+	// stop arriving are written off so a lost packet cannot stall the sender.
+	settle := func(sent int64, limit int64, stall time.Duration) {
+		last, since := received.Load(), time.Now()
+		for sent-received.Load()-skipped > limit {
+			if got := received.Load(); got != last {
+				last, since = got, time.Now()
+			} else if time.Since(since) > stall {
+				skipped = sent - got
+			}
+			time.Sleep(10 * time.Microsecond)
+		}
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for sent := int64(0); sent < int64(b.N); sent++ {
+		if sent%64 == 0 {
+			settle(sent, window, 20*time.Millisecond)
+		}
+		if _, err := src.WriteTo(packet, to); err != nil {
+			b.Fatal(err)
+		}
+	}
+	skipped = 0
+	settle(int64(b.N), 0, 100*time.Millisecond)
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	lost := int64(b.N) - received.Load()
+	b.ReportMetric(float64(received.Load())/elapsed.Seconds(), "pkts/s")
+	b.ReportMetric(100*float64(lost)/float64(b.N), "%loss")
+}
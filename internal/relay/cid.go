@@ -0,0 +1,121 @@
+package relay
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+var (
+	errUnknownCID   = errors.New("no session for short header DCID")
+	errAmbiguousCID = errors.New("short header DCID matches several sessions")
+)
+
+// cidRegistry tracks the connection ID lengths used by backends. Short header
+// packets do not encode their DCID length, so the relay tries each length in
+// use and looks up the exact-length prefix.
+type cidRegistry struct {
+	mu      sync.RWMutex
+	counts  map[int]int // CID length -> sessions using it
+	lengths []int       // Sorted keys of counts, replaced on every change
+}
+
+func (c *cidRegistry) add(length int) {
+	if length <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[int]int)
+	}
+	c.counts[length]++
+	if c.counts[length] == 1 {
+		c.update()
+	}
+}
+
+func (c *cidRegistry) remove(length int) {
+	if length <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[length]--
+	if c.counts[length] <= 0 {
+		delete(c.counts, length)
+		c.update()
+	}
+}
+
+// update rebuilds the sorted length list. The caller must hold c.mu.
+func (c *cidRegistry) update() {
+	lengths := make([]int, 0, len(c.counts))
+	for length := range c.counts {
+		lengths = append(lengths, length)
+	}
+	slices.Sort(lengths)
+	c.lengths = lengths
+}
+
+// snapshot returns the lengths in use, shortest first. The slice must not be
+// modified.
+func (c *cidRegistry) snapshot() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lengths
+}
+
+// lookupShortHeader finds the session a short header packet belongs to by
+// trying every connection ID length in use. It fails if no length matches a
+// session, or if different lengths match different sessions.
+func (r *Relay) lookupShortHeader(data []byte) (*session, []byte, error) {
+	var match *session
+	var matchID []byte
+
+	for _, length := range r.cids.snapshot() {
+		dcid, err := quic.ShortHeaderDCID(data, length)
+		if err != nil {
+			break // Longer lengths do not fit either
+		}
+		val, ok := r.sessions.Load(string(dcid))
+		if !ok {
+			continue
+		}
+		if match != nil && val.(*session) != match {
+			return nil, nil, errAmbiguousCID
+		}
+		match, matchID = val.(*session), dcid
+	}
+
+	if match == nil {
+		return nil, nil, errUnknownCID
+	}
+	return match, matchID, nil
+}
+
+// learnCIDLength records the length of the connection IDs the session's
+// backend issues, unless it is already known from the route configuration.
+func (r *Relay) learnCIDLength(sess *session, length int) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.closed || length == sess.cidLength {
+		return
+	}
+	if sess.cidLength != 0 {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: backend %s issued a %d-byte SCID, expected %d bytes", sess.targetAddr, length, sess.cidLength)
+		}
+		return
+	}
+	sess.cidLength = length
+	r.cids.add(length)
+}
@@ -0,0 +1,83 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+)
+
+func TestCIDRegistry(t *testing.T) {
+	var c cidRegistry
+	c.add(16)
+	c.add(4)
+	c.add(16)
+	c.add(0)
+
+	if got := c.snapshot(); !slices.Equal(got, []int{4, 16}) {
+		t.Fatalf("snapshot() = %v, want [4 16]", got)
+	}
+
+	c.remove(16)
+	c.remove(4)
+	if got := c.snapshot(); !slices.Equal(got, []int{16}) {
+		t.Errorf("snapshot() = %v, want [16]", got)
+	}
+	c.remove(16)
+	if got := c.snapshot(); len(got) != 0 {
+		t.Errorf("snapshot() = %v, want empty", got)
+	}
+}
+
+func shortHeaderPacket(dcid []byte) []byte {
+	packet := append([]byte{0x40}, dcid...)
+	return append(packet, bytes.Repeat([]byte{0xaa}, 32)...)
+}
+
+func TestLookupShortHeader(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}}
+
+	short := newTestSession(t, time.Minute)
+	r.registerID("\x01\x02\x03\x04", short)
+	r.learnCIDLength(short, 4)
+
+	long := newTestSession(t, time.Minute)
+	longID := bytes.Repeat([]byte{0x05}, 20)
+	r.registerID(string(longID), long)
+	r.learnCIDLength(long, 20)
+
+	sess, dcid, err := r.lookupShortHeader(shortHeaderPacket([]byte{1, 2, 3, 4}))
+	if err != nil || sess != short || !bytes.Equal(dcid, []byte{1, 2, 3, 4}) {
+		t.Errorf("4-byte lookup = %p, %x, %v, want %p", sess, dcid, err, short)
+	}
+
+	sess, dcid, err = r.lookupShortHeader(shortHeaderPacket(longID))
+	if err != nil || sess != long || !bytes.Equal(dcid, longID) {
+		t.Errorf("20-byte lookup = %p, %x, %v, want %p", sess, dcid, err, long)
+	}
+
+	if _, _, err := r.lookupShortHeader(shortHeaderPacket([]byte{9, 9, 9, 9})); !errors.Is(err, errUnknownCID) {
+		t.Errorf("unknown lookup error = %v, want errUnknownCID", err)
+	}
+
+	// A 20-byte CID starting with another session's 4-byte CID is ambiguous.
+	other := newTestSession(t, time.Minute)
+	otherID := append([]byte{1, 2, 3, 4}, bytes.Repeat([]byte{0x06}, 16)...)
+	r.registerID(string(otherID), other)
+	r.learnCIDLength(other, 20)
+	if _, _, err := r.lookupShortHeader(shortHeaderPacket(otherID)); !errors.Is(err, errAmbiguousCID) {
+		t.Errorf("ambiguous lookup error = %v, want errAmbiguousCID", err)
+	}
+
+	// Closing the only 4-byte session stops trying that length.
+	r.closeSession(short, "test")
+	if got := r.cids.snapshot(); !slices.Equal(got, []int{20}) {
+		t.Errorf("lengths after close = %v, want [20]", got)
+	}
+	if sess, _, err := r.lookupShortHeader(shortHeaderPacket(otherID)); err != nil || sess != other {
+		t.Errorf("lookup after close = %p, %v, want %p", sess, err, other)
+	}
+}
@@ -0,0 +1,239 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+// StrategyShared labels sessions adopted from another replica through the
+// session directory.
+const StrategyShared = "shared"
+
+// directoryTimeout bounds each session directory call made on the packet path.
+const directoryTimeout = 500 * time.Millisecond
+
+// directoryMissTTL is how long a DCID that the directory does not know is
+// dropped without another lookup.
+const directoryMissTTL = time.Second
+
+// maxDirectoryLookups caps the directory lookups in flight, so that a flood of
+// unknown DCIDs cannot stall every worker on Redis.
+const maxDirectoryLookups = 32
+
+// SessionEntry describes where a session is relayed to, so that any replica
+// can take it over.
+type SessionEntry struct {
+	Session   []byte `json:"session"` // Client's original DCID, identifies the session
+	Target    string `json:"target"`
+	SNI       string `json:"sni,omitempty"`
+	CIDLength int    `json:"cid_length,omitempty"`
+}
+
+// SessionDirectory shares the connection ID to session mapping between
+// Porter replicas. Entries expire unless they are stored again.
+type SessionDirectory interface {
+	// Store maps every connection ID to the entry, or extends the lifetime
+	// of existing mappings.
+	Store(ctx context.Context, ids []string, entry SessionEntry) error
+	// Lookup returns the first of the connection IDs that has an entry, or
+	// a nil entry if none do.
+	Lookup(ctx context.Context, ids []string) (string, *SessionEntry, error)
+	// Delete removes the mappings of the connection IDs.
+	Delete(ctx context.Context, ids []string) error
+}
+
+// SetSessionDirectory shares this relay's sessions through the directory and
+// adopts sessions created by other replicas. It must be called before Start.
+func (r *Relay) SetSessionDirectory(directory SessionDirectory) {
+	r.directory = directory
+}
+
+// entry returns the directory entry of the session.
+func (s *session) entry() SessionEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return SessionEntry{
+		Session:   []byte(s.key),
+		Target:    s.target,
+		SNI:       s.sni,
+		CIDLength: s.cidLength,
+	}
+}
+
+// shareIDs publishes connection IDs of the session to the directory in the
+// background.
+func (r *Relay) shareIDs(sess *session, ids ...string) {
+	if r.directory == nil {
+		return
+	}
+	entry := sess.entry()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		defer cancel()
+		if err := r.directory.Store(ctx, ids, entry); err != nil {
+			log.Printf("Failed to share session %x: %v", entry.Session, err)
+		}
+	}()
+}
+
+// unshareIDs removes connection IDs of a closed session from the directory in
+// the background.
+func (r *Relay) unshareIDs(ids []string) {
+	if r.directory == nil || len(ids) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		defer cancel()
+		if err := r.directory.Delete(ctx, ids); err != nil {
+			log.Printf("Failed to remove shared session: %v", err)
+		}
+	}()
+}
+
+// refreshShared re-publishes the connection IDs of sessions that saw traffic
+// since they were last shared, once a third of the TTL has passed, so that
+// entries of active sessions do not expire.
+func (r *Relay) refreshShared(now time.Time) {
+	if r.directory == nil {
+		return
+	}
+
+	seen := make(map[*session]bool)
+	r.sessions.Range(func(_, val any) bool {
+		sess := val.(*session)
+		if seen[sess] {
+			return true
+		}
+		seen[sess] = true
+
+		sess.mu.Lock()
+		due := !sess.closed && sess.lastSeen.After(sess.sharedAt) && now.Sub(sess.sharedAt) > r.cfg.Redis.Sessions.TTL/3
+		ids := append([]string(nil), sess.ids...)
+		if due {
+			sess.sharedAt = now
+		}
+		sess.mu.Unlock()
+
+		if due {
+			r.shareIDs(sess, ids...)
+		}
+		return true
+	})
+}
+
+// pruneDirectoryMisses forgets DCIDs whose negative cache entry expired.
+func (r *Relay) pruneDirectoryMisses(now time.Time) {
+	r.directoryMisses.Range(func(key, until any) bool {
+		if !now.Before(until.(time.Time)) {
+			r.directoryMisses.Delete(key)
+		}
+		return true
+	})
+}
+
+// adoptSession looks the packet's DCID up in the session directory and, if
+// another replica shared it, relays the packet to the same backend through a
+// new backend socket. It reports whether the packet was forwarded.
+func (r *Relay) adoptSession(srcAddr *net.UDPAddr, dst netip.Addr, data []byte, header *quic.ParsedHeader) bool {
+	var candidates []string
+	if header.IsLongHeader {
+		candidates = []string{string(header.DCID)}
+	} else {
+		// Any length is possible on a replica that has not seen the backend yet.
+		for length := 1; length <= quic.MaxConnIDLength; length++ {
+			dcid, err := quic.ShortHeaderDCID(data, length)
+			if err != nil {
+				break
+			}
+			candidates = append(candidates, string(dcid))
+		}
+	}
+
+	// The longest candidate covers all the others.
+	prefix := candidates[len(candidates)-1]
+	now := time.Now()
+	if until, ok := r.directoryMisses.Load(prefix); ok && now.Before(until.(time.Time)) {
+		return false
+	}
+	if r.directoryLookups.Add(1) > maxDirectoryLookups {
+		r.directoryLookups.Add(-1)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	id, entry, err := r.directory.Lookup(ctx, candidates)
+	cancel()
+	r.directoryLookups.Add(-1)
+	if err != nil {
+		log.Printf("Session directory lookup failed: %v", err)
+		return false
+	}
+	if entry == nil {
+		r.directoryMisses.Store(prefix, now.Add(directoryMissTTL))
+		return false
+	}
+
+	if !r.acl.AllowRoute(entry.SNI, addrIP(srcAddr)) {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> refused (access policy, SNI: %s, DCID: %x)", srcAddr, entry.SNI, id)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropAccessDenied).Inc()
+		metrics.RelayAccessDenied.WithLabelValues(metrics.ACLRoute).Inc()
+		return true
+	}
+
+	// Another alias of the session may already have been adopted here.
+	if val, ok := r.sessions.Load(string(entry.Session)); ok {
+		sess := val.(*session)
+		if r.registerID(id, sess) {
+			r.forward(sess, data)
+			return true
+		}
+	}
+
+	targetAddr, err := net.ResolveUDPAddr("udp", entry.Target)
+	if err != nil {
+		log.Printf("Invalid shared target address %s: %v", entry.Target, err)
+		return false
+	}
+	if !r.admit(srcAddr, []byte(id)) {
+		return true
+	}
+	backendConn, err := r.dialBackend(srcAddr, targetAddr, entry.SNI)
+	if err != nil {
+		log.Printf("Error dialing backend %s: %v", entry.Target, err)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropBackendUnavailable).Inc()
+		return true
+	}
+
+	if r.cfg.UDP.LogRequests {
+		log.Printf("Relay: %s -> %s (adopted session, SNI: %s, DCID: %x)", srcAddr, entry.Target, entry.SNI, id)
+	} else {
+		log.Printf("Adopted session: %s -> %s (SNI: %s, DCID: %x)", srcAddr, entry.Target, entry.SNI, id)
+	}
+
+	sess := &session{
+		key:         string(entry.Session),
+		target:      entry.Target,
+		targetAddr:  targetAddr,
+		lastSeen:    time.Now(),
+		srcAddr:     srcAddr,
+		dstAddr:     dst,
+		backendConn: backendConn,
+		sni:         entry.SNI,
+		idleTimeout: r.idleTimeout(entry.SNI),
+		cidLength:   entry.CIDLength,
+		mtu:         r.mtu(entry.SNI),
+		proxyMode:   r.proxyMode(entry.SNI),
+	}
+	if r.startSession(sess, id, StrategyShared, [][]byte{data}) && len(entry.Session) > 0 {
+		r.registerID(string(entry.Session), sess)
+	}
+	return true
+}
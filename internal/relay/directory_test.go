@@ -0,0 +1,220 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+type memoryDirectory struct {
+	mu      sync.Mutex
+	entries map[string]SessionEntry
+	lookups int
+}
+
+func (d *memoryDirectory) Store(ctx context.Context, ids []string, entry SessionEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		d.entries[id] = entry
+	}
+	return nil
+}
+
+func (d *memoryDirectory) Lookup(ctx context.Context, ids []string) (string, *SessionEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lookups++
+	for _, id := range ids {
+		if entry, ok := d.entries[id]; ok {
+			return id, &entry, nil
+		}
+	}
+	return "", nil, nil
+}
+
+func (d *memoryDirectory) Delete(ctx context.Context, ids []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		delete(d.entries, id)
+	}
+	return nil
+}
+
+func (d *memoryDirectory) lookupCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lookups
+}
+
+func (d *memoryDirectory) get(id string) (SessionEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	return entry, ok
+}
+
+func TestAdoptSharedSession(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer backend.Close()
+	target := backend.LocalAddr().String()
+
+	directory := &memoryDirectory{entries: make(map[string]SessionEntry)}
+	newReplica := func() *Relay {
+		r := &Relay{cfg: &config.Config{}, routeOptions: strategy.NewPatternTable[routeOptions]()}
+		r.SetSessionDirectory(directory)
+		return r
+	}
+
+	// Replica A creates the session and shares its DCID.
+	a := newReplica()
+	conn, err := net.DialUDP("udp", nil, backend.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &session{
+		target:      target,
+		targetAddr:  backend.LocalAddr().(*net.UDPAddr),
+		lastSeen:    time.Now(),
+		srcAddr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+		backendConn: conn,
+		sni:         "game.example.com",
+		cidLength:   6,
+	}
+	clientDCID := "\x11\x12\x13\x14\x15\x16\x17\x18"
+	a.startSession(sess, clientDCID, "simple", nil)
+	defer a.closeSession(sess, "test")
+
+	serverCID := "\x21\x22\x23\x24\x25\x26"
+	a.shareIDs(sess, serverCID)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := directory.get(serverCID); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the server CID to be shared")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if entry, _ := directory.get(clientDCID); string(entry.Session) != clientDCID || entry.Target != target || entry.CIDLength != 6 {
+		t.Fatalf("Shared entry = %+v", entry)
+	}
+
+	// Replica B receives a short header packet for the session and adopts it.
+	b := newReplica()
+	packet := shortHeaderPacket([]byte(serverCID))
+	header, _ := quic.ParsePacket(packet)
+	b.handlePacket(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001}, netip.Addr{}, packet, header)
+
+	backend.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := backend.ReadFromUDP(buf)
+	if err != nil || !bytes.Equal(buf[:n], packet) {
+		t.Fatalf("Backend received %x, %v, want %x", buf[:n], err, packet)
+	}
+
+	val, ok := b.sessions.Load(serverCID)
+	if !ok {
+		t.Fatal("Expected the adopted session to be registered")
+	}
+	adopted := val.(*session)
+	defer b.closeSession(adopted, "test")
+	if adopted.sni != "game.example.com" || adopted.cidLength != 6 {
+		t.Errorf("Adopted session = %+v", adopted)
+	}
+	if val, ok := b.sessions.Load(clientDCID); !ok || val.(*session) != adopted {
+		t.Error("Expected the session key to be registered as an alias")
+	}
+}
+
+func TestAdoptSessionLookupLimits(t *testing.T) {
+	directory := &memoryDirectory{entries: make(map[string]SessionEntry)}
+	r := &Relay{cfg: &config.Config{}, routeOptions: strategy.NewPatternTable[routeOptions]()}
+	r.SetSessionDirectory(directory)
+
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+	packet := shortHeaderPacket([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	header, _ := quic.ParsePacket(packet)
+
+	r.handlePacket(src, netip.Addr{}, packet, header)
+	r.handlePacket(src, netip.Addr{}, packet, header)
+	if n := directory.lookupCount(); n != 1 {
+		t.Fatalf("Lookups = %d, want 1 with the miss cached", n)
+	}
+
+	r.pruneDirectoryMisses(time.Now().Add(directoryMissTTL))
+	r.handlePacket(src, netip.Addr{}, packet, header)
+	if n := directory.lookupCount(); n != 2 {
+		t.Fatalf("Lookups = %d, want 2 after the miss expired", n)
+	}
+
+	other := shortHeaderPacket([]byte{9, 10, 11, 12, 13, 14, 15, 16})
+	header, _ = quic.ParsePacket(other)
+	r.directoryLookups.Store(maxDirectoryLookups)
+	r.handlePacket(src, netip.Addr{}, other, header)
+	if n := directory.lookupCount(); n != 2 {
+		t.Errorf("Lookups = %d, want 2 with the in-flight cap reached", n)
+	}
+	r.directoryLookups.Store(0)
+	r.handlePacket(src, netip.Addr{}, other, header)
+	if n := directory.lookupCount(); n != 3 {
+		t.Errorf("Lookups = %d, want 3 below the in-flight cap", n)
+	}
+}
+
+func TestCloseSessionUnshares(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer backend.Close()
+
+	directory := &memoryDirectory{entries: make(map[string]SessionEntry)}
+	r := &Relay{cfg: &config.Config{}, routeOptions: strategy.NewPatternTable[routeOptions]()}
+	r.SetSessionDirectory(directory)
+
+	conn, err := net.DialUDP("udp", nil, backend.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &session{
+		target:      backend.LocalAddr().String(),
+		targetAddr:  backend.LocalAddr().(*net.UDPAddr),
+		lastSeen:    time.Now(),
+		srcAddr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+		backendConn: conn,
+	}
+	id := "\x31\x32\x33\x34\x35\x36\x37\x38"
+	r.startSession(sess, id, "simple", nil)
+
+	waitFor := func(shared bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, ok := directory.get(id); ok == shared {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected shared = %v", shared)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(true)
+	r.closeSession(sess, "test")
+	waitFor(false)
+}
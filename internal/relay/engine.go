@@ -1,33 +1,59 @@
 package relay
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ewancrowle/porter/internal/accesslog"
+	"github.com/ewancrowle/porter/internal/acl"
 	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
 	"github.com/ewancrowle/porter/internal/quic"
 	"github.com/ewancrowle/porter/internal/strategy"
 )
 
 type Relay struct {
 	listenAddr *net.UDPAddr
-	conn       *net.UDPConn
+	listeners  []*listener
 	manager    *strategy.StrategyManager
 	cfg        *config.Config
 
-	sessions sync.Map
-}
+	workers []chan inPacket
+	buffers sync.Pool // *[]byte of maxDatagramSize
+
+	lifecycle      sync.RWMutex
+	listening      bool
+	drainStarted   time.Time // Zero unless draining
+	stopped        bool
+	activeSessions atomic.Int64
+	backendReaders sync.WaitGroup
+
+	sessions     sync.Map
+	cids         cidRegistry
+	routeOptions *strategy.PatternTable[routeOptions] // FQDN pattern -> per-route settings
+
+	pending      sync.Map // DCID -> *pendingHandshake
+	pendingBytes atomic.Int64
+
+	targetSessions sync.Map // target -> *atomic.Int64
 
-type session struct {
-	targetAddr  *net.UDPAddr
-	lastSeen    time.Time
-	mu          sync.RWMutex
-	srcAddr     *net.UDPAddr
-	backendConn *net.UDPConn
+	directoryMisses  sync.Map // DCID prefix -> time.Time until which lookups are skipped
+	directoryLookups atomic.Int64
+
+	lb        *loadBalancer     // nil unless QUIC-LB is enabled
+	directory SessionDirectory  // nil unless sessions are shared between replicas
+	accessLog *accesslog.Logger // nil unless access logging is enabled
+	limits    *rateLimiter      // nil unless rate limits are configured
+	retry     *retryService     // nil unless Retry is enabled
+	acl       *acl.Policy       // nil allows every client
 }
 
 func NewRelay(cfg *config.Config, manager *strategy.StrategyManager) (*Relay, error) {
@@ -36,189 +62,497 @@ func NewRelay(cfg *config.Config, manager *strategy.StrategyManager) (*Relay, er
 		return nil, err
 	}
 
-	return &Relay{
-		listenAddr: addr,
-		manager:    manager,
-		cfg:        cfg,
-	}, nil
+	options, err := newRouteOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UDP.CIDLength < 0 || cfg.UDP.CIDLength > quic.MaxConnIDLength {
+		return nil, fmt.Errorf("invalid udp.cid_length %d", cfg.UDP.CIDLength)
+	}
+	if size := cfg.UDP.MaxDatagramSize; size != 0 && (size < quic.MinInitialDatagramSize || size > maxDatagramSize) {
+		return nil, fmt.Errorf("udp.max_datagram_size must be between %d and %d", quic.MinInitialDatagramSize, maxDatagramSize)
+	}
+
+	r := &Relay{
+		listenAddr:   addr,
+		manager:      manager,
+		cfg:          cfg,
+		routeOptions: options,
+	}
+	if cfg.QUICLB.Enabled {
+		if r.lb, err = newLoadBalancer(cfg.QUICLB.Configs); err != nil {
+			return nil, err
+		}
+	}
+	if r.limits, err = newRateLimiter(cfg); err != nil {
+		return nil, err
+	}
+	if r.retry, err = newRetryService(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
+// SetAccessLog writes a record for every session start and end to the
+// logger. It must be called before Start.
+func (r *Relay) SetAccessLog(logger *accesslog.Logger) {
+	r.accessLog = logger
+}
+
+// SetAccessPolicy rejects new connections from clients the policy does not
+// allow. It must be called before Start; the policy itself may change at any
+// time.
+func (r *Relay) SetAccessPolicy(policy *acl.Policy) {
+	r.acl = policy
+}
+
+// Start relays packets until the context is cancelled, then closes every
+// session. Call Drain first to let sessions end on their own.
 func (r *Relay) Start(ctx context.Context) error {
-	conn, err := net.ListenUDP("udp", r.listenAddr)
-	if err != nil {
+	if err := r.listen(); err != nil {
 		return err
 	}
-	r.conn = conn
-	defer r.conn.Close()
+	defer r.closeListeners()
 
-	log.Printf("UDP Relay listening on %s", r.listenAddr.String())
+	r.lifecycle.Lock()
+	r.listening = true
+	r.lifecycle.Unlock()
 
-	buf := make([]byte, 2048)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			n, srcAddr, err := r.conn.ReadFromUDP(buf)
-			if err != nil {
-				log.Printf("Error reading from UDP: %v", err)
-				continue
-			}
+	if len(r.listeners) > 1 {
+		log.Printf("UDP Relay listening on %s (%d sockets)", r.localAddr(), len(r.listeners))
+	} else {
+		log.Printf("UDP Relay listening on %s", r.localAddr())
+	}
 
-			data := make([]byte, n)
-			copy(data, buf[:n])
+	r.setupIO()
 
-			go r.processUDPDatagram(srcAddr, data)
-		}
+	// Closing the listeners unblocks the read loops.
+	go func() {
+		<-ctx.Done()
+		r.closeListeners()
+	}()
+	r.serve(ctx)
+
+	r.shutdown()
+	log.Printf("UDP Relay stopped")
+	return nil
+}
+
+// setupIO prepares batched I/O on the listeners and starts the workers and
+// the client writers. Client datagrams are read in batches and processed by
+// a fixed pool of workers; backend datagrams are written back to clients in
+// batches.
+func (r *Relay) setupIO() {
+	for _, ln := range r.listeners {
+		ln.batch = newBatchConn(ln.conn)
+		ln.gro = r.cfg.UDP.GRO && enableGRO(ln.conn)
+		ln.pktInfo = enablePktInfo(ln.conn)
+		ln.gso.Store(r.cfg.UDP.GSO && gsoSupported(ln.conn))
+		ln.writeQueue = make(chan outPacket, max(r.cfg.UDP.QueueSize, 1))
+		go r.writeLoop(ln)
 	}
+	if ln := r.listeners[0]; ln.gro || ln.gso.Load() {
+		log.Printf("UDP offload enabled (GRO: %t, GSO: %t)", ln.gro, ln.gso.Load())
+	}
+	r.startWorkers()
 }
 
-func (r *Relay) processUDPDatagram(srcAddr *net.UDPAddr, data []byte) {
+// serve relays client datagrams until the context is cancelled, with one
+// read loop per listener.
+func (r *Relay) serve(ctx context.Context) {
+	defer r.stopWorkers()
+
+	go r.reapIdleSessions(ctx)
+
+	var wg sync.WaitGroup
+	for _, ln := range r.listeners {
+		wg.Go(func() { r.readLoop(ctx, ln) })
+	}
+	wg.Wait()
+}
+
+// processUDPDatagram forwards the QUIC packets coalesced in a client datagram.
+// Packets of established sessions are forwarded by the calling worker. The
+// first packet without a session, and everything after it, is copied out of
+// the pooled buffer and handled on its own goroutine, since it may wait on
+// strategy resolution, Redis or a pending handshake.
+func (r *Relay) processUDPDatagram(srcAddr *net.UDPAddr, dst netip.Addr, data []byte) {
 	curr := 0
 	for curr < len(data) {
-		header, err := quic.ParsePacket(data[curr:])
+		header, ok := r.parseNext(srcAddr, data[curr:], curr == 0)
+		if !ok {
+			return
+		}
+
+		packetData := data[curr : curr+header.FullLength]
+		sess, dcid, err := r.lookupSession(packetData, header)
 		if err != nil {
-			if r.cfg.UDP.LogRequests && curr == 0 {
-				log.Printf("Relay: %s -> unknown (parse error: %v)", srcAddr, err)
-			}
+			go r.handlePackets(srcAddr, dst, bytes.Clone(data[curr:]), curr == 0)
+			return
+		}
+		r.forwardFromClient(srcAddr, dst, sess, dcid, packetData)
+
+		curr += header.FullLength
+		if !header.IsLongHeader {
+			// Only long header packets can be followed by another packet.
+			break
+		}
+	}
+}
+
+// handlePackets handles every QUIC packet of a client datagram in turn.
+func (r *Relay) handlePackets(srcAddr *net.UDPAddr, dst netip.Addr, data []byte, first bool) {
+	curr := 0
+	for curr < len(data) {
+		header, ok := r.parseNext(srcAddr, data[curr:], first && curr == 0)
+		if !ok {
 			return
 		}
 
 		packetData := data[curr : curr+header.FullLength]
-		r.handlePacket(srcAddr, packetData, header)
+		r.handlePacket(srcAddr, dst, packetData, header)
 
 		curr += header.FullLength
 		if !header.IsLongHeader {
-			// Short header packets are not coalesced with other packets in the same way,
-			// or at least they are usually the last ones.
-			// RFC 9000 says: "A sender MUST NOT coalesce multiple QUIC packets
-			// into a single UDP datagram unless it is certain that the receiver
-			// will be able to process them... only long header packets can be coalesced."
-			// Actually, short header packets can be preceded by long header packets.
-			// But once we see a short header, it's typically the end or we can't easily find the next one.
 			break
 		}
 	}
 }
 
-func (r *Relay) handlePacket(srcAddr *net.UDPAddr, data []byte, header *quic.ParsedHeader) {
-	dcid := string(header.DCID)
+// parseNext parses the packet at the start of data, counting it as dropped
+// if that fails. Only failures on the first packet of a datagram are logged.
+func (r *Relay) parseNext(srcAddr *net.UDPAddr, data []byte, first bool) (*quic.ParsedHeader, bool) {
+	header, err := quic.ParsePacket(data)
+	if err != nil {
+		if r.cfg.UDP.LogRequests && first {
+			log.Printf("Relay: %s -> unknown (parse error: %v)", srcAddr, err)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(dropReason(err)).Inc()
+		return nil, false
+	}
+	return header, true
+}
+
+// handlePacket handles a client packet sent to dst, which is zero if the
+// kernel did not report it.
+func (r *Relay) handlePacket(srcAddr *net.UDPAddr, dst netip.Addr, data []byte, header *quic.ParsedHeader) {
 	srcStr := srcAddr.String()
 
-	if val, ok := r.sessions.Load(dcid); ok {
-		sess := val.(*session)
-		sess.mu.Lock()
-		if sess.srcAddr.String() != srcStr {
-			if r.cfg.UDP.LogRequests {
-				log.Printf("Relay: %s -> %s (migrated from %s, DCID: %x)", srcStr, sess.targetAddr, sess.srcAddr, header.DCID)
-			}
-			sess.srcAddr = srcAddr
+	sess, dcid, err := r.lookupSession(data, header)
+	if err == nil {
+		r.forwardFromClient(srcAddr, dst, sess, dcid, data)
+		return
+	}
+
+	// Global rules apply before a session is resumed or adopted, or the
+	// Initial is decrypted.
+	if !r.acl.AllowGlobal(addrIP(srcAddr)) {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> refused (access policy, DCID: %x)", srcStr, header.DCID)
 		}
-		sess.lastSeen = time.Now()
-		backendConn := sess.backendConn
-		sess.mu.Unlock()
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropAccessDenied).Inc()
+		metrics.RelayAccessDenied.WithLabelValues(metrics.ACLGlobal).Inc()
+		return
+	}
+
+	// Only DCIDs issued by the server can carry a QUIC-LB server ID. Initial
+	// and 0-RTT packets use the client's random DCID.
+	serverIssued := !header.IsLongHeader || header.Type == quic.PacketTypeHandshake
+	if r.lb != nil && serverIssued && r.resumeSession(srcAddr, dst, data, header) {
+		return
+	}
+	isInitial := header.IsLongHeader && header.Type == quic.PacketTypeInitial
+	if r.directory != nil && !isInitial && r.adoptSession(srcAddr, dst, data, header) {
+		return
+	}
 
-		r.forward(backendConn, data)
+	if !header.IsLongHeader {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> unknown (%v, CID lengths: %v)", srcStr, err, r.cids.snapshot())
+		}
+		reason := metrics.DropUnknownCID
+		if errors.Is(err, errAmbiguousCID) {
+			reason = metrics.DropAmbiguousCID
+		}
+		metrics.RelayPacketDrops.WithLabelValues(reason).Inc()
 		return
 	}
 
-	if !header.IsLongHeader || header.Type != 0x00 {
+	if !isInitial {
 		if r.cfg.UDP.LogRequests {
 			log.Printf("Relay: %s -> unknown (no session and not an Initial packet, DCID: %x)", srcStr, header.DCID)
 		}
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropNoSession).Inc()
 		return
 	}
 
-	sni, err := quic.ExtractSNI(data)
-	if err != nil {
+	if r.draining() {
 		if r.cfg.UDP.LogRequests {
-			log.Printf("Relay: %s -> unknown (failed to extract SNI: %v, DCID: %x)", srcStr, err, header.DCID)
+			log.Printf("Relay: %s -> refused (draining, DCID: %x)", srcStr, header.DCID)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropDraining).Inc()
+		return
+	}
+
+	// Only the first Initial of a connection counts against the limits, later
+	// ones join its pending handshake.
+	if _, pending := r.pending.Load(string(header.DCID)); !pending {
+		if !r.admit(srcAddr, header.DCID) {
+			return
 		}
+	}
+
+	r.handleInitial(srcAddr, dst, data, header)
+}
+
+// admit checks a client that is about to start a session against the rate
+// limits, counting the packet as dropped if it exceeds one.
+func (r *Relay) admit(srcAddr *net.UDPAddr, dcid []byte) bool {
+	limit, ok := r.limits.admit(srcAddr, time.Now())
+	if !ok {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> refused (%s rate limit, DCID: %x)", srcAddr, limit, dcid)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropRateLimited).Inc()
+		metrics.RelayRateLimited.WithLabelValues(limit).Inc()
+	}
+	return ok
+}
+
+// forwardFromClient forwards a client packet to its session's backend,
+// following the client to its new address if it migrated. A migrated client
+// gets a fresh PROXY header in first mode.
+func (r *Relay) forwardFromClient(srcAddr *net.UDPAddr, dst netip.Addr, sess *session, dcid []byte, data []byte) {
+	sess.mu.Lock()
+	if !sess.srcAddr.IP.Equal(srcAddr.IP) || sess.srcAddr.Port != srcAddr.Port || sess.dstAddr != dst {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> %s (migrated from %s, DCID: %x)", srcAddr, sess.targetAddr, sess.srcAddr, dcid)
+		}
+		sess.srcAddr = srcAddr
+		sess.dstAddr = dst
+		sess.proxySent = false
+		sess.migrations.Add(1)
+	}
+	sess.lastSeen = time.Now()
+	sess.mu.Unlock()
+
+	r.forward(sess, data)
+}
+
+// lookupSession finds the session a client packet belongs to. Long headers
+// carry their DCID length; short headers are matched against the connection
+// ID lengths in use.
+func (r *Relay) lookupSession(data []byte, header *quic.ParsedHeader) (*session, []byte, error) {
+	if !header.IsLongHeader {
+		return r.lookupShortHeader(data)
+	}
+	if val, ok := r.sessions.Load(string(header.DCID)); ok {
+		return val.(*session), header.DCID, nil
+	}
+	return nil, nil, errors.New("no session for DCID")
+}
+
+// createSession resolves the backend for the SNI, opens a backend socket and
+// forwards the given client packets, in order, to it.
+func (r *Relay) createSession(srcAddr *net.UDPAddr, dst netip.Addr, header *quic.ParsedHeader, sni string, packets [][]byte) {
+	dcid := string(header.DCID)
+	srcStr := srcAddr.String()
+
+	if !r.acl.AllowRoute(sni, addrIP(srcAddr)) {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> refused (access policy, SNI: %s, DCID: %x)", srcStr, sni, header.DCID)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropAccessDenied).Add(float64(len(packets)))
+		metrics.RelayAccessDenied.WithLabelValues(metrics.ACLRoute).Inc()
+		return
+	}
+
+	if !r.checkRetry(srcAddr, header, sni, len(packets)) {
 		return
 	}
 
-	target, err := r.resolveTarget(sni)
+	target, strategyType, err := r.resolveTarget(srcAddr, sni)
 	if err != nil {
 		if r.cfg.UDP.LogRequests {
 			log.Printf("Relay: %s -> unknown (SNI: %s, error: %v, DCID: %x)", srcStr, sni, err, header.DCID)
 		}
 		log.Printf("Failed to resolve target for SNI %s: %v", sni, err)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropNoRoute).Add(float64(len(packets)))
 		return
 	}
 
 	targetAddr, err := net.ResolveUDPAddr("udp", target)
 	if err != nil {
 		log.Printf("Invalid target address %s: %v", target, err)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropBackendUnavailable).Add(float64(len(packets)))
 		return
 	}
 
 	if r.cfg.UDP.LogRequests {
-		log.Printf("Relay: %s -> %s (new session, SNI: %s, DCID: %x)", srcStr, target, sni, header.DCID)
+		log.Printf("Relay: %s -> %s (new session, SNI: %s, DCID: %x, packets: %d)", srcStr, target, sni, header.DCID, len(packets))
 	} else {
 		log.Printf("New session: %s -> %s (SNI: %s, DCID: %x)", srcStr, target, sni, header.DCID)
 	}
 
-	backendConn, err := net.DialUDP("udp", nil, targetAddr)
+	backendConn, err := r.dialBackend(srcAddr, targetAddr, sni)
 	if err != nil {
 		log.Printf("Error dialing backend %s: %v", target, err)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropBackendUnavailable).Add(float64(len(packets)))
 		return
 	}
 
 	newSess := &session{
+		target:      target,
 		targetAddr:  targetAddr,
 		lastSeen:    time.Now(),
 		srcAddr:     srcAddr,
+		dstAddr:     dst,
 		backendConn: backendConn,
+		sni:         sni,
+		idleTimeout: r.idleTimeout(sni),
+		cidLength:   r.cidLength(sni),
+		mtu:         r.mtu(sni),
+		proxyMode:   r.proxyMode(sni),
+	}
+	r.startSession(newSess, dcid, string(strategyType), packets)
+}
+
+// startSession registers the session under the connection ID, starts relaying
+// backend responses and forwards the packets. If another goroutine created a
+// session for the same ID first, the packets go to that session instead and
+// startSession returns false. It also returns false once the relay has
+// stopped.
+func (r *Relay) startSession(sess *session, id string, strategyType string, packets [][]byte) bool {
+	if sess.key == "" {
+		sess.key = id
+	}
+	sess.sharedAt = time.Now()
+	sess.strategy = strategyType
+	sess.started = time.Now()
+	sess.logged = r.accessLog.Sample()
+	sess.clientIP = addrIP(sess.srcAddr)
+
+	// Hold off shutdown until the session is registered, so it gets closed.
+	r.lifecycle.RLock()
+	defer r.lifecycle.RUnlock()
+	if r.stopped {
+		sess.backendConn.Close()
+		return false
+	}
+
+	r.cids.add(sess.cidLength)
+	if !r.registerID(id, sess) {
+		r.cids.remove(sess.cidLength)
+		sess.backendConn.Close()
+		if val, ok := r.sessions.Load(id); ok {
+			for _, packet := range packets {
+				r.forward(val.(*session), packet)
+			}
+		}
+		return false
+	}
+	r.shareIDs(sess, id)
+
+	r.targetSessionCount(sess.target).Add(1)
+	r.activeSessions.Add(1)
+	r.limits.sessionStarted(sess.clientIP)
+	metrics.RelayActiveSessions.Inc()
+	metrics.RelaySessions.WithLabelValues(r.routePattern(sess.sni), strategyType).Inc()
+	if sess.logged {
+		r.accessLog.Log(sess.accessRecord(accesslog.EventSessionStart))
 	}
-	r.sessions.Store(dcid, newSess)
 
-	go r.handleBackendResponse(newSess)
+	r.backendReaders.Add(1)
+	go r.handleBackendResponse(sess)
 
-	r.forward(backendConn, data)
+	for _, packet := range packets {
+		r.forward(sess, packet)
+	}
+	return true
 }
 
-func (r *Relay) resolveTarget(sni string) (string, error) {
+// routePattern returns the route pattern the SNI matches, trying the
+// strategies in the order resolveTarget does, or "" if none matches. Metrics
+// use it instead of the SNI, which clients choose freely under wildcard and
+// suffix routes.
+func (r *Relay) routePattern(sni string) string {
+	if sni == "" || r.manager == nil {
+		return ""
+	}
+	for _, t := range []strategy.StrategyType{strategy.StrategySimple, strategy.StrategyGeoIP, strategy.StrategyAgones} {
+		if m, ok := r.manager.Get(t).(strategy.RouteMatcher); ok {
+			if pattern, ok := m.MatchRoute(sni); ok {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
+
+func (r *Relay) resolveTarget(srcAddr *net.UDPAddr, sni string) (string, strategy.StrategyType, error) {
+	ctx := strategy.WithRequest(context.Background(), strategy.Request{ClientAddr: srcAddr, SNI: sni})
+
 	if s := r.manager.Get(strategy.StrategySimple); s != nil {
-		if target, err := s.Resolve(context.Background(), sni); err == nil {
-			return target, nil
+		if target, err := s.Resolve(ctx, sni); err == nil {
+			return target, strategy.StrategySimple, nil
+		}
+	}
+
+	if s := r.manager.Get(strategy.StrategyGeoIP); s != nil {
+		if target, err := s.Resolve(ctx, sni); err == nil {
+			return target, strategy.StrategyGeoIP, nil
 		}
 	}
 
 	if s := r.manager.Get(strategy.StrategyAgones); s != nil {
-		if target, err := s.Resolve(context.Background(), sni); err == nil {
-			return target, nil
+		if target, err := s.Resolve(ctx, sni); err == nil {
+			return target, strategy.StrategyAgones, nil
 		}
 	}
 
-	return "", fmt.Errorf("no route for SNI %s", sni)
+	return "", "", fmt.Errorf("no route for SNI %s", sni)
 }
 
 func (r *Relay) handleBackendResponse(sess *session) {
-	defer sess.backendConn.Close()
-	buf := make([]byte, 2048)
+	defer r.backendReaders.Done()
 	for {
-		n, err := sess.backendConn.Read(buf)
+		buf := r.getBuffer()
+		n, _, flags, _, err := sess.backendConn.ReadMsgUDP(*buf, nil)
 		if err != nil {
-			// This will also be triggered when the connection is closed.
+			r.putBuffer(buf)
+			// This will also be triggered when the reaper closes the connection,
+			// in which case closeSession is a no-op.
+			r.closeSession(sess, fmt.Sprintf("backend read failed: %v", err))
 			return
 		}
+		sess.touch()
+		if truncated(flags) {
+			r.putBuffer(buf)
+			metrics.RelayTruncated.WithLabelValues(metrics.DirectionBackendToClient).Inc()
+			continue
+		}
+		if sess.mtu > 0 && n > sess.mtu {
+			r.putBuffer(buf)
+			metrics.RelayOversized.WithLabelValues(metrics.DirectionBackendToClient).Inc()
+			continue
+		}
+		data := (*buf)[:n]
 
 		// Response Snooping: Extract Server-Initiated IDs
 		curr := 0
 		for curr < n {
-			header, err := quic.ParsePacket(buf[curr:n])
+			header, err := quic.ParsePacket(data[curr:])
 			if err != nil {
 				break // Stop parsing if we can't read headers, just forward the blob
 			}
 
-			// Snoop the Server's Source Connection ID
+			// Snoop the Server's Source Connection ID, which the client uses
+			// as the DCID of its short header packets.
 			if header.IsLongHeader && len(header.SCID) > 0 {
-				serverSCID := string(header.SCID)
-				r.sessions.LoadOrStore(serverSCID, sess)
-
-				// Register the 8-byte prefix for Short Header matches
-				if len(serverSCID) > 8 {
-					r.sessions.LoadOrStore(serverSCID[:8], sess)
+				scid := string(header.SCID)
+				if _, known := r.sessions.Load(scid); !known && r.registerID(scid, sess) {
+					r.learnCIDLength(sess, len(header.SCID))
+					r.shareIDs(sess, scid)
 				}
 			}
 
@@ -232,17 +566,53 @@ func (r *Relay) handleBackendResponse(sess *session) {
 		clientAddr := sess.srcAddr
 		sess.mu.RUnlock()
 
-		_, err = r.conn.WriteToUDP(buf[:n], clientAddr)
-		if err != nil {
-			log.Printf("Error writing back to client %v: %v", clientAddr, err)
-			return
-		}
+		r.sendToClient(sess, clientAddr, buf, n)
 	}
 }
 
-func (r *Relay) forward(conn *net.UDPConn, data []byte) {
-	_, err := conn.Write(data)
+// forward sends a client packet to the session's backend, prepending a PROXY
+// protocol header if the route asks for one.
+func (r *Relay) forward(sess *session, data []byte) {
+	// The MTU applies to the client's datagram, not the PROXY header.
+	if sess.mtu > 0 && len(data) > sess.mtu {
+		metrics.RelayOversized.WithLabelValues(metrics.DirectionClientToBackend).Inc()
+		return
+	}
+	// Traffic is counted without the PROXY header, as the client sent it.
+	size := len(data)
+	header := sess.proxyHeader(r.localAddr())
+	if header != nil {
+		data = append(header, data...)
+	}
+
+	_, err := sess.backendConn.Write(data)
 	if err != nil {
 		log.Printf("Error writing to backend: %v", err)
+		return
+	}
+	if header != nil {
+		sess.proxyHeaderSent()
+	}
+	if sess.logged {
+		sess.stats.packetsIn.Add(1)
+		sess.stats.bytesIn.Add(int64(size))
+	}
+	metrics.RelayPackets.WithLabelValues(metrics.DirectionClientToBackend).Inc()
+	metrics.RelayBytes.WithLabelValues(metrics.DirectionClientToBackend).Add(float64(size))
+}
+
+// dropReason maps a packet parsing or SNI extraction error to a metrics label.
+func dropReason(err error) string {
+	switch {
+	case errors.Is(err, quic.ErrUnsupportedVersion), errors.Is(err, quic.ErrVersionNegotiation):
+		return metrics.DropUnsupportedVersion
+	case errors.Is(err, quic.ErrDecryptFailed):
+		return metrics.DropDecryptFailed
+	case errors.Is(err, quic.ErrMalformedPayload):
+		return metrics.DropMalformedPayload
+	case errors.Is(err, quic.ErrInvalidHello):
+		return metrics.DropSNINotFound
+	default:
+		return metrics.DropParseError
 	}
 }
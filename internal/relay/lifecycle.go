@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Status describes the relay's lifecycle, for readiness reporting.
+type Status struct {
+	Listening      bool       `json:"listening"`
+	Draining       bool       `json:"draining"`
+	DrainStarted   *time.Time `json:"drain_started,omitempty"`
+	ActiveSessions int        `json:"active_sessions"`
+}
+
+// Status returns the relay's current lifecycle state.
+func (r *Relay) Status() Status {
+	r.lifecycle.RLock()
+	defer r.lifecycle.RUnlock()
+
+	status := Status{
+		Listening:      r.listening,
+		Draining:       !r.drainStarted.IsZero(),
+		ActiveSessions: int(r.activeSessions.Load()),
+	}
+	if status.Draining {
+		started := r.drainStarted
+		status.DrainStarted = &started
+	}
+	return status
+}
+
+// draining reports whether the relay has stopped accepting new connections.
+func (r *Relay) draining() bool {
+	r.lifecycle.RLock()
+	defer r.lifecycle.RUnlock()
+	return !r.drainStarted.IsZero()
+}
+
+// Drain stops the relay from accepting new connections and waits until every
+// session has ended or the context is done. Established sessions keep being
+// relayed in the meantime.
+func (r *Relay) Drain(ctx context.Context) error {
+	r.lifecycle.Lock()
+	if r.drainStarted.IsZero() {
+		r.drainStarted = time.Now()
+	}
+	r.lifecycle.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	lastLog := time.Now()
+	for {
+		active := r.activeSessions.Load()
+		if active == 0 {
+			log.Printf("Relay drained")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if now.Sub(lastLog) >= 5*time.Second {
+				log.Printf("Draining relay: %d sessions active", active)
+				lastLog = now
+			}
+		}
+	}
+}
+
+// shutdown closes every session once the listeners are closed and waits for
+// their backend readers to stop. No session can be started afterwards.
+func (r *Relay) shutdown() {
+	r.lifecycle.Lock()
+	r.stopped = true
+	r.listening = false
+	r.lifecycle.Unlock()
+
+	r.sessions.Range(func(_, val any) bool {
+		r.closeSession(val.(*session), "shutdown")
+		return true
+	})
+	r.backendReaders.Wait()
+
+	for _, ln := range r.listeners {
+		close(ln.writeQueue)
+	}
+}
@@ -0,0 +1,57 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDrain(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, sess := startServing(t, testServeConfig(false), client, backend)
+
+	if status := r.Status(); status.Draining || status.ActiveSessions != 1 {
+		t.Fatalf("Status() = %+v, want 1 active session and not draining", status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain() error = %v, want deadline exceeded with a session open", err)
+	}
+
+	status := r.Status()
+	if !status.Draining || status.DrainStarted == nil {
+		t.Errorf("Status() = %+v, want draining", status)
+	}
+
+	// New connections are refused while established sessions keep working.
+	initial, header := testInitial(t, 1)
+	drops := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropDraining))
+	r.handlePacket(client.LocalAddr().(*net.UDPAddr), netip.Addr{}, initial, header)
+	if got := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropDraining)) - drops; got != 1 {
+		t.Errorf("Counted %v draining drops, want 1", got)
+	}
+	if _, ok := r.pending.Load(string(header.DCID)); ok {
+		t.Error("Expected the Initial not to start a handshake")
+	}
+
+	if _, err := client.WriteToUDP(sizedPacket(1, 100), r.localAddr()); err != nil {
+		t.Fatal(err)
+	}
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := backend.ReadFromUDP(make([]byte, 2048)); err != nil {
+		t.Fatalf("Expected the session to keep relaying while draining: %v", err)
+	}
+
+	r.closeSession(sess, "test")
+	if err := r.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() error = %v once the last session closed", err)
+	}
+}
@@ -0,0 +1,53 @@
+package relay
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// listener is one of the relay's UDP sockets, with its own read loop and
+// client writer.
+type listener struct {
+	conn       *net.UDPConn
+	batch      batchConn
+	gro        bool
+	pktInfo    bool // Datagrams carry their destination address
+	gso        atomic.Bool
+	writeQueue chan outPacket
+}
+
+// listen opens the relay's sockets. With udp.listeners above 1 they share
+// the port through SO_REUSEPORT, and the kernel spreads clients across them
+// by address. The session table is shared, so a client that migrates to
+// another socket keeps its session.
+func (r *Relay) listen() error {
+	count := max(r.cfg.UDP.Listeners, 1)
+	if count == 1 {
+		conn, err := net.ListenUDP("udp", r.listenAddr)
+		if err != nil {
+			return err
+		}
+		r.listeners = []*listener{{conn: conn}}
+		return nil
+	}
+
+	addr := r.listenAddr
+	for range count {
+		conn, err := listenReusePort(addr)
+		if err != nil {
+			r.closeListeners()
+			return err
+		}
+		r.listeners = append(r.listeners, &listener{conn: conn})
+
+		// With port 0, the first socket picks the port the others share.
+		addr = &net.UDPAddr{IP: r.listenAddr.IP, Port: conn.LocalAddr().(*net.UDPAddr).Port, Zone: r.listenAddr.Zone}
+	}
+	return nil
+}
+
+func (r *Relay) closeListeners() {
+	for _, ln := range r.listeners {
+		ln.conn.Close()
+	}
+}
@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+// pendingHandshake buffers the client Initial packets of a connection whose
+// ClientHello spans more than one packet, until the SNI can be resolved.
+type pendingHandshake struct {
+	mu        sync.Mutex
+	assembler *quic.ClientHelloAssembler
+	packets   [][]byte
+	size      int
+	created   time.Time
+	resolving bool // ClientHello complete, session being created
+	closed    bool
+}
+
+// handleInitial feeds a client Initial packet for an unknown DCID into its
+// pending handshake. Once the ClientHello is complete a session is created
+// and every buffered packet is flushed to the chosen backend.
+func (r *Relay) handleInitial(srcAddr *net.UDPAddr, dst netip.Addr, data []byte, header *quic.ParsedHeader) {
+	dcid := string(header.DCID)
+
+	for {
+		val, _ := r.pending.LoadOrStore(dcid, &pendingHandshake{
+			assembler: quic.NewClientHelloAssembler(header.DCID),
+			created:   time.Now(),
+		})
+		p := val.(*pendingHandshake)
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			// The handshake completed while we waited for the lock.
+			if sess, ok := r.sessions.Load(dcid); ok {
+				r.forward(sess.(*session), data)
+				return
+			}
+			// It was discarded instead, start over with a fresh buffer.
+			continue
+		}
+		if p.resolving {
+			// Hold the packet until the session exists, behind the others.
+			if err := r.bufferInitial(p, data); err != nil {
+				metrics.RelayPacketDrops.WithLabelValues(metrics.DropHandshakeBuffer).Inc()
+			}
+			p.mu.Unlock()
+			return
+		}
+
+		sni, packets, ok := r.addInitial(srcAddr, data, header, p)
+		p.mu.Unlock()
+		if ok {
+			r.resolvePending(srcAddr, dst, header, sni, packets, p)
+		}
+		return
+	}
+}
+
+// addInitial processes a packet for a pending handshake. Once the ClientHello
+// is complete it hands over the SNI and the packets to flush, and marks the
+// handshake as resolving. The caller must hold p.mu.
+func (r *Relay) addInitial(srcAddr *net.UDPAddr, data []byte, header *quic.ParsedHeader, p *pendingHandshake) (string, [][]byte, bool) {
+	dcid := string(header.DCID)
+	srcStr := srcAddr.String()
+
+	sni, err := p.assembler.AddPacket(data)
+	if errors.Is(err, quic.ErrClientHelloIncomplete) {
+		if err := r.bufferInitial(p, data); err != nil {
+			metrics.RelayPacketDrops.WithLabelValues(metrics.DropHandshakeBuffer).Add(float64(len(p.packets) + 1))
+			r.discardPending(dcid, p, err.Error())
+			return "", nil, false
+		}
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> pending (ClientHello incomplete, buffered: %d, DCID: %x)", srcStr, len(p.packets), header.DCID)
+		}
+		return "", nil, false
+	}
+	if err != nil {
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> unknown (failed to extract SNI: %v, DCID: %x)", srcStr, err, header.DCID)
+		}
+		metrics.RelayPacketDrops.WithLabelValues(dropReason(err)).Inc()
+		// Keep waiting if earlier packets were fine, this one may just be junk.
+		if len(p.packets) == 0 {
+			r.discardPending(dcid, p, "")
+		}
+		return "", nil, false
+	}
+
+	// The handshake leaves the pending map, so the reaper does not wait on
+	// a slow backend resolution. Its buffered bytes stay counted until then.
+	packets := append(p.packets, data)
+	p.packets = nil
+	p.resolving = true
+	r.pending.CompareAndDelete(dcid, p)
+	return sni, packets, true
+}
+
+// resolvePending creates the session of a resolving handshake without holding
+// p.mu, then flushes the packets that arrived in the meantime.
+func (r *Relay) resolvePending(srcAddr *net.UDPAddr, dst netip.Addr, header *quic.ParsedHeader, sni string, packets [][]byte, p *pendingHandshake) {
+	r.createSession(srcAddr, dst, header, sni, packets)
+
+	dcid := string(header.DCID)
+	var sess *session
+	if val, ok := r.sessions.Load(dcid); ok {
+		sess = val.(*session)
+	}
+
+	p.mu.Lock()
+	r.flushPending(dcid, p, sess)
+	p.mu.Unlock()
+
+	// Packets handled by another worker may have started a new handshake.
+	if val, ok := r.pending.Load(dcid); ok && sess != nil {
+		next := val.(*pendingHandshake)
+		next.mu.Lock()
+		if !next.resolving {
+			r.flushPending(dcid, next, sess)
+		}
+		next.mu.Unlock()
+	}
+}
+
+// flushPending forwards the buffered packets of a handshake to its session,
+// or drops them if none was created, and discards the handshake. The caller
+// must hold p.mu.
+func (r *Relay) flushPending(dcid string, p *pendingHandshake, sess *session) {
+	if sess != nil {
+		for _, data := range p.packets {
+			r.forward(sess, data)
+		}
+	} else {
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropNoSession).Add(float64(len(p.packets)))
+	}
+	p.resolving = false
+	r.discardPending(dcid, p, "")
+}
+
+// bufferInitial stores a copy of the packet, enforcing the per-connection and
+// global memory caps.
+func (r *Relay) bufferInitial(p *pendingHandshake, data []byte) error {
+	if p.size+len(data) > r.cfg.UDP.HandshakeBufferSize {
+		return errors.New("handshake buffer limit exceeded")
+	}
+	if r.pendingBytes.Add(int64(len(data))) > r.cfg.UDP.MaxPendingBytes {
+		r.pendingBytes.Add(-int64(len(data)))
+		return errors.New("global handshake buffer limit exceeded")
+	}
+
+	p.packets = append(p.packets, bytes.Clone(data))
+	p.size += len(data)
+	return nil
+}
+
+// discardPending removes the pending handshake and releases its buffered
+// packets. The caller must hold p.mu.
+func (r *Relay) discardPending(dcid string, p *pendingHandshake, reason string) {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	r.pending.CompareAndDelete(dcid, p)
+	r.pendingBytes.Add(-int64(p.size))
+
+	if reason != "" {
+		log.Printf("Dropped pending handshake (DCID: %x, packets: %d, reason: %s)", dcid, len(p.packets), reason)
+	}
+	p.packets = nil
+	p.size = 0
+}
+
+// expirePendingHandshakes discards handshakes whose ClientHello did not
+// complete within the handshake timeout.
+func (r *Relay) expirePendingHandshakes(now time.Time) {
+	r.pending.Range(func(key, val any) bool {
+		p := val.(*pendingHandshake)
+		p.mu.Lock()
+		if now.Sub(p.created) > r.cfg.UDP.HandshakeTimeout {
+			metrics.RelayPacketDrops.WithLabelValues(metrics.DropHandshakeTimeout).Add(float64(len(p.packets)))
+			r.discardPending(key.(string), p, "handshake timeout")
+		}
+		p.mu.Unlock()
+		return true
+	})
+}
@@ -0,0 +1,274 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"golang.org/x/crypto/hkdf"
+)
+
+// testClientHello is the ClientHello for example.com from RFC 9001 Appendix
+// A.2, without its CRYPTO frame header.
+const testClientHello = "010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e868" +
+	"04fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578" +
+	"616d706c652e636f6dff01000100000a00080006001d00170018001000070005" +
+	"04616c706e000500050100000000003300260024001d00209370b2c9caa47fba" +
+	"baf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b000302030400" +
+	"0d0010000e0403050306030203080408050806002d00020101001c0002400100" +
+	"3900320408ffffffffffffffff05048000ffff07048000ffff08011001048000" +
+	"75300901100f088394c8f03e51570806048000ffff"
+
+// hkdfExpandLabel is HKDF-Expand-Label from RFC 8446 with an empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(sha256.New, secret, info), out)
+	return out
+}
+
+// sealInitial builds a protected QUIC v1 client Initial carrying the part of
+// testClientHello from offset to end, padded to 1200 bytes.
+func sealInitial(t *testing.T, dcid []byte, pn uint32, offset, end int) []byte {
+	t.Helper()
+	hello, _ := hex.DecodeString(testClientHello)
+	data := hello[offset:min(end, len(hello))]
+	frames := []byte{0x06, 0x40 | byte(offset>>8), byte(offset), 0x40 | byte(len(data)>>8), byte(len(data))}
+	frames = append(frames, data...)
+
+	salt, _ := hex.DecodeString("38762cf7f55934b34d179ae6a4c80cadccbb7f0a")
+	secret := hkdfExpandLabel(hkdf.Extract(sha256.New, dcid, salt), "client in", 32)
+	key := hkdfExpandLabel(secret, "quic key", 16)
+	iv := hkdfExpandLabel(secret, "quic iv", 12)
+	hp := hkdfExpandLabel(secret, "quic hp", 16)
+
+	header := []byte{0xc3, 0, 0, 0, 1, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // SCID length, token length
+	payload := make([]byte, 1200-len(header)-2-4-16)
+	copy(payload, frames)
+	header = binary.BigEndian.AppendUint16(header, 0x4000|uint16(4+len(payload)+16))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint32(header, pn)
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := bytes.Clone(iv)
+	for i := range 4 {
+		nonce[8+i] ^= byte(pn >> (24 - 8*i))
+	}
+	packet := aead.Seal(header, nonce, payload, header)
+
+	hpBlock, _ := aes.NewCipher(hp)
+	mask := make([]byte, 16)
+	hpBlock.Encrypt(mask, packet[pnOffset+4:pnOffset+20])
+	packet[0] ^= mask[0] & 0x0f
+	for i := range 4 {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+func mustParse(t *testing.T, packet []byte) *quic.ParsedHeader {
+	t.Helper()
+	header, err := quic.ParsePacket(packet)
+	if err != nil {
+		t.Fatalf("ParsePacket() error = %v", err)
+	}
+	return header
+}
+
+// sendInitial hands a client Initial to the relay as a worker would.
+func sendInitial(t *testing.T, r *Relay, src *net.UDPAddr, packet []byte) {
+	t.Helper()
+	r.handlePacket(src, netip.Addr{}, packet, mustParse(t, packet))
+}
+
+// readBackend reads the next datagram the backend receives.
+func readBackend(t *testing.T, backend *net.UDPConn) []byte {
+	t.Helper()
+	buf := make([]byte, 2048)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := backend.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Backend read error = %v", err)
+	}
+	return buf[:n]
+}
+
+func testHandshakeConfig() *config.Config {
+	cfg := testServeConfig(false)
+	cfg.UDP.HandshakeBufferSize = 16 * 1024
+	cfg.UDP.MaxPendingBytes = 1 << 20
+	cfg.UDP.HandshakeTimeout = 5 * time.Second
+	return cfg
+}
+
+func TestPendingHandshakeLimits(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.UDP.HandshakeBufferSize = 2000
+	cfg.UDP.MaxPendingBytes = 3000
+	cfg.UDP.HandshakeTimeout = time.Second
+	r := &Relay{cfg: cfg}
+
+	a := &pendingHandshake{created: time.Now()}
+	r.pending.Store("a", a)
+	if err := r.bufferInitial(a, make([]byte, 1200)); err != nil {
+		t.Fatalf("Expected first packet to be buffered: %v", err)
+	}
+	if err := r.bufferInitial(a, make([]byte, 1200)); err == nil {
+		t.Error("Expected per-connection limit to be enforced")
+	}
+
+	b := &pendingHandshake{created: time.Now().Add(-time.Minute)}
+	r.pending.Store("b", b)
+	if err := r.bufferInitial(b, make([]byte, 1200)); err != nil {
+		t.Fatalf("Expected packet to be buffered: %v", err)
+	}
+	if err := r.bufferInitial(b, make([]byte, 700)); err == nil {
+		t.Error("Expected global limit to be enforced")
+	}
+	if got := r.pendingBytes.Load(); got != 2400 {
+		t.Errorf("Expected 2400 pending bytes, got %d", got)
+	}
+
+	r.expirePendingHandshakes(time.Now())
+
+	if _, ok := r.pending.Load("b"); ok {
+		t.Error("Expected expired handshake to be discarded")
+	}
+	if _, ok := r.pending.Load("a"); !ok {
+		t.Error("Expected fresh handshake to be kept")
+	}
+	if got := r.pendingBytes.Load(); got != 1200 {
+		t.Errorf("Expected 1200 pending bytes after expiry, got %d", got)
+	}
+}
+
+func TestSplitClientHello(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, _ := startServing(t, testHandshakeConfig(), client, backend)
+	simple := strategy.NewSimpleStrategy()
+	simple.UpdateRoute("example.com", backend.LocalAddr().String())
+	r.manager = strategy.NewStrategyManager()
+	r.manager.Register(strategy.StrategySimple, simple)
+	src := client.LocalAddr().(*net.UDPAddr)
+
+	tests := []struct {
+		name    string
+		dcid    []byte
+		packets func(dcid []byte) [][]byte
+	}{
+		{"in order", []byte{1, 1, 1, 1, 1, 1, 1, 1}, func(dcid []byte) [][]byte {
+			return [][]byte{sealInitial(t, dcid, 0, 0, 120), sealInitial(t, dcid, 1, 120, 1<<10)}
+		}},
+		{"out of order", []byte{2, 2, 2, 2, 2, 2, 2, 2}, func(dcid []byte) [][]byte {
+			return [][]byte{
+				sealInitial(t, dcid, 2, 160, 1<<10),
+				sealInitial(t, dcid, 1, 80, 160),
+				sealInitial(t, dcid, 0, 0, 80),
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets := tt.packets(tt.dcid)
+			for _, packet := range packets {
+				sendInitial(t, r, src, packet)
+			}
+			for i, want := range packets {
+				if got := readBackend(t, backend); !bytes.Equal(got, want) {
+					t.Fatalf("Backend packet %d = %x..., want %x...", i, got[:24], want[:24])
+				}
+			}
+			if _, ok := r.sessions.Load(string(tt.dcid)); !ok {
+				t.Error("Expected a session once the ClientHello is complete")
+			}
+			if _, ok := r.pending.Load(string(tt.dcid)); ok {
+				t.Error("Expected the pending handshake to be gone")
+			}
+			if got := r.pendingBytes.Load(); got != 0 {
+				t.Errorf("Pending bytes = %d, want 0", got)
+			}
+		})
+	}
+}
+
+// blockingStrategy resolves every SNI to target once released.
+type blockingStrategy struct {
+	target  string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStrategy) Resolve(ctx context.Context, fqdn string) (string, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.target, nil
+}
+
+func TestSlowResolveDoesNotBlockReaper(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	r, _ := startServing(t, testHandshakeConfig(), client, backend)
+	slow := &blockingStrategy{
+		target:  backend.LocalAddr().String(),
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	r.manager = strategy.NewStrategyManager()
+	r.manager.Register(strategy.StrategySimple, slow)
+	src := client.LocalAddr().(*net.UDPAddr)
+
+	dcid := []byte{3, 3, 3, 3, 3, 3, 3, 3}
+	first := sealInitial(t, dcid, 0, 0, 120)
+	second := sealInitial(t, dcid, 1, 120, 1<<10)
+	sendInitial(t, r, src, first)
+	done := make(chan struct{})
+	go func() {
+		sendInitial(t, r, src, second)
+		close(done)
+	}()
+	<-slow.entered
+
+	reaped := make(chan struct{})
+	go func() {
+		r.expirePendingHandshakes(time.Now().Add(time.Hour))
+		close(reaped)
+	}()
+	select {
+	case <-reaped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reaper blocked on a pending handshake being resolved")
+	}
+
+	// A retransmission arriving on another worker follows the others.
+	third := sealInitial(t, dcid, 2, 0, 120)
+	r.handleInitial(src, netip.Addr{}, third, mustParse(t, third))
+	close(slow.release)
+	<-done
+
+	for i, want := range [][]byte{first, second, third} {
+		if got := readBackend(t, backend); !bytes.Equal(got, want) {
+			t.Fatalf("Backend packet %d = %x..., want %x...", i, got[:24], want[:24])
+		}
+	}
+	if got := r.pendingBytes.Load(); got != 0 {
+		t.Errorf("Pending bytes = %d, want 0", got)
+	}
+}
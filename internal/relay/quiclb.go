@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+)
+
+// StrategyQUICLB labels sessions recreated from a QUIC-LB connection ID.
+const StrategyQUICLB = "quic_lb"
+
+// loadBalancer routes packets without session state by decoding the server
+// ID that QUIC-LB aware backends embed in the connection IDs they issue.
+type loadBalancer struct {
+	configs [quic.LBUnroutableConfigID]*lbConfig
+}
+
+type lbConfig struct {
+	*quic.LBConfig
+	servers map[string]string // Raw server ID -> target
+}
+
+func newLoadBalancer(cfgs []config.QUICLBConfig) (*loadBalancer, error) {
+	lb := &loadBalancer{}
+	for _, c := range cfgs {
+		key, err := hex.DecodeString(c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("QUIC-LB config %d: invalid key: %w", c.ID, err)
+		}
+		lbCfg, err := quic.NewLBConfig(c.ID, c.ServerIDLength, c.NonceLength, key)
+		if err != nil {
+			return nil, fmt.Errorf("QUIC-LB config %d: %w", c.ID, err)
+		}
+		if lb.configs[c.ID] != nil {
+			return nil, fmt.Errorf("QUIC-LB config %d is defined twice", c.ID)
+		}
+
+		servers := make(map[string]string, len(c.Servers))
+		for id, target := range c.Servers {
+			serverID, err := hex.DecodeString(id)
+			if err != nil || len(serverID) != c.ServerIDLength {
+				return nil, fmt.Errorf("QUIC-LB config %d: server ID %q must be %d hex-encoded bytes", c.ID, id, c.ServerIDLength)
+			}
+			servers[string(serverID)] = target
+		}
+		lb.configs[c.ID] = &lbConfig{LBConfig: lbCfg, servers: servers}
+	}
+	return lb, nil
+}
+
+// route returns the target whose server ID is encoded in the packet's DCID,
+// along with the DCID and its config.
+func (lb *loadBalancer) route(data []byte, header *quic.ParsedHeader) (string, []byte, *lbConfig, error) {
+	var configID uint8
+	if header.IsLongHeader {
+		configID = quic.LBConfigID(header.DCID)
+	} else if len(data) > 1 {
+		configID = quic.LBConfigID(data[1:])
+	}
+	if configID == quic.LBUnroutableConfigID || lb.configs[configID] == nil {
+		return "", nil, nil, quic.ErrUnroutableConnID
+	}
+	cfg := lb.configs[configID]
+
+	dcid := header.DCID
+	if !header.IsLongHeader {
+		var err error
+		if dcid, err = quic.ShortHeaderDCID(data, cfg.ConnIDLength()); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	serverID, err := cfg.DecodeServerID(dcid)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	target, ok := cfg.servers[string(serverID)]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown QUIC-LB server ID %x", serverID)
+	}
+	return target, dcid, cfg, nil
+}
+
+// resumeSession recreates the session for a packet whose DCID encodes a known
+// server ID, for example after Porter restarted or the client moved to
+// another replica. It reports whether the packet was forwarded.
+func (r *Relay) resumeSession(srcAddr *net.UDPAddr, dst netip.Addr, data []byte, header *quic.ParsedHeader) bool {
+	target, dcid, cfg, err := r.lb.route(data, header)
+	if err != nil {
+		return false
+	}
+
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		log.Printf("Invalid QUIC-LB target address %s: %v", target, err)
+		return false
+	}
+	if !r.admit(srcAddr, dcid) {
+		return true
+	}
+	// The DCID carries no SNI, so the resumed session gets the global
+	// options rather than those of its route.
+	backendConn, err := r.dialBackend(srcAddr, targetAddr, "")
+	if err != nil {
+		log.Printf("Error dialing backend %s: %v", target, err)
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropBackendUnavailable).Inc()
+		return true
+	}
+
+	if r.cfg.UDP.LogRequests {
+		log.Printf("Relay: %s -> %s (resumed session, QUIC-LB config: %d, DCID: %x)", srcAddr, target, cfg.ID, dcid)
+	} else {
+		log.Printf("Resumed session: %s -> %s (QUIC-LB config: %d, DCID: %x)", srcAddr, target, cfg.ID, dcid)
+	}
+
+	sess := &session{
+		target:      target,
+		targetAddr:  targetAddr,
+		lastSeen:    time.Now(),
+		srcAddr:     srcAddr,
+		dstAddr:     dst,
+		backendConn: backendConn,
+		idleTimeout: r.idleTimeout(""),
+		cidLength:   cfg.ConnIDLength(),
+		mtu:         r.mtu(""),
+		proxyMode:   r.proxyMode(""),
+	}
+	r.startSession(sess, string(dcid), StrategyQUICLB, [][]byte{data})
+	return true
+}
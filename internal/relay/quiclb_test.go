@@ -0,0 +1,90 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+)
+
+func TestResumeSessionFromQUICLB(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer backend.Close()
+
+	key := "00112233445566778899aabbccddeeff"
+	cfg := &config.Config{}
+	cfg.QUICLB.Configs = []config.QUICLBConfig{{
+		ID:             1,
+		ServerIDLength: 2,
+		NonceLength:    5,
+		Key:            key,
+		Servers:        map[string]string{"0a01": backend.LocalAddr().String()},
+	}}
+	lb, err := newLoadBalancer(cfg.QUICLB.Configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{cfg: cfg, lb: lb, routeOptions: strategy.NewPatternTable[routeOptions]()}
+
+	rawKey, _ := hex.DecodeString(key)
+	lbCfg, _ := quic.NewLBConfig(1, 2, 5, rawKey)
+	cid, _ := lbCfg.EncodeConnID([]byte{0x0a, 0x01}, []byte{1, 2, 3, 4, 5})
+	packet := shortHeaderPacket(cid)
+
+	header, err := quic.ParsePacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.handlePacket(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}, netip.Addr{}, packet, header)
+
+	backend.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := backend.ReadFromUDP(buf)
+	if err != nil || !bytes.Equal(buf[:n], packet) {
+		t.Fatalf("Backend received %x, %v, want %x", buf[:n], err, packet)
+	}
+
+	val, ok := r.sessions.Load(string(cid))
+	if !ok {
+		t.Fatal("Expected the resumed session to be registered under its DCID")
+	}
+	defer r.closeSession(val.(*session), "test")
+	if sess, _, err := r.lookupShortHeader(packet); err != nil || sess != val.(*session) {
+		t.Errorf("lookupShortHeader() = %p, %v, want the resumed session", sess, err)
+	}
+
+	// Unknown server IDs are not routed.
+	unknown, _ := lbCfg.EncodeConnID([]byte{0x0b, 0x02}, []byte{1, 2, 3, 4, 5})
+	packet = shortHeaderPacket(unknown)
+	header, _ = quic.ParsePacket(packet)
+	if r.resumeSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50001}, netip.Addr{}, packet, header) {
+		t.Error("Expected an unknown server ID not to be routed")
+	}
+}
+
+func TestNewLoadBalancerValidation(t *testing.T) {
+	invalid := []config.QUICLBConfig{
+		{ID: 0, ServerIDLength: 2, NonceLength: 5, Key: "zz"},
+		{ID: 0, ServerIDLength: 2, NonceLength: 5, Servers: map[string]string{"0a": "127.0.0.1:1"}},
+		{ID: 7, ServerIDLength: 2, NonceLength: 5},
+	}
+	for _, c := range invalid {
+		if _, err := newLoadBalancer([]config.QUICLBConfig{c}); err == nil {
+			t.Errorf("newLoadBalancer(%+v) succeeded, want error", c)
+		}
+	}
+
+	dup := config.QUICLBConfig{ID: 3, ServerIDLength: 2, NonceLength: 5}
+	if _, err := newLoadBalancer([]config.QUICLBConfig{dup, dup}); err == nil {
+		t.Error("Expected duplicate config IDs to fail")
+	}
+}
@@ -0,0 +1,243 @@
+package relay
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+)
+
+// tokenBucket allows rate events per second on average, in bursts of up to
+// burst events.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if !now.After(b.last) {
+		return
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// bucketTable keeps a token bucket per source IP or prefix. Buckets that have
+// refilled are dropped by cleanup, and at most max are tracked (0 for no
+// limit); sources beyond that are not limited by the table, leaving them to
+// the global limit.
+type bucketTable struct {
+	rate, burst float64
+	max         int
+
+	mu      sync.Mutex
+	buckets map[netip.Prefix]*tokenBucket
+}
+
+func newBucketTable(limit config.RateLimit, maxTracked int) *bucketTable {
+	if limit.Rate == 0 {
+		return nil
+	}
+	return &bucketTable{
+		rate:    limit.Rate,
+		burst:   burstOf(limit),
+		max:     maxTracked,
+		buckets: make(map[netip.Prefix]*tokenBucket),
+	}
+}
+
+func burstOf(limit config.RateLimit) float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	return max(math.Ceil(limit.Rate), 1)
+}
+
+// allow takes a token from the key's bucket, reporting false if it is empty.
+func (t *bucketTable) allow(key netip.Prefix, now time.Time) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.buckets[key]
+	if !ok {
+		if t.max > 0 && len(t.buckets) >= t.max {
+			return true
+		}
+		b = &tokenBucket{tokens: t.burst, last: now}
+		t.buckets[key] = b
+	}
+	b.refill(now, t.rate, t.burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup forgets buckets that have refilled, since a fresh bucket behaves
+// the same.
+func (t *bucketTable) cleanup(now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, b := range t.buckets {
+		b.refill(now, t.rate, t.burst)
+		if b.tokens >= t.burst {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// rateLimiter decides whether an Initial packet may start a new connection.
+// It runs before the Initial is decrypted, so floods cost little more than
+// the read.
+type rateLimiter struct {
+	perIP      *bucketTable
+	perPrefix  *bucketTable
+	ipv4Prefix int
+	ipv6Prefix int
+
+	globalRate, globalBurst float64
+	globalMu                sync.Mutex
+	global                  *tokenBucket // nil if unlimited
+
+	maxSessions    int // Per client IP, 0 if unlimited
+	sessionsMu     sync.Mutex
+	clientSessions map[netip.Addr]int
+}
+
+// newRateLimiter builds the limits configured under udp.rate_limit. It
+// returns nil if none are enabled.
+func newRateLimiter(cfg *config.Config) (*rateLimiter, error) {
+	c := cfg.UDP.RateLimit
+	for name, limit := range map[string]config.RateLimit{
+		"per_ip":     c.PerIP,
+		"per_prefix": c.PerPrefix.RateLimit,
+		"global":     c.Global,
+	} {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return nil, fmt.Errorf("udp.rate_limit.%s must not be negative", name)
+		}
+	}
+	if c.PerPrefix.IPv4Prefix < 0 || c.PerPrefix.IPv4Prefix > 32 {
+		return nil, fmt.Errorf("invalid udp.rate_limit.per_prefix.ipv4_prefix %d", c.PerPrefix.IPv4Prefix)
+	}
+	if c.PerPrefix.IPv6Prefix < 0 || c.PerPrefix.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("invalid udp.rate_limit.per_prefix.ipv6_prefix %d", c.PerPrefix.IPv6Prefix)
+	}
+	if c.MaxSessionsPerIP < 0 {
+		return nil, fmt.Errorf("udp.rate_limit.max_sessions_per_ip must not be negative")
+	}
+
+	if c.PerIP.Rate == 0 && c.PerPrefix.Rate == 0 && c.Global.Rate == 0 && c.MaxSessionsPerIP == 0 {
+		return nil, nil
+	}
+
+	l := &rateLimiter{
+		perIP:          newBucketTable(c.PerIP, c.MaxTrackedSources),
+		perPrefix:      newBucketTable(c.PerPrefix.RateLimit, c.MaxTrackedSources),
+		ipv4Prefix:     c.PerPrefix.IPv4Prefix,
+		ipv6Prefix:     c.PerPrefix.IPv6Prefix,
+		maxSessions:    c.MaxSessionsPerIP,
+		clientSessions: make(map[netip.Addr]int),
+	}
+	if c.Global.Rate > 0 {
+		l.globalRate, l.globalBurst = c.Global.Rate, burstOf(c.Global)
+		l.global = &tokenBucket{tokens: l.globalBurst, last: time.Now()}
+	}
+	return l, nil
+}
+
+func addrIP(addr *net.UDPAddr) netip.Addr {
+	ip, _ := netip.AddrFromSlice(addr.IP)
+	return ip.Unmap()
+}
+
+// admit checks a new connection from the address against every limit, from
+// the narrowest to the widest, so a single flooding source is stopped by its
+// own limits before it drains the shared ones. It returns the limit that was
+// exceeded, if any.
+func (l *rateLimiter) admit(addr *net.UDPAddr, now time.Time) (string, bool) {
+	if l == nil {
+		return "", true
+	}
+	ip := addrIP(addr)
+
+	if l.maxSessions > 0 {
+		l.sessionsMu.Lock()
+		active := l.clientSessions[ip]
+		l.sessionsMu.Unlock()
+		if active >= l.maxSessions {
+			return metrics.LimitSessions, false
+		}
+	}
+
+	if !l.perIP.allow(netip.PrefixFrom(ip, ip.BitLen()), now) {
+		return metrics.LimitIP, false
+	}
+
+	bits := l.ipv6Prefix
+	if ip.Is4() {
+		bits = l.ipv4Prefix
+	}
+	if prefix, err := ip.Prefix(bits); err == nil && !l.perPrefix.allow(prefix, now) {
+		return metrics.LimitPrefix, false
+	}
+
+	if l.global != nil {
+		l.globalMu.Lock()
+		l.global.refill(now, l.globalRate, l.globalBurst)
+		ok := l.global.tokens >= 1
+		if ok {
+			l.global.tokens--
+		}
+		l.globalMu.Unlock()
+		if !ok {
+			return metrics.LimitGlobal, false
+		}
+	}
+	return "", true
+}
+
+// sessionStarted and sessionClosed track the concurrent sessions of each
+// client IP for max_sessions_per_ip.
+func (l *rateLimiter) sessionStarted(ip netip.Addr) {
+	if l == nil || l.maxSessions == 0 {
+		return
+	}
+	l.sessionsMu.Lock()
+	l.clientSessions[ip]++
+	l.sessionsMu.Unlock()
+}
+
+func (l *rateLimiter) sessionClosed(ip netip.Addr) {
+	if l == nil || l.maxSessions == 0 {
+		return
+	}
+	l.sessionsMu.Lock()
+	if l.clientSessions[ip] <= 1 {
+		delete(l.clientSessions, ip)
+	} else {
+		l.clientSessions[ip]--
+	}
+	l.sessionsMu.Unlock()
+}
+
+func (l *rateLimiter) cleanup(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.perIP.cleanup(now)
+	l.perPrefix.cleanup(now)
+}
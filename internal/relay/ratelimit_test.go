@@ -0,0 +1,247 @@
+package relay
+
+import (
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testInitial returns an Initial packet header for a new connection. Its
+// payload is not decryptable, which tests stopping before decryption rely on.
+func testInitial(t *testing.T, id byte) ([]byte, *quic.ParsedHeader) {
+	t.Helper()
+	return testInitialWithToken(t, []byte{id, 2, 3, 4, 5, 6, 7, 8}, nil)
+}
+
+func testInitialWithToken(t *testing.T, dcid, token []byte) ([]byte, *quic.ParsedHeader) {
+	t.Helper()
+	initial := []byte{0xc0, 0, 0, 0, 1, byte(len(dcid))}
+	initial = append(initial, dcid...)
+	initial = append(initial, 4, 0xa, 0xb, 0xc, 0xd) // SCID
+	initial = quicvarint(initial, len(token))
+	initial = append(initial, token...)
+	initial = append(initial, 0x40, 0x14)
+	initial = append(initial, make([]byte, 20)...)
+	header, err := quic.ParsePacket(initial)
+	if err != nil {
+		t.Fatalf("ParsePacket() error = %v", err)
+	}
+	return initial, header
+}
+
+// quicvarint appends a variable-length integer below 16384.
+func quicvarint(b []byte, v int) []byte {
+	if v < 64 {
+		return append(b, byte(v))
+	}
+	return append(b, 0x40|byte(v>>8), byte(v))
+}
+
+func TestRateLimiter(t *testing.T) {
+	addr := func(ip string) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: 4433}
+	}
+	now := time.Now()
+
+	cfg := &config.Config{}
+	cfg.UDP.RateLimit.PerIP = config.RateLimit{Rate: 1, Burst: 2}
+	cfg.UDP.RateLimit.PerPrefix.RateLimit = config.RateLimit{Rate: 1, Burst: 3}
+	cfg.UDP.RateLimit.PerPrefix.IPv4Prefix = 24
+	cfg.UDP.RateLimit.PerPrefix.IPv6Prefix = 48
+	cfg.UDP.RateLimit.Global = config.RateLimit{Rate: 1, Burst: 5}
+	cfg.UDP.RateLimit.MaxSessionsPerIP = 1
+	l, err := newRateLimiter(cfg)
+	if err != nil {
+		t.Fatalf("newRateLimiter() error = %v", err)
+	}
+
+	steps := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1", ""},
+		{"::ffff:192.0.2.1", ""}, // Same IP, mapped
+		{"192.0.2.1", metrics.LimitIP},
+		{"192.0.2.2", ""},
+		{"192.0.2.3", metrics.LimitPrefix},
+		{"2001:db8:1::1", ""},
+		{"2001:db8:2::1", ""},
+		{"2001:db8:3::1", metrics.LimitGlobal},
+	}
+	for i, step := range steps {
+		limit, ok := l.admit(addr(step.addr), now)
+		if limit != step.want || ok != (step.want == "") {
+			t.Errorf("Step %d: admit(%s) = %q, %v, want %q", i, step.addr, limit, ok, step.want)
+		}
+	}
+
+	// Buckets refill over time and are forgotten once full.
+	later := now.Add(10 * time.Second)
+	l.cleanup(later)
+	if n := len(l.perIP.buckets); n != 0 {
+		t.Errorf("Expected refilled buckets to be cleaned up, %d left", n)
+	}
+	if _, ok := l.admit(addr("192.0.2.1"), later); !ok {
+		t.Error("Expected the source to be admitted after refilling")
+	}
+
+	client := addrIP(addr("198.51.100.1"))
+	l.sessionStarted(client)
+	if limit, _ := l.admit(addr("198.51.100.1"), later); limit != metrics.LimitSessions {
+		t.Errorf("Expected the session limit, got %q", limit)
+	}
+	l.sessionClosed(client)
+	if _, ok := l.admit(addr("198.51.100.1"), later); !ok {
+		t.Error("Expected the client to be admitted after its session closed")
+	}
+	if len(l.clientSessions) != 0 {
+		t.Errorf("Expected no tracked clients, got %v", l.clientSessions)
+	}
+}
+
+func TestRateLimiterTrackingCap(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.UDP.RateLimit.PerIP = config.RateLimit{Rate: 1, Burst: 1}
+	cfg.UDP.RateLimit.MaxTrackedSources = 1
+	l, err := newRateLimiter(cfg)
+	if err != nil {
+		t.Fatalf("newRateLimiter() error = %v", err)
+	}
+
+	now := time.Now()
+	l.admit(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}, now)
+	for range 3 {
+		if _, ok := l.admit(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}, now); !ok {
+			t.Fatal("Expected untracked sources to pass the per-IP limit")
+		}
+	}
+}
+
+func TestNewRateLimiterValidation(t *testing.T) {
+	cfg := &config.Config{}
+	if l, err := newRateLimiter(cfg); l != nil || err != nil {
+		t.Errorf("newRateLimiter() = %v, %v, want nil without limits", l, err)
+	}
+
+	cfg.UDP.RateLimit.Global.Rate = -1
+	if _, err := newRateLimiter(cfg); err == nil {
+		t.Error("Expected a negative rate to be rejected")
+	}
+
+	cfg = &config.Config{}
+	cfg.UDP.RateLimit.PerPrefix.IPv4Prefix = 33
+	if _, err := newRateLimiter(cfg); err == nil {
+		t.Error("Expected an invalid prefix length to be rejected")
+	}
+}
+
+func TestInitialRateLimited(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.UDP.RateLimit.PerIP = config.RateLimit{Rate: 1, Burst: 1}
+	r := &Relay{cfg: cfg}
+	var err error
+	if r.limits, err = newRateLimiter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+
+	drops := func() float64 {
+		return testutil.ToFloat64(metrics.RelayRateLimited.WithLabelValues(metrics.LimitIP))
+	}
+	before := drops()
+
+	// The first connection is admitted and fails decryption.
+	first, header := testInitial(t, 1)
+	r.handlePacket(src, netip.Addr{}, first, header)
+	if got := drops() - before; got != 0 {
+		t.Fatalf("Counted %v rate limited drops for the first connection", got)
+	}
+
+	second, header := testInitial(t, 2)
+	decryptFailures := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropDecryptFailed))
+	r.handlePacket(src, netip.Addr{}, second, header)
+	if got := drops() - before; got != 1 {
+		t.Errorf("Counted %v rate limited drops, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropDecryptFailed)); got != decryptFailures {
+		t.Error("Expected the second Initial to be dropped before decryption")
+	}
+}
+
+func TestResumeAndAdoptRateLimited(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer backend.Close()
+	target := backend.LocalAddr().String()
+
+	key := "00112233445566778899aabbccddeeff"
+	cfg := &config.Config{}
+	cfg.UDP.RateLimit.MaxSessionsPerIP = 1
+	cfg.QUICLB.Configs = []config.QUICLBConfig{{
+		ID:             1,
+		ServerIDLength: 2,
+		NonceLength:    5,
+		Key:            key,
+		Servers:        map[string]string{"0a01": target},
+	}}
+	r := &Relay{cfg: cfg, routeOptions: strategy.NewPatternTable[routeOptions]()}
+	if r.lb, err = newLoadBalancer(cfg.QUICLB.Configs); err != nil {
+		t.Fatal(err)
+	}
+	if r.limits, err = newRateLimiter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	directory := &memoryDirectory{entries: make(map[string]SessionEntry)}
+	sharedCID := "\x01\x02\x03\x04\x05\x06\x07\x08"
+	directory.entries[sharedCID] = SessionEntry{Session: []byte(sharedCID), Target: target, CIDLength: 8}
+	r.SetSessionDirectory(directory)
+
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+	send := func(cid []byte) {
+		packet := shortHeaderPacket(cid)
+		header, err := quic.ParsePacket(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.handlePacket(src, netip.Addr{}, packet, header)
+	}
+	refused := func() float64 {
+		return testutil.ToFloat64(metrics.RelayRateLimited.WithLabelValues(metrics.LimitSessions))
+	}
+
+	rawKey, _ := hex.DecodeString(key)
+	lbCfg, _ := quic.NewLBConfig(1, 2, 5, rawKey)
+	first, _ := lbCfg.EncodeConnID([]byte{0x0a, 0x01}, []byte{1, 2, 3, 4, 5})
+	second, _ := lbCfg.EncodeConnID([]byte{0x0a, 0x01}, []byte{6, 7, 8, 9, 10})
+
+	before := refused()
+	send(first)
+	val, ok := r.sessions.Load(string(first))
+	if !ok {
+		t.Fatal("Expected the first connection to be resumed")
+	}
+	defer r.closeSession(val.(*session), "test")
+
+	// The client's one session is in use, so it may neither resume nor
+	// adopt another.
+	send(second)
+	send([]byte(sharedCID))
+	if got := refused() - before; got != 2 {
+		t.Errorf("Counted %v session limit refusals, want 2", got)
+	}
+	for _, cid := range []string{string(second), sharedCID} {
+		if _, ok := r.sessions.Load(cid); ok {
+			t.Errorf("Expected no session for %x", cid)
+		}
+	}
+}
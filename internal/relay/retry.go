@@ -0,0 +1,217 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/pkg/retryoffload"
+)
+
+// Retry tokens issued by Porter follow the no-shared-state format of
+// draft-ietf-quic-retry-offload, so backends can read the original DCID with
+// pkg/retryoffload. The opaque data is:
+//
+//	Issued At (8), Unix milliseconds
+//	MAC (16), HMAC-SHA256 truncated, over the token so far, the client IP
+//	    (16, IPv4 mapped to IPv6) and the Retry Source Connection ID
+//
+// The Retry Source Connection ID is the DCID of the Initial carrying the
+// token.
+const retryTokenMACSize = 16
+
+var (
+	errInvalidToken = errors.New("invalid retry token")
+	errExpiredToken = errors.New("expired retry token")
+)
+
+// retryService issues and validates stateless Retry tokens, and decides when
+// load calls for address validation.
+type retryService struct {
+	key      []byte
+	lifetime time.Duration
+
+	loadRate float64 // New connections per second before retrying, 0 if never
+	mu       sync.Mutex
+	load     tokenBucket
+}
+
+// newRetryService returns nil unless a route declares its backends
+// Retry-aware. Porter only retries connections to such routes, whose backends
+// trust its tokens, so it must also be the one validating them.
+func newRetryService(cfg *config.Config) (*retryService, error) {
+	c := cfg.UDP.Retry
+	if c.Threshold < 0 {
+		return nil, errors.New("udp.retry.threshold must not be negative")
+	}
+
+	enabled := false
+	for _, route := range cfg.Routes {
+		enabled = enabled || route.RetryAware
+	}
+	if !enabled {
+		if c.Threshold > 0 {
+			return nil, errors.New("udp.retry.threshold requires a route with retry_aware backends")
+		}
+		return nil, nil
+	}
+
+	s := &retryService{lifetime: c.TokenLifetime, loadRate: c.Threshold}
+	if s.lifetime <= 0 {
+		s.lifetime = 10 * time.Second
+	}
+	if s.loadRate > 0 {
+		s.load = tokenBucket{tokens: s.loadRate, last: time.Now()}
+	}
+
+	if c.Key == "" {
+		s.key = make([]byte, 32)
+		rand.Read(s.key)
+		log.Printf("Retry tokens use a random key and are only valid on this instance; set udp.retry.key to share one")
+		return s, nil
+	}
+	key, err := hex.DecodeString(c.Key)
+	if err != nil || len(key) < 16 {
+		return nil, fmt.Errorf("udp.retry.key must be at least 16 hex-encoded bytes")
+	}
+	s.key = key
+	return s, nil
+}
+
+func (s *retryService) mac(body []byte, client netip.Addr, rscid []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(body)
+	ip := client.As16()
+	h.Write(ip[:])
+	h.Write(rscid)
+	return h.Sum(nil)[:retryTokenMACSize]
+}
+
+func (s *retryService) newToken(client netip.Addr, odcid, rscid []byte, now time.Time) ([]byte, error) {
+	token := make([]byte, 0, 1+len(odcid)+8+retryTokenMACSize)
+	token, err := retryoffload.AppendToken(token, odcid, nil)
+	if err != nil {
+		return nil, err
+	}
+	token = binary.BigEndian.AppendUint64(token, uint64(now.UnixMilli()))
+	return append(token, s.mac(token, client, rscid)...), nil
+}
+
+// checkToken validates a Retry token received from the client in an Initial
+// whose DCID is rscid, returning the original DCID it carries.
+func (s *retryService) checkToken(client netip.Addr, token, rscid []byte, now time.Time) ([]byte, error) {
+	odcid, opaque, err := retryoffload.ParseToken(token)
+	if err != nil || len(opaque) != 8+retryTokenMACSize {
+		return nil, errInvalidToken
+	}
+
+	body := token[:len(token)-retryTokenMACSize]
+	if !hmac.Equal(s.mac(body, client, rscid), token[len(body):]) {
+		return nil, errInvalidToken
+	}
+
+	issued := time.UnixMilli(int64(binary.BigEndian.Uint64(opaque)))
+	// Allow some clock skew between replicas sharing the key.
+	if now.Sub(issued) > s.lifetime || issued.Sub(now) > time.Second {
+		return nil, errExpiredToken
+	}
+	return odcid, nil
+}
+
+// overloaded takes a slot from the new connection budget, reporting true once
+// it is used up and new connections should be retried.
+func (s *retryService) overloaded(now time.Time) bool {
+	if s.loadRate == 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load.refill(now, s.loadRate, s.loadRate)
+	if s.load.tokens < 1 {
+		return true
+	}
+	s.load.tokens--
+	return false
+}
+
+// checkRetry decides whether a new connection to a Retry-aware route may go
+// on to its backend. A client with a valid Retry token may; one with an
+// invalid or expired token is dropped, as the backend would trust the token.
+// Other clients are sent a Retry if the route always retries or the relay is
+// under load. Connections to other routes are never retried.
+func (r *Relay) checkRetry(srcAddr *net.UDPAddr, header *quic.ParsedHeader, sni string, packets int) bool {
+	if r.retry == nil || !r.retryAware(sni) {
+		return true
+	}
+	now := time.Now()
+
+	// Tokens from a backend's NEW_TOKEN frames are left to the backend.
+	if retryoffload.IsRetryToken(header.Token) {
+		_, err := r.retry.checkToken(addrIP(srcAddr), header.Token, header.DCID, now)
+		if err == nil {
+			metrics.RelayRetryTokens.WithLabelValues(metrics.TokenValid).Inc()
+			return true
+		}
+		result := metrics.TokenInvalid
+		if errors.Is(err, errExpiredToken) {
+			result = metrics.TokenExpired
+		}
+		if r.cfg.UDP.LogRequests {
+			log.Printf("Relay: %s -> refused (%v, SNI: %s, DCID: %x)", srcAddr, err, sni, header.DCID)
+		}
+		metrics.RelayRetryTokens.WithLabelValues(result).Inc()
+		metrics.RelayPacketDrops.WithLabelValues(metrics.DropInvalidToken).Add(float64(packets))
+		return false
+	}
+
+	switch {
+	case r.retryRoute(sni):
+		r.sendRetry(srcAddr, header, metrics.RetryRoute)
+	case r.retry.overloaded(now):
+		r.sendRetry(srcAddr, header, metrics.RetryLoad)
+	default:
+		return true
+	}
+	return false
+}
+
+// sendRetry answers an Initial with a Retry carrying a token, asking the
+// client to prove it owns its address by echoing the token from a new
+// connection ID.
+func (r *Relay) sendRetry(srcAddr *net.UDPAddr, header *quic.ParsedHeader, reason string) {
+	scid := make([]byte, len(header.DCID))
+	rand.Read(scid)
+
+	token, err := r.retry.newToken(addrIP(srcAddr), header.DCID, scid, time.Now())
+	if err != nil {
+		log.Printf("Failed to build Retry token for %s: %v", srcAddr, err)
+		return
+	}
+	packet, err := quic.NewRetry(header.Version, header.SCID, scid, header.DCID, token)
+	if err != nil {
+		log.Printf("Failed to build Retry for %s: %v", srcAddr, err)
+		return
+	}
+
+	ln := r.listeners[addrHash(srcAddr)%uint32(len(r.listeners))]
+	if _, err := ln.conn.WriteToUDP(packet, srcAddr); err != nil {
+		log.Printf("Failed to send Retry to %s: %v", srcAddr, err)
+		return
+	}
+	if r.cfg.UDP.LogRequests {
+		log.Printf("Relay: %s -> retry (%s, DCID: %x, new DCID: %x)", srcAddr, reason, header.DCID, scid)
+	}
+	metrics.RelayRetries.WithLabelValues(reason).Inc()
+}
@@ -0,0 +1,230 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/ewancrowle/porter/pkg/retryoffload"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryToken(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.UDP.Retry.Threshold = 1
+	cfg.UDP.Retry.Key = "000102030405060708090a0b0c0d0e0f"
+	cfg.Routes = []config.Route{{FQDN: "game.example.com", RetryAware: true}}
+	cfg.UDP.Retry.TokenLifetime = 10 * time.Second
+	s, err := newRetryService(cfg)
+	if err != nil {
+		t.Fatalf("newRetryService() error = %v", err)
+	}
+
+	client := netip.MustParseAddr("192.0.2.1")
+	odcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	rscid := []byte{9, 10, 11, 12, 13, 14, 15, 16}
+	now := time.Now()
+	token, err := s.newToken(client, odcid, rscid, now)
+	if err != nil {
+		t.Fatalf("newToken() error = %v", err)
+	}
+
+	got, err := s.checkToken(client, token, rscid, now.Add(time.Second))
+	if err != nil || !bytes.Equal(got, odcid) {
+		t.Errorf("checkToken() = %x, %v, want %x", got, err, odcid)
+	}
+	// Backends read the original DCID without the key.
+	if got, _, err := retryoffload.ParseToken(token); err != nil || !bytes.Equal(got, odcid) {
+		t.Errorf("ParseToken() = %x, %v, want %x", got, err, odcid)
+	}
+	if _, err := s.newToken(client, odcid[:4], rscid, now); err == nil {
+		t.Error("Expected a token for a short connection ID to be rejected")
+	}
+
+	tampered := bytes.Clone(token)
+	tampered[2] ^= 1
+	tests := []struct {
+		name   string
+		client netip.Addr
+		token  []byte
+		rscid  []byte
+		now    time.Time
+		want   error
+	}{
+		{"other client", netip.MustParseAddr("192.0.2.2"), token, rscid, now, errInvalidToken},
+		{"other connection ID", client, token, odcid, now, errInvalidToken},
+		{"tampered", client, tampered, rscid, now, errInvalidToken},
+		{"new token", client, append([]byte{0x80 | 8}, token[1:]...), rscid, now, errInvalidToken},
+		{"expired", client, token, rscid, now.Add(11 * time.Second), errExpiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.checkToken(tt.client, tt.token, tt.rscid, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("checkToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRetryServiceValidation(t *testing.T) {
+	cfg := &config.Config{}
+	if s, err := newRetryService(cfg); s != nil || err != nil {
+		t.Errorf("newRetryService() = %v, %v, want nil when disabled", s, err)
+	}
+
+	cfg.UDP.Retry.Threshold = 100
+	cfg.Routes = []config.Route{{FQDN: "game.example.com"}}
+	if _, err := newRetryService(cfg); err == nil {
+		t.Error("Expected a threshold without Retry-aware routes to be rejected")
+	}
+
+	cfg.Routes[0].Retry = true
+	if _, err := newRouteOptions(cfg); err == nil {
+		t.Error("Expected retry on a route without Retry-aware backends to be rejected")
+	}
+
+	cfg.Routes[0].RetryAware = true
+	if s, err := newRetryService(cfg); s == nil || err != nil {
+		t.Errorf("newRetryService() = %v, %v, want a service for a Retry-aware route", s, err)
+	}
+
+	cfg.UDP.Retry.Key = "abcd"
+	if _, err := newRetryService(cfg); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
+
+// startRetrying runs a relay on loopback with Retry configured for the routes,
+// routing every SNI to backend.
+func startRetrying(t *testing.T, cfg *config.Config, client, backend *net.UDPConn) *Relay {
+	t.Helper()
+	r, _ := startServing(t, cfg, client, backend)
+	simple := strategy.NewSimpleStrategy()
+	simple.UpdateRoute("*.example.com", backend.LocalAddr().String())
+	r.manager = strategy.NewStrategyManager()
+	r.manager.Register(strategy.StrategySimple, simple)
+	var err error
+	if r.routeOptions, err = newRouteOptions(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if r.retry, err = newRetryService(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRetryUnderLoad(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	cfg := testServeConfig(false)
+	cfg.UDP.Retry.Threshold = 1
+	cfg.Routes = []config.Route{{FQDN: "game.example.com", RetryAware: true}}
+	r := startRetrying(t, cfg, client, backend)
+	src := client.LocalAddr().(*net.UDPAddr)
+
+	retries := func() float64 {
+		return testutil.ToFloat64(metrics.RelayRetries.WithLabelValues(metrics.RetryLoad))
+	}
+	before := retries()
+
+	// Routes whose backends do not understand Retry are never retried, nor
+	// do they use up the budget.
+	other, header := testInitial(t, 1)
+	r.createSession(src, netip.Addr{}, header, "other.example.com", [][]byte{other})
+	if got := retries() - before; got != 0 {
+		t.Fatalf("Sent %v retries, want none for a route without Retry-aware backends", got)
+	}
+	if _, ok := r.sessions.Load(string(header.DCID)); !ok {
+		t.Error("Expected a session for a route without Retry-aware backends")
+	}
+
+	// The first connection fits the budget, the second gets a Retry.
+	first, header := testInitial(t, 2)
+	r.createSession(src, netip.Addr{}, header, "game.example.com", [][]byte{first})
+	odcid := []byte{0xaa, 2, 3, 4, 5, 6, 7, 8}
+	second, header := testInitialWithToken(t, odcid, nil)
+	r.createSession(src, netip.Addr{}, header, "game.example.com", [][]byte{second})
+	if got := retries() - before; got != 1 {
+		t.Fatalf("Sent %v retries, want 1", got)
+	}
+
+	buf := make([]byte, 2048)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Expected a Retry: %v", err)
+	}
+	retry := buf[:n]
+	if !quic.VerifyRetry(quic.Version1, odcid, retry) {
+		t.Fatal("Retry integrity tag does not verify")
+	}
+	// DCID is the client's SCID; then the new SCID and the token.
+	if !bytes.Equal(retry[6:10], header.SCID) {
+		t.Errorf("Retry DCID = %x, want %x", retry[6:10], header.SCID)
+	}
+	scid := retry[11 : 11+len(odcid)]
+	token := retry[11+len(odcid) : n-quic.RetryIntegrityTagSize]
+
+	// The client retries from the new connection ID with the token. It is
+	// validated and forwarded instead of retried again.
+	valid := testutil.ToFloat64(metrics.RelayRetryTokens.WithLabelValues(metrics.TokenValid))
+	third, header := testInitialWithToken(t, scid, token)
+	r.createSession(src, netip.Addr{}, header, "game.example.com", [][]byte{third})
+	if got := retries() - before; got != 1 {
+		t.Errorf("Sent %v retries, want no more for a validated client", got)
+	}
+	if got := testutil.ToFloat64(metrics.RelayRetryTokens.WithLabelValues(metrics.TokenValid)) - valid; got != 1 {
+		t.Errorf("Counted %v valid tokens, want 1", got)
+	}
+	if _, ok := r.sessions.Load(string(scid)); !ok {
+		t.Error("Expected a session for the validated client")
+	}
+
+	// The same token is useless from the original connection ID. The
+	// backend would trust it, so the Initial is dropped.
+	dropped := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropInvalidToken))
+	fourth, header := testInitialWithToken(t, odcid, token)
+	r.createSession(src, netip.Addr{}, header, "game.example.com", [][]byte{fourth})
+	if got := retries() - before; got != 1 {
+		t.Errorf("Sent %v retries, want none for an invalid token", got)
+	}
+	if got := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropInvalidToken)) - dropped; got != 1 {
+		t.Errorf("Dropped %v Initials, want 1 for a token bound to another connection ID", got)
+	}
+	if _, ok := r.sessions.Load(string(odcid)); ok {
+		t.Error("Expected no session for an invalid token")
+	}
+}
+
+func TestRetryRoute(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	cfg := testServeConfig(false)
+	cfg.Routes = []config.Route{{FQDN: "game.example.com", Retry: true, RetryAware: true}}
+	r := startRetrying(t, cfg, client, backend)
+
+	before := testutil.ToFloat64(metrics.RelayRetries.WithLabelValues(metrics.RetryRoute))
+	initial, header := testInitial(t, 1)
+	r.createSession(client.LocalAddr().(*net.UDPAddr), netip.Addr{}, header, "game.example.com", [][]byte{initial})
+	if got := testutil.ToFloat64(metrics.RelayRetries.WithLabelValues(metrics.RetryRoute)) - before; got != 1 {
+		t.Fatalf("Sent %v retries, want 1 for a retry route", got)
+	}
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Expected a Retry: %v", err)
+	}
+	if !quic.VerifyRetry(quic.Version1, header.DCID, buf[:n]) {
+		t.Error("Retry integrity tag does not verify")
+	}
+	if _, ok := r.sessions.Load(string(header.DCID)); ok {
+		t.Error("Expected no session before the client validates its address")
+	}
+}
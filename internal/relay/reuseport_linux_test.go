@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReusePortListeners(t *testing.T) {
+	client, backend := listenLoopback(t), listenLoopback(t)
+	cfg := testServeConfig(false)
+	cfg.UDP.Listeners = 4
+	r, sess := startServing(t, cfg, client, backend)
+
+	relayAddr := r.localAddr()
+	for _, ln := range r.listeners[1:] {
+		if port := ln.conn.LocalAddr().(*net.UDPAddr).Port; port != relayAddr.Port {
+			t.Fatalf("Listener bound to port %d, want %d", port, relayAddr.Port)
+		}
+	}
+
+	// Each new source port is a migration the kernel may hash to any
+	// socket; the session must follow it and replies come from the relay port.
+	buf := make([]byte, 2048)
+	for i := range 16 {
+		migrated := client
+		if i > 0 {
+			migrated = listenLoopback(t)
+		}
+		packet := sizedPacket(byte(i), 1200)
+		if _, err := migrated.WriteToUDP(packet, relayAddr); err != nil {
+			t.Fatal(err)
+		}
+
+		backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := backend.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("Migration %d: backend read: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], packet) {
+			t.Fatalf("Migration %d: backend got the wrong packet", i)
+		}
+
+		if _, err := backend.WriteToUDP([]byte("reply"), from); err != nil {
+			t.Fatal(err)
+		}
+		migrated.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err = migrated.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("Migration %d: client read: %v", i, err)
+		}
+		if string(buf[:n]) != "reply" || from.Port != relayAddr.Port {
+			t.Errorf("Migration %d: client got %q from %v, want reply from port %d", i, buf[:n], from, relayAddr.Port)
+		}
+	}
+
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	if sess.srcAddr.Port == client.LocalAddr().(*net.UDPAddr).Port {
+		t.Error("Session did not follow the client's migrations")
+	}
+}
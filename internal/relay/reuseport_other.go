@@ -0,0 +1,12 @@
+//go:build !linux
+
+package relay
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(addr *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errors.New("udp.listeners above 1 is only supported on Linux")
+}
@@ -0,0 +1,158 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/ewancrowle/porter/pkg/proxyproto"
+)
+
+// PROXY protocol modes of a route.
+const (
+	ProxyProtocolFirst = "first" // Header on the first datagram of a session
+	ProxyProtocolEvery = "every" // Header on every datagram
+)
+
+// routeOptions are the per-route relay settings from the config file. Zero
+// values fall back to the global settings.
+type routeOptions struct {
+	idleTimeout   time.Duration
+	cidLength     int
+	proxyProtocol string
+	transparent   bool
+	mtu           int
+	retry         bool
+	retryAware    bool
+}
+
+func newRouteOptions(cfg *config.Config) (*strategy.PatternTable[routeOptions], error) {
+	table := strategy.NewPatternTable[routeOptions]()
+	for _, route := range cfg.Routes {
+		if route.CIDLength < 0 || route.CIDLength > quic.MaxConnIDLength {
+			return nil, fmt.Errorf("route %s: invalid cid_length %d", route.FQDN, route.CIDLength)
+		}
+		if route.MTU != 0 && (route.MTU < quic.MinInitialDatagramSize || route.MTU > maxDatagramSize) {
+			return nil, fmt.Errorf("route %s: mtu must be between %d and %d", route.FQDN, quic.MinInitialDatagramSize, maxDatagramSize)
+		}
+		switch route.ProxyProtocol {
+		case "", ProxyProtocolFirst, ProxyProtocolEvery:
+		default:
+			return nil, fmt.Errorf("route %s: proxy_protocol must be %q or %q", route.FQDN, ProxyProtocolFirst, ProxyProtocolEvery)
+		}
+		if route.Retry && !route.RetryAware {
+			return nil, fmt.Errorf("route %s: retry requires retry_aware backends", route.FQDN)
+		}
+
+		opts := routeOptions{
+			idleTimeout:   route.IdleTimeout,
+			cidLength:     route.CIDLength,
+			proxyProtocol: route.ProxyProtocol,
+			transparent:   route.Transparent,
+			mtu:           route.MTU,
+			retry:         route.Retry,
+			retryAware:    route.RetryAware,
+		}
+		if opts != (routeOptions{}) {
+			table.Set(route.FQDN, opts)
+		}
+	}
+	return table, nil
+}
+
+// idleTimeout returns the idle timeout for sessions routed by the given SNI,
+// preferring a per-route override over the global setting.
+func (r *Relay) idleTimeout(sni string) time.Duration {
+	if opts, _, ok := r.routeOptions.Match(sni); ok && opts.idleTimeout > 0 {
+		return opts.idleTimeout
+	}
+	return r.cfg.UDP.IdleTimeout
+}
+
+// cidLength returns the configured backend connection ID length for the SNI,
+// or 0 if it should be learned from the backend.
+func (r *Relay) cidLength(sni string) int {
+	if opts, _, ok := r.routeOptions.Match(sni); ok && opts.cidLength > 0 {
+		return opts.cidLength
+	}
+	return r.cfg.UDP.CIDLength
+}
+
+// proxyMode returns the PROXY protocol mode for the SNI, empty if disabled.
+func (r *Relay) proxyMode(sni string) string {
+	opts, _, _ := r.routeOptions.Match(sni)
+	return opts.proxyProtocol
+}
+
+// mtu returns the largest datagram relayed for the SNI in either direction,
+// or 0 if only udp.max_datagram_size applies.
+func (r *Relay) mtu(sni string) int {
+	opts, _, _ := r.routeOptions.Match(sni)
+	return opts.mtu
+}
+
+// retryRoute reports whether new connections for the SNI must validate their
+// address with a Retry first.
+func (r *Relay) retryRoute(sni string) bool {
+	opts, _, _ := r.routeOptions.Match(sni)
+	return opts.retry
+}
+
+// retryAware reports whether the backends of the SNI's route understand Retry
+// tokens, so its connections may be retried.
+func (r *Relay) retryAware(sni string) bool {
+	opts, _, _ := r.routeOptions.Match(sni)
+	return opts.retryAware
+}
+
+// dialBackend opens the backend socket of a new session, bound to the
+// client's address if the route uses transparent mode.
+func (r *Relay) dialBackend(client, target *net.UDPAddr, sni string) (*net.UDPConn, error) {
+	if opts, _, ok := r.routeOptions.Match(sni); ok && opts.transparent {
+		return dialTransparent(client, target)
+	}
+	return net.DialUDP("udp", nil, target)
+}
+
+// localAddr returns the address the relay listens on. It is the destination
+// of PROXY headers for sessions whose packets did not report theirs.
+func (r *Relay) localAddr() *net.UDPAddr {
+	if len(r.listeners) > 0 {
+		return r.listeners[0].conn.LocalAddr().(*net.UDPAddr)
+	}
+	return r.listenAddr
+}
+
+// proxyHeader returns the PROXY header to prepend to the next datagram sent
+// to the backend, or nil if none is due. The destination is the address the
+// client sent to, on the port of the local address.
+func (s *session) proxyHeader(local *net.UDPAddr) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch s.proxyMode {
+	case ProxyProtocolEvery:
+	case ProxyProtocolFirst:
+		if s.proxySent {
+			return nil
+		}
+	default:
+		return nil
+	}
+	dst := local
+	if s.dstAddr.IsValid() {
+		dst = &net.UDPAddr{IP: s.dstAddr.AsSlice(), Port: local.Port}
+	}
+	return proxyproto.AppendHeader(nil, s.srcAddr, dst)
+}
+
+// proxyHeaderSent records that a datagram carrying the PROXY header reached
+// the backend socket.
+func (s *session) proxyHeaderSent() {
+	s.mu.Lock()
+	s.proxySent = true
+	s.mu.Unlock()
+}
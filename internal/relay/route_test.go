@@ -0,0 +1,290 @@
+package relay
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/quic"
+	"github.com/ewancrowle/porter/internal/strategy"
+	"github.com/ewancrowle/porter/pkg/proxyproto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testRouteConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.UDP.IdleTimeout = 5 * time.Minute
+	cfg.UDP.CIDLength = 8
+	cfg.Routes = make([]config.Route, 2)
+	cfg.Routes[0].FQDN = "*.example.com"
+	cfg.Routes[0].IdleTimeout = time.Minute
+	cfg.Routes[0].CIDLength = 16
+	cfg.Routes[1].FQDN = "game.example.com"
+	cfg.Routes[1].ProxyProtocol = ProxyProtocolEvery
+	return cfg
+}
+
+func TestRouteOptions(t *testing.T) {
+	cfg := testRouteConfig()
+	options, err := newRouteOptions(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{cfg: cfg, routeOptions: options}
+
+	tests := []struct {
+		sni       string
+		timeout   time.Duration
+		cidLength int
+		proxy     string
+	}{
+		{"lobby.example.com", time.Minute, 16, ""},
+		// The most specific route wins, unset options use the global settings.
+		{"game.example.com", 5 * time.Minute, 8, ProxyProtocolEvery},
+		{"example.org", 5 * time.Minute, 8, ""},
+	}
+	for _, tt := range tests {
+		if got := r.idleTimeout(tt.sni); got != tt.timeout {
+			t.Errorf("idleTimeout(%s) = %v, want %v", tt.sni, got, tt.timeout)
+		}
+		if got := r.cidLength(tt.sni); got != tt.cidLength {
+			t.Errorf("cidLength(%s) = %d, want %d", tt.sni, got, tt.cidLength)
+		}
+		if got := r.proxyMode(tt.sni); got != tt.proxy {
+			t.Errorf("proxyMode(%s) = %q, want %q", tt.sni, got, tt.proxy)
+		}
+	}
+
+	cfg.Routes[1].ProxyProtocol = "always"
+	if _, err := newRouteOptions(cfg); err == nil {
+		t.Error("Expected an invalid proxy_protocol to fail")
+	}
+	cfg.Routes[1].ProxyProtocol = ""
+	cfg.Routes[1].MTU = 1000
+	if _, err := newRouteOptions(cfg); err == nil {
+		t.Error("Expected an mtu below the QUIC minimum to fail")
+	}
+}
+
+func TestForwardProxyProtocol(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}, listenAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}}
+
+	for _, mode := range []string{ProxyProtocolFirst, ProxyProtocolEvery} {
+		t.Run(mode, func(t *testing.T) {
+			listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			conn, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 50000}
+			sess := &session{srcAddr: client, backendConn: conn, proxyMode: mode}
+			r.forward(sess, []byte("one"))
+			r.forward(sess, []byte("two"))
+
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 1500)
+			for i, want := range []string{"one", "two"} {
+				n, _, err := listener.ReadFromUDP(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				header, payload, err := proxyproto.Parse(buf[:n])
+				wantHeader := i == 0 || mode == ProxyProtocolEvery
+				if wantHeader != (err == nil) {
+					t.Fatalf("datagram %d: header error = %v, want header %v", i, err, wantHeader)
+				}
+				if string(payload) != want {
+					t.Errorf("datagram %d: payload = %q, want %q", i, payload, want)
+				}
+				if wantHeader && (header.Source.String() != client.String() || header.Destination.String() != "10.0.0.1:443") {
+					t.Errorf("datagram %d: header = %s -> %s", i, header.Source, header.Destination)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardProxyProtocolMigration(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}, listenAddr: &net.UDPAddr{IP: net.IPv4zero, Port: 443}}
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	relayIP := netip.MustParseAddr("10.0.0.1")
+	client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 50000}
+	migrated := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 8), Port: 50001}
+	sess := &session{srcAddr: client, dstAddr: relayIP, backendConn: conn, proxyMode: ProxyProtocolFirst}
+	r.forwardFromClient(client, relayIP, sess, nil, []byte("one"))
+	r.forwardFromClient(client, relayIP, sess, nil, []byte("two"))
+	r.forwardFromClient(migrated, relayIP, sess, nil, []byte("three"))
+
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	for i, want := range []*net.UDPAddr{client, nil, migrated} {
+		n, _, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, err := proxyproto.Parse(buf[:n])
+		if (err == nil) != (want != nil) {
+			t.Fatalf("datagram %d: header error = %v, want header %v", i, err, want != nil)
+		}
+		if want != nil && (header.Source.String() != want.String() || header.Destination.String() != "10.0.0.1:443") {
+			t.Errorf("datagram %d: header = %s -> %s, want %s -> 10.0.0.1:443", i, header.Source, header.Destination, want)
+		}
+	}
+}
+
+func TestForwardMTU(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}}
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sess := &session{srcAddr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 50000}, backendConn: conn, mtu: 1300}
+	r.forward(sess, make([]byte, 1400))
+	r.forward(sess, make([]byte, 1300))
+
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := listener.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1300 {
+		t.Errorf("Backend got a %d byte datagram, want only the 1300 byte one", n)
+	}
+}
+
+func TestForwardMTUWithProxyProtocol(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}, listenAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}}
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 50000}
+	sess := &session{srcAddr: client, backendConn: conn, mtu: 1300, proxyMode: ProxyProtocolFirst, logged: true}
+	relayed := func() float64 {
+		return testutil.ToFloat64(metrics.RelayBytes.WithLabelValues(metrics.DirectionClientToBackend))
+	}
+	before := relayed()
+
+	// A dropped datagram must not use up the header.
+	r.forward(sess, make([]byte, 1400))
+	r.forward(sess, make([]byte, 1300))
+
+	// Traffic is counted as the client sent it, without the header.
+	if got := sess.stats.bytesIn.Load(); got != 1300 {
+		t.Errorf("Session bytes in = %d, want 1300", got)
+	}
+	if got := relayed() - before; got != 1300 {
+		t.Errorf("Relayed bytes = %v, want 1300", got)
+	}
+
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := listener.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err := proxyproto.Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("Expected a PROXY header on the first relayed datagram: %v", err)
+	}
+	if len(payload) != 1300 || header.Source.String() != client.String() {
+		t.Errorf("Got %d payload bytes from %s, want 1300 from %s", len(payload), header.Source, client)
+	}
+
+	// Neither does a failed write.
+	closed, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	failed := &session{srcAddr: client, backendConn: closed, proxyMode: ProxyProtocolFirst}
+	r.forward(failed, []byte("lost"))
+	if failed.proxySent {
+		t.Error("Expected the header to stay due after a failed write")
+	}
+}
+
+func TestSessionMetrics(t *testing.T) {
+	backend := listenLoopback(t)
+	simple := strategy.NewSimpleStrategy()
+	simple.UpdateRoute("*.example.com", backend.LocalAddr().String())
+	manager := strategy.NewStrategyManager()
+	manager.Register(strategy.StrategySimple, simple)
+
+	cfg := testRouteConfig()
+	options, err := newRouteOptions(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{cfg: cfg, manager: manager, routeOptions: options}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+
+	sessions := func() float64 {
+		return testutil.ToFloat64(metrics.RelaySessions.WithLabelValues("*.example.com", string(strategy.StrategySimple)))
+	}
+	before := sessions()
+	initial, header := testInitial(t, 1)
+	r.createSession(src, netip.Addr{}, header, "player-1234.example.com", [][]byte{initial})
+	val, ok := r.sessions.Load(string(header.DCID))
+	if !ok {
+		t.Fatal("Expected a session")
+	}
+	defer r.closeSession(val.(*session), "test done")
+
+	if got := sessions() - before; got != 1 {
+		t.Errorf("Counted %v sessions for the route pattern, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.RelaySessions.WithLabelValues("player-1234.example.com", string(strategy.StrategySimple))); got != 0 {
+		t.Errorf("Counted %v sessions under the raw SNI", got)
+	}
+
+	noRoute := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropNoRoute))
+	initial, header = testInitial(t, 2)
+	r.createSession(src, netip.Addr{}, header, "game.example.org", [][]byte{initial})
+	if got := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropNoRoute)) - noRoute; got != 1 {
+		t.Errorf("Counted %v no_route drops, want 1", got)
+	}
+
+	unknown := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropUnknownCID))
+	r.handlePacket(src, netip.Addr{}, shortHeaderPacket([]byte{1, 2, 3, 4, 5, 6, 7, 8}), &quic.ParsedHeader{})
+	if got := testutil.ToFloat64(metrics.RelayPacketDrops.WithLabelValues(metrics.DropUnknownCID)) - unknown; got != 1 {
+		t.Errorf("Counted %v unknown_cid drops, want 1", got)
+	}
+}
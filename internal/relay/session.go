@@ -0,0 +1,193 @@
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/accesslog"
+	"github.com/ewancrowle/porter/internal/metrics"
+)
+
+type session struct {
+	key         string // Client's original DCID, identifies the session across replicas
+	target      string // Target as resolved by the routing strategy
+	targetAddr  *net.UDPAddr
+	lastSeen    time.Time
+	mu          sync.RWMutex
+	srcAddr     *net.UDPAddr
+	dstAddr     netip.Addr // Relay address the client sends to, zero if unknown
+	backendConn *net.UDPConn
+
+	sni         string
+	idleTimeout time.Duration
+	ids         []string // Every DCID/SCID alias registered in Relay.sessions
+	cidLength   int      // Length of the backend's connection IDs, 0 until known
+	sharedAt    time.Time
+
+	mtu       int    // Largest datagram relayed, 0 if unlimited
+	proxyMode string // PROXY protocol mode of the route, see routeOptions
+	proxySent bool
+	closed    bool
+	clientIP  netip.Addr // Client's IP when the session started, for rate limits
+
+	// Access log state, counted only when logged is set.
+	strategy   string
+	started    time.Time
+	logged     bool
+	stats      sessionStats
+	migrations atomic.Int64
+}
+
+// sessionStats counts the traffic of a session for its access log record.
+type sessionStats struct {
+	bytesIn, bytesOut     atomic.Int64 // Client to backend, backend to client
+	packetsIn, packetsOut atomic.Int64
+}
+
+// accessRecord describes the session for the access log.
+func (s *session) accessRecord(event string) accesslog.Record {
+	s.mu.RLock()
+	client := s.srcAddr.String()
+	s.mu.RUnlock()
+
+	return accesslog.Record{
+		Event:      event,
+		Client:     client,
+		SNI:        s.sni,
+		DCID:       hex.EncodeToString([]byte(s.key)),
+		Strategy:   s.strategy,
+		Target:     s.target,
+		BytesIn:    s.stats.bytesIn.Load(),
+		BytesOut:   s.stats.bytesOut.Load(),
+		PacketsIn:  s.stats.packetsIn.Load(),
+		PacketsOut: s.stats.packetsOut.Load(),
+		Migrations: s.migrations.Load(),
+	}
+}
+
+// touch records activity on the session so the reaper does not expire it.
+func (s *session) touch() {
+	s.mu.Lock()
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+// expired reports whether the session has been idle for longer than its timeout.
+// A zero timeout disables expiry.
+func (s *session) expired(now time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idleTimeout > 0 && now.Sub(s.lastSeen) > s.idleTimeout
+}
+
+// registerID maps a connection ID to the session. It returns false if the ID
+// already belongs to another session or the session has been closed.
+func (r *Relay) registerID(id string, sess *session) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.closed {
+		return false
+	}
+
+	if existing, loaded := r.sessions.LoadOrStore(id, sess); loaded {
+		return existing.(*session) == sess
+	}
+	sess.ids = append(sess.ids, id)
+	return true
+}
+
+// closeSession evicts every connection ID alias pointing at the session and
+// closes its backend socket, which also stops handleBackendResponse.
+func (r *Relay) closeSession(sess *session, reason string) {
+	sess.mu.Lock()
+	if sess.closed {
+		sess.mu.Unlock()
+		return
+	}
+	sess.closed = true
+	ids := sess.ids
+	srcAddr := sess.srcAddr
+	cidLength := sess.cidLength
+	sess.mu.Unlock()
+
+	for _, id := range ids {
+		r.sessions.CompareAndDelete(id, sess)
+	}
+	// Sessions closed by a shutdown stay shared for another replica to adopt.
+	r.lifecycle.RLock()
+	stopped := r.stopped
+	r.lifecycle.RUnlock()
+	if !stopped {
+		r.unshareIDs(ids)
+	}
+	sess.backendConn.Close()
+	r.cids.remove(cidLength)
+	r.targetSessionCount(sess.target).Add(-1)
+	r.activeSessions.Add(-1)
+	r.limits.sessionClosed(sess.clientIP)
+	metrics.RelayActiveSessions.Dec()
+
+	log.Printf("Session closed: %s -> %s (SNI: %s, reason: %s)", srcAddr, sess.targetAddr, sess.sni, reason)
+
+	if sess.logged {
+		rec := sess.accessRecord(accesslog.EventSessionEnd)
+		rec.Duration = time.Since(sess.started).Seconds()
+		rec.Reason = reason
+		r.accessLog.Log(rec)
+	}
+}
+
+// reapIdleSessions periodically closes sessions that have seen no traffic in
+// either direction for longer than their idle timeout, and drops pending
+// handshakes that never completed.
+func (r *Relay) reapIdleSessions(ctx context.Context) {
+	interval := r.cfg.UDP.ReapInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.sessions.Range(func(_, val any) bool {
+				sess := val.(*session)
+				if sess.expired(now) {
+					r.closeSession(sess, "idle timeout")
+				}
+				return true
+			})
+			if r.cfg.UDP.HandshakeTimeout > 0 {
+				r.expirePendingHandshakes(now)
+			}
+			r.limits.cleanup(now)
+			r.refreshShared(now)
+			r.pruneDirectoryMisses(now)
+		}
+	}
+}
+
+func (r *Relay) targetSessionCount(target string) *atomic.Int64 {
+	val, _ := r.targetSessions.LoadOrStore(target, new(atomic.Int64))
+	return val.(*atomic.Int64)
+}
+
+// ActiveSessions returns the number of open sessions to the target. It
+// implements strategy.SessionCounter.
+func (r *Relay) ActiveSessions(target string) int {
+	if val, ok := r.targetSessions.Load(target); ok {
+		return int(val.(*atomic.Int64).Load())
+	}
+	return 0
+}
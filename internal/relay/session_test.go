@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+)
+
+func newTestSession(t *testing.T, timeout time.Duration) *session {
+	t.Helper()
+
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	targetAddr := backend.LocalAddr().(*net.UDPAddr)
+	conn, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial backend: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &session{
+		targetAddr:  targetAddr,
+		lastSeen:    time.Now(),
+		srcAddr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+		backendConn: conn,
+		sni:         "test.com",
+		idleTimeout: timeout,
+	}
+}
+
+func TestReapIdleSessions(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.UDP.ReapInterval = 10 * time.Millisecond
+	r := &Relay{cfg: cfg}
+
+	idle := newTestSession(t, 50*time.Millisecond)
+	idle.lastSeen = time.Now().Add(-time.Second)
+	r.registerID("dcid-idle", idle)
+	r.registerID("scid-idle", idle)
+
+	active := newTestSession(t, time.Hour)
+	r.registerID("dcid-active", active)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.reapIdleSessions(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		_, dcidOK := r.sessions.Load("dcid-idle")
+		_, scidOK := r.sessions.Load("scid-idle")
+		if !dcidOK && !scidOK {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, ok := r.sessions.Load("dcid-idle"); ok {
+		t.Error("Expected idle DCID alias to be evicted")
+	}
+	if _, ok := r.sessions.Load("scid-idle"); ok {
+		t.Error("Expected idle SCID alias to be evicted")
+	}
+	if _, ok := r.sessions.Load("dcid-active"); !ok {
+		t.Error("Expected active session to be kept")
+	}
+
+	if _, err := idle.backendConn.Write([]byte{0}); err == nil {
+		t.Error("Expected backend socket of reaped session to be closed")
+	}
+	if r.registerID("late-alias", idle) {
+		t.Error("Expected registering an alias on a closed session to fail")
+	}
+}
+
+func TestRegisterIDConflict(t *testing.T) {
+	r := &Relay{cfg: &config.Config{}}
+	a := newTestSession(t, time.Minute)
+	b := newTestSession(t, time.Minute)
+
+	if !r.registerID("cid", a) {
+		t.Fatal("Expected first registration to succeed")
+	}
+	if !r.registerID("cid", a) {
+		t.Error("Expected re-registering the same session to succeed")
+	}
+	if r.registerID("cid", b) {
+		t.Error("Expected registration for a different session to fail")
+	}
+	if len(a.ids) != 1 {
+		t.Errorf("Expected 1 alias recorded, got %d", len(a.ids))
+	}
+}
@@ -0,0 +1,56 @@
+package relay
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dialTransparent opens a backend socket bound to the client's own address,
+// so the backend sees the real client IP. The socket needs IP_TRANSPARENT,
+// which requires CAP_NET_ADMIN, and policy routing that delivers the
+// backend's replies to the client address back to this host.
+func dialTransparent(client, target *net.UDPAddr) (*net.UDPConn, error) {
+	local := &net.UDPAddr{IP: client.IP, Port: client.Port, Zone: client.Zone}
+	if ip4 := client.IP.To4(); ip4 != nil {
+		local.IP = ip4
+	}
+
+	d := net.Dialer{
+		LocalAddr: local,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setTransparent(int(fd), network == "udp6")
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	conn, err := d.Dial("udp", target.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+func setTransparent(fd int, ipv6 bool) error {
+	// A client reconnecting from the same port may still have a session.
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return err
+	}
+	if ipv6 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1); err != nil {
+			return err
+		}
+		return unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_FREEBIND, 1)
+}
@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs fn on a thread moved into a new network namespace, with the
+// given ip(8) commands applied first. The thread is never returned to the
+// runtime, so the namespace does not leak into other tests. fn must not call
+// t.Fatal.
+func inNetns(t *testing.T, setup [][]string, fn func()) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip command not found")
+	}
+
+	skip := make(chan error, 1)
+	go func() {
+		defer close(skip)
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			skip <- err
+			return
+		}
+		for _, args := range setup {
+			if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+				t.Errorf("ip %v: %v: %s", args, err, out)
+				return
+			}
+		}
+		fn()
+	}()
+	if err := <-skip; err != nil {
+		t.Skipf("unshare(CLONE_NEWNET): %v", err)
+	}
+}
+
+func TestDialTransparent(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+
+	// Deliver traffic for the client's network locally, the way the policy
+	// routing for transparent mode does on a real relay host.
+	setup := [][]string{
+		{"link", "set", "lo", "up"},
+		{"rule", "add", "to", "198.51.100.0/24", "lookup", "100"},
+		{"route", "add", "local", "198.51.100.0/24", "dev", "lo", "table", "100"},
+	}
+
+	inNetns(t, setup, func() {
+		backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Errorf("Failed to listen: %v", err)
+			return
+		}
+		defer backend.Close()
+
+		conn, err := dialTransparent(client, backend.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Errorf("dialTransparent() error = %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Error(err)
+			return
+		}
+		backend.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1500)
+		n, from, err := backend.ReadFromUDP(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != "hello" || from.String() != client.String() {
+			t.Errorf("Backend received %q from %s, want %q from %s", buf[:n], from, "hello", client)
+		}
+
+		// The reply to the client's address must reach the relay's socket.
+		if _, err := backend.WriteToUDP([]byte("welcome"), from); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err = conn.Read(buf)
+		if err != nil || string(buf[:n]) != "welcome" {
+			t.Errorf("Relay received %q, %v, want %q", buf[:n], err, "welcome")
+		}
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package relay
+
+import (
+	"errors"
+	"net"
+)
+
+func dialTransparent(client, target *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errors.New("transparent mode is only supported on Linux")
+}
@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/ewancrowle/porter/internal/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -73,10 +77,51 @@ func (s *AgonesStrategy) Setup(enabled bool, ns, host, cert, key string) error {
 
 	s.conn = conn
 	s.client = pb.NewAllocationServiceClient(conn)
+	// Connect now rather than on the first allocation, so Check reflects
+	// whether the allocator is reachable.
+	conn.Connect()
 
 	return nil
 }
 
+// Check reports whether the connection to the Agones allocator is usable. An
+// idle connection passes, since gRPC closes unused connections and reconnects
+// on demand; Check asks it to reconnect so the next call sees the real state.
+func (s *AgonesStrategy) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.enabled {
+		return nil
+	}
+	if s.conn == nil {
+		return errors.New("agones allocator not connected")
+	}
+
+	switch state := s.conn.GetState(); state {
+	case connectivity.Ready:
+		return nil
+	case connectivity.Idle:
+		s.conn.Connect()
+		return nil
+	default:
+		return fmt.Errorf("agones allocator connection is %s", strings.ToLower(state.String()))
+	}
+}
+
+// Close closes the connection to the Agones allocator.
+func (s *AgonesStrategy) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
 func (s *AgonesStrategy) createRemoteClusterDialOption(clientCert, clientKey []byte) (grpc.DialOption, error) {
 	cert, err := tls.X509KeyPair(clientCert, clientKey)
 	if err != nil {
@@ -90,7 +135,7 @@ func (s *AgonesStrategy) createRemoteClusterDialOption(clientCert, clientKey []b
 
 func (s *AgonesStrategy) Resolve(ctx context.Context, fqdn string) (string, error) {
 	s.mu.RLock()
-	fleetName, ok := s.fleets[fqdn]
+	fleetName, ok := s.fleets[NormalizePattern(fqdn)]
 	client := s.client
 	enabled := s.enabled
 	s.mu.RUnlock()
@@ -107,10 +152,44 @@ func (s *AgonesStrategy) Resolve(ctx context.Context, fqdn string) (string, erro
 	return target, err
 }
 
+// MatchRoute reports whether the FQDN is mapped to a fleet. Agones routes
+// are exact names.
+func (s *AgonesStrategy) MatchRoute(fqdn string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fqdn = NormalizePattern(fqdn)
+	_, ok := s.fleets[fqdn]
+	return fqdn, ok
+}
+
 func (s *AgonesStrategy) UpdateRoute(fqdn, fleetName string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.fleets[fqdn] = fleetName
+	s.fleets[NormalizePattern(fqdn)] = fleetName
+}
+
+// RemoveRoute deletes the fleet mapping for the FQDN and reports whether it existed.
+func (s *AgonesStrategy) RemoveRoute(fqdn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fqdn = NormalizePattern(fqdn)
+	if _, ok := s.fleets[fqdn]; !ok {
+		return false
+	}
+	delete(s.fleets, fqdn)
+	return true
+}
+
+// Routes returns a snapshot of all FQDN to fleet mappings.
+func (s *AgonesStrategy) Routes() []Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routes := make([]Route, 0, len(s.fleets))
+	for fqdn, fleetName := range s.fleets {
+		routes = append(routes, Route{FQDN: fqdn, Type: StrategyAgones, Target: fleetName})
+	}
+	return routes
 }
 
 func (s *AgonesStrategy) Allocate(ctx context.Context, fleetName string) (string, string, error) {
@@ -151,11 +230,15 @@ func (s *AgonesStrategy) Allocate(ctx context.Context, fleetName string) (string
 	}
 
 	log.Printf("Attempting Agones allocation for fleet: %s", fleetName)
+	start := time.Now()
 	resp, err := client.Allocate(ctx, request)
 	if err != nil {
+		metrics.AgonesAllocationDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		metrics.AgonesAllocationErrors.WithLabelValues(fleetName).Inc()
 		log.Printf("Agones allocation failed for fleet %s: %v", fleetName, err)
 		return "", "", fmt.Errorf("agones allocation failed: %w", err)
 	}
+	metrics.AgonesAllocationDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 
 	target := fmt.Sprintf("%s:%d", resp.Address, resp.Ports[0].Port)
 	log.Printf("Agones allocation successful: %s -> %s (GameServer: %s)", fleetName, target, resp.GameServerName)
@@ -0,0 +1,225 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sync"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmRoundRobin    Algorithm = "round_robin"
+	AlgorithmRandom        Algorithm = "random"
+	AlgorithmLeastSessions Algorithm = "least_sessions"
+	AlgorithmHash          Algorithm = "hash"
+)
+
+// WeightedTarget is one backend of a route. A weight of 0 is treated as 1.
+type WeightedTarget struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// SessionCounter reports how many sessions are currently open to a target.
+// The relay implements it for the least_sessions algorithm.
+type SessionCounter interface {
+	ActiveSessions(target string) int
+}
+
+// HealthChecker reports whether a target is passing its health checks.
+type HealthChecker interface {
+	Healthy(target string) bool
+}
+
+// ClientAddrFromContext returns the client address of the request attached by
+// WithRequest, for algorithms such as hash.
+func ClientAddrFromContext(ctx context.Context) (net.Addr, bool) {
+	req, ok := RequestFromContext(ctx)
+	if !ok || req.ClientAddr == nil {
+		return nil, false
+	}
+	return req.ClientAddr, true
+}
+
+// ValidateAlgorithm reports whether the algorithm is known. An empty
+// algorithm selects round robin.
+func ValidateAlgorithm(algorithm Algorithm) error {
+	switch algorithm {
+	case "", AlgorithmRoundRobin, AlgorithmRandom, AlgorithmLeastSessions, AlgorithmHash:
+		return nil
+	default:
+		return fmt.Errorf("unknown load balancing algorithm %q", algorithm)
+	}
+}
+
+// balancedRoute picks one of several weighted targets for each new connection.
+type balancedRoute struct {
+	targets   []WeightedTarget
+	algorithm Algorithm
+
+	mu      sync.Mutex
+	current []int // Smooth weighted round robin state
+}
+
+func newBalancedRoute(targets []WeightedTarget, algorithm Algorithm) (*balancedRoute, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("route has no targets")
+	}
+	if err := ValidateAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+	if algorithm == "" {
+		algorithm = AlgorithmRoundRobin
+	}
+
+	normalized := make([]WeightedTarget, len(targets))
+	for i, t := range targets {
+		if t.Address == "" {
+			return nil, errors.New("target address is empty")
+		}
+		if t.Weight < 0 {
+			return nil, fmt.Errorf("target %s has a negative weight", t.Address)
+		}
+		if t.Weight == 0 {
+			t.Weight = 1
+		}
+		normalized[i] = t
+	}
+
+	return &balancedRoute{
+		targets:   normalized,
+		algorithm: algorithm,
+		current:   make([]int, len(normalized)),
+	}, nil
+}
+
+// pick chooses a target for a new connection. Targets the health checker
+// reports as down are skipped, unless every target is down, in which case
+// all of them stay eligible rather than failing the route outright.
+func (r *balancedRoute) pick(ctx context.Context, counter SessionCounter, health HealthChecker) string {
+	candidates := r.healthy(health)
+	if len(candidates) == 1 {
+		return r.targets[candidates[0]].Address
+	}
+
+	switch r.algorithm {
+	case AlgorithmRandom:
+		return r.pickRandom(candidates)
+	case AlgorithmLeastSessions:
+		if counter != nil {
+			return r.pickLeastSessions(candidates, counter)
+		}
+	case AlgorithmHash:
+		if addr, ok := ClientAddrFromContext(ctx); ok {
+			return r.pickHash(candidates, clientIP(addr))
+		}
+		return r.pickRandom(candidates)
+	}
+	return r.pickRoundRobin(candidates)
+}
+
+// healthy returns the indexes of the targets eligible for new connections.
+func (r *balancedRoute) healthy(health HealthChecker) []int {
+	all := make([]int, 0, len(r.targets))
+	up := make([]int, 0, len(r.targets))
+	for i, t := range r.targets {
+		all = append(all, i)
+		if health == nil || health.Healthy(t.Address) {
+			up = append(up, i)
+		}
+	}
+	if len(up) == 0 {
+		return all
+	}
+	return up
+}
+
+// pickRoundRobin implements smooth weighted round robin, which spreads
+// heavier targets evenly through the rotation instead of in bursts.
+func (r *balancedRoute) pickRoundRobin(candidates []int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total, best := 0, candidates[0]
+	for _, i := range candidates {
+		r.current[i] += r.targets[i].Weight
+		total += r.targets[i].Weight
+		if r.current[i] > r.current[best] {
+			best = i
+		}
+	}
+	r.current[best] -= total
+	return r.targets[best].Address
+}
+
+func (r *balancedRoute) pickRandom(candidates []int) string {
+	total := 0
+	for _, i := range candidates {
+		total += r.targets[i].Weight
+	}
+
+	n := rand.IntN(total)
+	for _, i := range candidates {
+		if n < r.targets[i].Weight {
+			return r.targets[i].Address
+		}
+		n -= r.targets[i].Weight
+	}
+	return r.targets[candidates[len(candidates)-1]].Address
+}
+
+// pickLeastSessions picks the target with the fewest active sessions per
+// unit of weight. Ties go to the target listed first.
+func (r *balancedRoute) pickLeastSessions(candidates []int, counter SessionCounter) string {
+	best := candidates[0]
+	bestActive := counter.ActiveSessions(r.targets[best].Address)
+	for _, i := range candidates[1:] {
+		active := counter.ActiveSessions(r.targets[i].Address)
+		// active/weight < bestActive/bestWeight, without division
+		if active*r.targets[best].Weight < bestActive*r.targets[i].Weight {
+			best, bestActive = i, active
+		}
+	}
+	return r.targets[best].Address
+}
+
+// pickHash uses weighted rendezvous hashing on the client IP, so a client
+// keeps landing on the same target and only clients of a removed target move.
+func (r *balancedRoute) pickHash(candidates []int, key string) string {
+	best, bestScore := candidates[0], math.Inf(-1)
+	for _, i := range candidates {
+		t := r.targets[i]
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(t.Address))
+
+		// Map the hash to (0, 1) and weight it: score = -weight / ln(u)
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(t.Weight) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return r.targets[best].Address
+}
+
+func clientIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
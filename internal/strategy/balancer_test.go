@@ -0,0 +1,178 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestBalancedRouteRoundRobin(t *testing.T) {
+	route, err := newBalancedRoute([]WeightedTarget{
+		{Address: "a:1", Weight: 5},
+		{Address: "b:1", Weight: 1},
+		{Address: "c:1", Weight: 1},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 7; i++ {
+		got = append(got, route.pick(context.Background(), nil, nil))
+	}
+	want := []string{"a:1", "a:1", "b:1", "a:1", "c:1", "a:1", "a:1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sequence = %v, want %v", got, want)
+	}
+}
+
+func TestBalancedRouteRandom(t *testing.T) {
+	route, err := newBalancedRoute([]WeightedTarget{
+		{Address: "a:1", Weight: 3},
+		{Address: "b:1", Weight: 1},
+	}, AlgorithmRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[route.pick(context.Background(), nil, nil)]++
+	}
+	// Expect roughly 3000/1000, leave plenty of room for randomness.
+	if counts["a:1"] < 2700 || counts["b:1"] < 700 {
+		t.Errorf("distribution = %v, want about 3:1", counts)
+	}
+}
+
+type fakeCounter map[string]int
+
+func (f fakeCounter) ActiveSessions(target string) int { return f[target] }
+
+func TestBalancedRouteLeastSessions(t *testing.T) {
+	route, err := newBalancedRoute([]WeightedTarget{
+		{Address: "a:1", Weight: 2},
+		{Address: "b:1", Weight: 1},
+		{Address: "c:1", Weight: 1},
+	}, AlgorithmLeastSessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		counts fakeCounter
+		want   string
+	}{
+		{fakeCounter{}, "a:1"},
+		{fakeCounter{"a:1": 2, "b:1": 1, "c:1": 0}, "c:1"},
+		{fakeCounter{"a:1": 3, "b:1": 2, "c:1": 2}, "a:1"}, // 1.5 per unit of weight
+		{fakeCounter{"a:1": 4, "b:1": 2, "c:1": 2}, "a:1"}, // ties go to the first target
+		{fakeCounter{"a:1": 5, "b:1": 2, "c:1": 2}, "b:1"},
+	}
+	for _, tt := range tests {
+		if got := route.pick(context.Background(), tt.counts, nil); got != tt.want {
+			t.Errorf("pick(%v) = %s, want %s", tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestBalancedRouteHash(t *testing.T) {
+	targets := []WeightedTarget{{Address: "a:1"}, {Address: "b:1"}, {Address: "c:1"}}
+	route, err := newBalancedRoute(targets, AlgorithmHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reduced, err := newBalancedRoute(targets[:2], AlgorithmHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i/256), byte(i)), Port: 1000 + i}
+		ctx := WithRequest(context.Background(), Request{ClientAddr: addr})
+
+		first := route.pick(ctx, nil, nil)
+		used[first] = true
+
+		// The port must not matter, only the client IP.
+		other := WithRequest(context.Background(), Request{ClientAddr: &net.UDPAddr{IP: addr.IP, Port: 9}})
+		if got := route.pick(other, nil, nil); got != first {
+			t.Fatalf("client %s moved from %s to %s", addr.IP, first, got)
+		}
+
+		// Removing c:1 must only move the clients that were on it.
+		if first != "c:1" {
+			if got := reduced.pick(ctx, nil, nil); got != first {
+				t.Errorf("client %s moved from %s to %s after removing c:1", addr.IP, first, got)
+			}
+		}
+	}
+	if len(used) != 3 {
+		t.Errorf("clients used %d targets, want 3", len(used))
+	}
+}
+
+func TestSimpleStrategySetRoute(t *testing.T) {
+	s := NewSimpleStrategy()
+
+	invalid := []Route{
+		{FQDN: "game.example.com"},
+		{FQDN: "game.example.com", Targets: []WeightedTarget{{Address: ""}}},
+		{FQDN: "game.example.com", Targets: []WeightedTarget{{Address: "a:1", Weight: -1}}},
+		{FQDN: "game.example.com", Targets: []WeightedTarget{{Address: "a:1"}}, Algorithm: "fastest"},
+		{FQDN: "ga*e.example.com", Target: "a:1"},
+	}
+	for _, route := range invalid {
+		if err := s.SetRoute(route); err == nil {
+			t.Errorf("SetRoute(%+v) succeeded, want error", route)
+		}
+	}
+
+	route := Route{
+		FQDN:      "game.example.com",
+		Targets:   []WeightedTarget{{Address: "a:1", Weight: 2}, {Address: "b:1", Weight: 1}},
+		Algorithm: AlgorithmLeastSessions,
+	}
+	if err := s.SetRoute(route); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSessionCounter(fakeCounter{"a:1": 4})
+
+	if got, err := s.Resolve(context.Background(), "game.example.com"); err != nil || got != "b:1" {
+		t.Errorf("Resolve() = %q, %v, want b:1", got, err)
+	}
+
+	routes := s.Routes()
+	if len(routes) != 1 || len(routes[0].Targets) != 2 || routes[0].Algorithm != AlgorithmLeastSessions {
+		t.Errorf("Routes() = %+v", routes)
+	}
+}
+
+type fakeHealth map[string]bool
+
+func (f fakeHealth) Healthy(target string) bool { return !f[target] }
+
+func TestBalancedRouteSkipsUnhealthy(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmRoundRobin, AlgorithmRandom, AlgorithmLeastSessions, AlgorithmHash} {
+		route, err := newBalancedRoute([]WeightedTarget{{Address: "a:1", Weight: 5}, {Address: "b:1"}, {Address: "c:1"}}, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		down := fakeHealth{"a:1": true, "c:1": true}
+		for i := 0; i < 20; i++ {
+			ctx := WithRequest(context.Background(), Request{ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i))}})
+			if got := route.pick(ctx, fakeCounter{}, down); got != "b:1" {
+				t.Fatalf("%s: pick() = %s with a:1 and c:1 down, want b:1", algorithm, got)
+			}
+		}
+
+		// With every target down, all of them stay eligible.
+		all := fakeHealth{"a:1": true, "b:1": true, "c:1": true}
+		if got := route.pick(context.Background(), fakeCounter{}, all); got == "" {
+			t.Errorf("%s: pick() returned no target with every target down", algorithm)
+		}
+	}
+}
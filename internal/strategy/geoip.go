@@ -0,0 +1,229 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GeoTargets maps client locations to targets. The most specific match wins:
+// an ASN, then a country, then a continent.
+type GeoTargets struct {
+	ASNs       map[uint32]string `json:"asns,omitempty"`
+	Countries  map[string]string `json:"countries,omitempty"`  // ISO 3166-1 alpha-2 code -> target
+	Continents map[string]string `json:"continents,omitempty"` // Continent code, e.g. EU -> target
+}
+
+// GeoLocation is what a GeoIP database knows about a client. Empty fields are
+// unknown.
+type GeoLocation struct {
+	Country   string
+	Continent string
+	ASN       uint32
+}
+
+// GeoLocator looks up client locations. The geoip package implements it.
+type GeoLocator interface {
+	Locate(ip netip.Addr) GeoLocation
+}
+
+var continentCodes = map[string]bool{
+	"AF": true, "AN": true, "AS": true, "EU": true, "NA": true, "OC": true, "SA": true,
+}
+
+// GeoIPStrategy sends clients of one FQDN to different targets depending on
+// where they connect from.
+type GeoIPStrategy struct {
+	routes *PatternTable[*geoRoute]
+
+	mu      sync.RWMutex
+	locator GeoLocator
+	health  HealthChecker
+}
+
+type geoRoute struct {
+	targets  GeoTargets
+	fallback string
+}
+
+func NewGeoIPStrategy() *GeoIPStrategy {
+	return &GeoIPStrategy{
+		routes: NewPatternTable[*geoRoute](),
+	}
+}
+
+// SetLocator provides the database used to locate clients. Without one every
+// client gets its route's fallback target.
+func (s *GeoIPStrategy) SetLocator(locator GeoLocator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locator = locator
+}
+
+// SetHealthChecker makes Resolve skip targets that are failing their health
+// checks.
+func (s *GeoIPStrategy) SetHealthChecker(health HealthChecker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = health
+}
+
+// Resolve returns the target for the client's location on the most specific
+// route matching the FQDN, or the route's fallback Target if no location
+// matches.
+func (s *GeoIPStrategy) Resolve(ctx context.Context, fqdn string) (string, error) {
+	route, _, ok := s.routes.Match(fqdn)
+	if !ok {
+		return "", errors.New("route not found")
+	}
+
+	s.mu.RLock()
+	locator, health := s.locator, s.health
+	s.mu.RUnlock()
+
+	var loc GeoLocation
+	if addr, ok := ClientAddrFromContext(ctx); ok && locator != nil {
+		if ip, ok := addrIP(addr); ok {
+			loc = locator.Locate(ip)
+		}
+	}
+
+	if target := route.pick(loc, health); target != "" {
+		return target, nil
+	}
+	return "", errors.New("no target for client location")
+}
+
+// MatchRoute returns the most specific route pattern matching the FQDN.
+func (s *GeoIPStrategy) MatchRoute(fqdn string) (string, bool) {
+	_, pattern, ok := s.routes.Match(fqdn)
+	return pattern, ok
+}
+
+// pick returns the most specific healthy target for the location, falling
+// back to less specific ones and then the route's fallback. If none is
+// healthy it uses the most specific one anyway, like simple routes do.
+func (r *geoRoute) pick(loc GeoLocation, health HealthChecker) string {
+	var candidates []string
+	if target, ok := r.targets.ASNs[loc.ASN]; ok && loc.ASN != 0 {
+		candidates = append(candidates, target)
+	}
+	if target, ok := r.targets.Countries[loc.Country]; ok && loc.Country != "" {
+		candidates = append(candidates, target)
+	}
+	if target, ok := r.targets.Continents[loc.Continent]; ok && loc.Continent != "" {
+		candidates = append(candidates, target)
+	}
+	if r.fallback != "" {
+		candidates = append(candidates, r.fallback)
+	}
+
+	for _, target := range candidates {
+		if health == nil || health.Healthy(target) {
+			return target
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(clientIP(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// SetRoute stores a GeoIP route. Country and continent codes are matched
+// case-insensitively. It fails if the pattern or a code is invalid, or if the
+// route has no targets at all.
+func (s *GeoIPStrategy) SetRoute(route Route) error {
+	if err := ValidatePattern(route.FQDN); err != nil {
+		return err
+	}
+
+	var targets GeoTargets
+	if route.Geo != nil {
+		targets.ASNs = make(map[uint32]string, len(route.Geo.ASNs))
+		for asn, target := range route.Geo.ASNs {
+			if asn == 0 || target == "" {
+				return fmt.Errorf("invalid ASN target %d -> %q", asn, target)
+			}
+			targets.ASNs[asn] = target
+		}
+		targets.Countries = make(map[string]string, len(route.Geo.Countries))
+		for code, target := range route.Geo.Countries {
+			code = strings.ToUpper(code)
+			if len(code) != 2 || target == "" {
+				return fmt.Errorf("invalid country target %q -> %q", code, target)
+			}
+			targets.Countries[code] = target
+		}
+		targets.Continents = make(map[string]string, len(route.Geo.Continents))
+		for code, target := range route.Geo.Continents {
+			code = strings.ToUpper(code)
+			if !continentCodes[code] || target == "" {
+				return fmt.Errorf("invalid continent target %q -> %q", code, target)
+			}
+			targets.Continents[code] = target
+		}
+	}
+	if route.Target == "" && len(targets.ASNs)+len(targets.Countries)+len(targets.Continents) == 0 {
+		return errors.New("route has no target")
+	}
+
+	s.routes.Set(route.FQDN, &geoRoute{targets: targets, fallback: route.Target})
+	return nil
+}
+
+// RemoveRoute deletes the route for the FQDN and reports whether it existed.
+func (s *GeoIPStrategy) RemoveRoute(fqdn string) bool {
+	return s.routes.Delete(fqdn)
+}
+
+// Targets returns every target of every route, including fallbacks, for
+// health checking.
+func (s *GeoIPStrategy) Targets() []string {
+	seen := make(map[string]bool)
+	var targets []string
+	add := func(target string) {
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, r := range s.routes.Entries() {
+		for _, target := range r.targets.ASNs {
+			add(target)
+		}
+		for _, target := range r.targets.Countries {
+			add(target)
+		}
+		for _, target := range r.targets.Continents {
+			add(target)
+		}
+		add(r.fallback)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Routes returns a snapshot of all configured routes.
+func (s *GeoIPStrategy) Routes() []Route {
+	entries := s.routes.Entries()
+
+	routes := make([]Route, 0, len(entries))
+	for fqdn, r := range entries {
+		targets := r.targets
+		routes = append(routes, Route{FQDN: fqdn, Type: StrategyGeoIP, Target: r.fallback, Geo: &targets})
+	}
+	return routes
+}
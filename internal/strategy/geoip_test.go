@@ -0,0 +1,129 @@
+package strategy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+type mapLocator map[netip.Addr]GeoLocation
+
+func (m mapLocator) Locate(ip netip.Addr) GeoLocation {
+	return m[ip]
+}
+
+func TestGeoIPStrategy(t *testing.T) {
+	s := NewGeoIPStrategy()
+	err := s.SetRoute(Route{
+		FQDN:   "play.example.com",
+		Type:   StrategyGeoIP,
+		Target: "default:7777",
+		Geo: &GeoTargets{
+			ASNs:       map[uint32]string{64500: "partner:7777"},
+			Countries:  map[string]string{"ca": "canada:7777"},
+			Continents: map[string]string{"EU": "eu:7777", "NA": "na:7777"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetRoute() error = %v", err)
+	}
+	s.SetLocator(mapLocator{
+		netip.MustParseAddr("192.0.2.1"):   {Country: "DE", Continent: "EU"},
+		netip.MustParseAddr("192.0.2.2"):   {Country: "US", Continent: "NA"},
+		netip.MustParseAddr("192.0.2.3"):   {Country: "CA", Continent: "NA"},
+		netip.MustParseAddr("192.0.2.4"):   {Country: "US", Continent: "NA", ASN: 64500},
+		netip.MustParseAddr("2001:db8::1"): {Country: "FR", Continent: "EU"},
+	})
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "eu:7777"},
+		{"192.0.2.2", "na:7777"},
+		{"192.0.2.3", "canada:7777"},
+		{"192.0.2.4", "partner:7777"},
+		{"::ffff:192.0.2.1", "eu:7777"},
+		{"2001:db8::1", "eu:7777"},
+		{"198.51.100.1", "default:7777"},
+	}
+	for _, tt := range tests {
+		ctx := WithRequest(context.Background(), Request{ClientAddr: &net.UDPAddr{IP: net.ParseIP(tt.ip), Port: 4433}, SNI: "play.example.com"})
+		if got, err := s.Resolve(ctx, "play.example.com"); err != nil || got != tt.want {
+			t.Errorf("Resolve() for %s = %q, %v, want %s", tt.ip, got, err, tt.want)
+		}
+	}
+
+	// Without a client address the fallback is used.
+	if got, err := s.Resolve(context.Background(), "play.example.com"); err != nil || got != "default:7777" {
+		t.Errorf("Resolve() without a request = %q, %v", got, err)
+	}
+	if _, err := s.Resolve(context.Background(), "other.example.com"); err == nil {
+		t.Error("Expected an unknown FQDN to fail")
+	}
+}
+
+func TestGeoIPStrategyNoFallback(t *testing.T) {
+	s := NewGeoIPStrategy()
+	if err := s.SetRoute(Route{FQDN: "eu.example.com", Geo: &GeoTargets{Continents: map[string]string{"EU": "eu:7777"}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetLocator(mapLocator{})
+	ctx := WithRequest(context.Background(), Request{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}})
+	if _, err := s.Resolve(ctx, "eu.example.com"); err == nil {
+		t.Error("Expected unmatched clients to fail without a fallback")
+	}
+
+	invalid := []Route{
+		{FQDN: "a.example.com"},
+		{FQDN: "a.example.com", Geo: &GeoTargets{Continents: map[string]string{"XX": "x:1"}}},
+		{FQDN: "a.example.com", Geo: &GeoTargets{Countries: map[string]string{"USA": "x:1"}}},
+		{FQDN: "a.example.com", Geo: &GeoTargets{ASNs: map[uint32]string{1: ""}}},
+	}
+	for _, route := range invalid {
+		if err := s.SetRoute(route); err == nil {
+			t.Errorf("Expected %+v to be rejected", route.Geo)
+		}
+	}
+}
+
+func TestGeoIPStrategyHealth(t *testing.T) {
+	s := NewGeoIPStrategy()
+	err := s.SetRoute(Route{
+		FQDN:   "play.example.com",
+		Target: "default:7777",
+		Geo: &GeoTargets{
+			Countries:  map[string]string{"DE": "de:7777"},
+			Continents: map[string]string{"EU": "eu:7777"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLocator(mapLocator{netip.MustParseAddr("192.0.2.1"): {Country: "DE", Continent: "EU"}})
+	ctx := WithRequest(context.Background(), Request{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}})
+
+	if got := s.Targets(); len(got) != 3 || got[0] != "de:7777" || got[1] != "default:7777" || got[2] != "eu:7777" {
+		t.Errorf("Targets() = %v, want every target and the fallback", got)
+	}
+
+	tests := []struct {
+		name string
+		down fakeHealth
+		want string
+	}{
+		{"all healthy", fakeHealth{}, "de:7777"},
+		{"country down", fakeHealth{"de:7777": true}, "eu:7777"},
+		{"region down", fakeHealth{"de:7777": true, "eu:7777": true}, "default:7777"},
+		{"all down", fakeHealth{"de:7777": true, "eu:7777": true, "default:7777": true}, "de:7777"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetHealthChecker(tt.down)
+			if got, err := s.Resolve(ctx, "play.example.com"); err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PatternTable maps host name patterns to values. A pattern is one of:
+//
+//   - an exact name, "game.example.com"
+//   - a name with wildcard labels, "*.eu.example.com", each "*" matching
+//     exactly one label
+//   - a suffix, ".example.com", matching any name with one or more labels
+//     in front of example.com
+//
+// Patterns are stored in a trie keyed by reversed labels, so a lookup walks
+// the name once from its top-level label. When several patterns match, the
+// most specific wins: comparing labels from the right, an exact label beats
+// "*", and "*" beats a suffix match. This ordering is total, so ties are
+// always broken the same way.
+type PatternTable[V any] struct {
+	mu       sync.RWMutex
+	root     *patternNode[V]
+	patterns map[string]V
+}
+
+type patternNode[V any] struct {
+	children map[string]*patternNode[V]
+	wildcard *patternNode[V]
+
+	exact     *V
+	exactKey  string
+	suffix    *V
+	suffixKey string
+}
+
+func newPatternNode[V any]() *patternNode[V] {
+	return &patternNode[V]{children: make(map[string]*patternNode[V])}
+}
+
+func NewPatternTable[V any]() *PatternTable[V] {
+	return &PatternTable[V]{
+		root:     newPatternNode[V](),
+		patterns: make(map[string]V),
+	}
+}
+
+// NormalizePattern lowercases a pattern or host name and strips a trailing dot.
+func NormalizePattern(pattern string) string {
+	return strings.TrimSuffix(strings.ToLower(pattern), ".")
+}
+
+// ValidatePattern reports whether the pattern is a valid exact, wildcard or
+// suffix pattern.
+func ValidatePattern(pattern string) error {
+	pattern = NormalizePattern(pattern)
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+
+	name := strings.TrimPrefix(pattern, ".")
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("pattern %q has an empty label", pattern)
+		}
+		if label != "*" && strings.Contains(label, "*") {
+			return fmt.Errorf("pattern %q: wildcards must span a whole label", pattern)
+		}
+	}
+	return nil
+}
+
+// reversedLabels splits a pattern into labels, top-level label first, and
+// reports whether it is a suffix pattern.
+func reversedLabels(pattern string) ([]string, bool) {
+	name, suffix := strings.CutPrefix(pattern, ".")
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels, suffix
+}
+
+// Set stores the value for the pattern, replacing any previous value.
+func (t *PatternTable[V]) Set(pattern string, value V) {
+	pattern = NormalizePattern(pattern)
+	labels, suffix := reversedLabels(pattern)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.root
+	for _, label := range labels {
+		if label == "*" {
+			if node.wildcard == nil {
+				node.wildcard = newPatternNode[V]()
+			}
+			node = node.wildcard
+			continue
+		}
+		child, ok := node.children[label]
+		if !ok {
+			child = newPatternNode[V]()
+			node.children[label] = child
+		}
+		node = child
+	}
+
+	if suffix {
+		node.suffix, node.suffixKey = &value, pattern
+	} else {
+		node.exact, node.exactKey = &value, pattern
+	}
+	t.patterns[pattern] = value
+}
+
+// Delete removes the pattern and reports whether it existed. Emptied trie
+// nodes are left in place; they cost nothing on lookups that do not reach them.
+func (t *PatternTable[V]) Delete(pattern string) bool {
+	pattern = NormalizePattern(pattern)
+	labels, suffix := reversedLabels(pattern)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.patterns[pattern]; !ok {
+		return false
+	}
+	delete(t.patterns, pattern)
+
+	node := t.root
+	for _, label := range labels {
+		if label == "*" {
+			node = node.wildcard
+		} else {
+			node = node.children[label]
+		}
+	}
+
+	if suffix {
+		node.suffix, node.suffixKey = nil, ""
+	} else {
+		node.exact, node.exactKey = nil, ""
+	}
+	return true
+}
+
+// Get returns the value stored for the exact pattern string.
+func (t *PatternTable[V]) Get(pattern string) (V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	v, ok := t.patterns[NormalizePattern(pattern)]
+	return v, ok
+}
+
+// Match returns the value of the most specific pattern matching the host
+// name, along with that pattern.
+func (t *PatternTable[V]) Match(host string) (V, string, bool) {
+	labels, _ := reversedLabels(NormalizePattern(host))
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if v, key := t.root.match(labels); v != nil {
+		return *v, key, true
+	}
+	var zero V
+	return zero, "", false
+}
+
+func (n *patternNode[V]) match(labels []string) (*V, string) {
+	if len(labels) == 0 {
+		return n.exact, n.exactKey
+	}
+
+	if child, ok := n.children[labels[0]]; ok {
+		if v, key := child.match(labels[1:]); v != nil {
+			return v, key
+		}
+	}
+	if n.wildcard != nil {
+		if v, key := n.wildcard.match(labels[1:]); v != nil {
+			return v, key
+		}
+	}
+	return n.suffix, n.suffixKey
+}
+
+// Entries returns a snapshot of every pattern and its value.
+func (t *PatternTable[V]) Entries() map[string]V {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entries := make(map[string]V, len(t.patterns))
+	for pattern, v := range t.patterns {
+		entries[pattern] = v
+	}
+	return entries
+}
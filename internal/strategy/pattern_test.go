@@ -0,0 +1,114 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestPatternTableMatch(t *testing.T) {
+	table := NewPatternTable[string]()
+	for _, pattern := range []string{
+		"game.example.com",
+		"*.example.com",
+		".example.com",
+		"*.eu.example.com",
+		"lobby.*.example.com",
+		"a.*.b.example.com",
+		"*.c.b.example.com",
+	} {
+		table.Set(pattern, pattern)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"game.example.com", "game.example.com"},
+		{"GAME.Example.com.", "game.example.com"},
+		{"other.example.com", "*.example.com"},
+		{"deep.other.example.com", ".example.com"},
+		{"s1.eu.example.com", "*.eu.example.com"},
+		{"lobby.eu.example.com", "*.eu.example.com"}, // "eu" beats "*" at the second label
+		{"lobby.us.example.com", "lobby.*.example.com"},
+		{"a.c.b.example.com", "*.c.b.example.com"}, // "c" beats "*" before "a" beats "*"
+		{"a.d.b.example.com", "a.*.b.example.com"},
+		{"x.d.b.example.com", ".example.com"},
+		{"example.com", ""},
+		{"example.org", ""},
+	}
+
+	for _, tt := range tests {
+		got, pattern, ok := table.Match(tt.host)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Match(%q) = %q, want no match", tt.host, pattern)
+			}
+			continue
+		}
+		if !ok || got != tt.want || pattern != tt.want {
+			t.Errorf("Match(%q) = %q (%q, %v), want %q", tt.host, got, pattern, ok, tt.want)
+		}
+	}
+}
+
+func TestPatternTableDelete(t *testing.T) {
+	table := NewPatternTable[int]()
+	table.Set("*.example.com", 1)
+	table.Set(".example.com", 2)
+
+	if !table.Delete("*.example.com") {
+		t.Fatal("Expected *.example.com to be deleted")
+	}
+	if v, _, _ := table.Match("a.example.com"); v != 2 {
+		t.Errorf("Expected suffix route after deleting wildcard, got %d", v)
+	}
+	if table.Delete("*.example.com") {
+		t.Error("Expected second delete to report false")
+	}
+	if len(table.Entries()) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(table.Entries()))
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	valid := []string{"example.com", "*.example.com", ".example.com", "a.*.example.com", "example.com."}
+	invalid := []string{"", ".", "a..example.com", "*a.example.com", "a*.example.com", "..example.com"}
+
+	for _, p := range valid {
+		if err := ValidatePattern(p); err != nil {
+			t.Errorf("ValidatePattern(%q) = %v, want nil", p, err)
+		}
+	}
+	for _, p := range invalid {
+		if err := ValidatePattern(p); err == nil {
+			t.Errorf("ValidatePattern(%q) = nil, want error", p)
+		}
+	}
+}
+
+func TestSimpleStrategyWildcard(t *testing.T) {
+	s := NewSimpleStrategy()
+	s.UpdateRoute("*.eu.example.com", "10.0.0.1:7777")
+
+	target, err := s.Resolve(context.Background(), "server-42.eu.example.com")
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if target != "10.0.0.1:7777" {
+		t.Errorf("Expected 10.0.0.1:7777, got %s", target)
+	}
+}
+
+func BenchmarkPatternTableMatch(b *testing.B) {
+	table := NewPatternTable[string]()
+	table.Set("*.eu.example.com", "eu")
+	table.Set(".example.com", "fallback")
+	for i := 0; i < 1000; i++ {
+		table.Set(fmt.Sprintf("game%d.example.com", i), "exact")
+	}
+
+	for b.Loop() {
+		table.Match("server-42.eu.example.com")
+	}
+}
@@ -3,33 +3,127 @@ package strategy
 import (
 	"context"
 	"errors"
+	"log"
+	"sort"
 	"sync"
 )
 
 type SimpleStrategy struct {
-	mu     sync.RWMutex
-	routes map[string]string // FQDN -> target
+	routes *PatternTable[*balancedRoute] // FQDN pattern -> targets
+
+	mu       sync.RWMutex
+	sessions SessionCounter
+	health   HealthChecker
 }
 
 func NewSimpleStrategy() *SimpleStrategy {
 	return &SimpleStrategy{
-		routes: make(map[string]string),
+		routes: NewPatternTable[*balancedRoute](),
 	}
 }
 
-func (s *SimpleStrategy) Resolve(ctx context.Context, fqdn string) (string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// SetSessionCounter provides the active session counts used by the
+// least_sessions algorithm.
+func (s *SimpleStrategy) SetSessionCounter(counter SessionCounter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = counter
+}
+
+// SetHealthChecker makes Resolve skip targets that are failing their health
+// checks.
+func (s *SimpleStrategy) SetHealthChecker(health HealthChecker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = health
+}
 
-	target, ok := s.routes[fqdn]
+// Resolve returns a target of the most specific route matching the FQDN.
+// See PatternTable for the supported wildcard and suffix patterns. Routes with
+// several targets pick one per call using the route's algorithm.
+func (s *SimpleStrategy) Resolve(ctx context.Context, fqdn string) (string, error) {
+	route, _, ok := s.routes.Match(fqdn)
 	if !ok {
 		return "", errors.New("route not found")
 	}
-	return target, nil
+
+	s.mu.RLock()
+	counter, health := s.sessions, s.health
+	s.mu.RUnlock()
+
+	return route.pick(ctx, counter, health), nil
+}
+
+// MatchRoute returns the most specific route pattern matching the FQDN.
+func (s *SimpleStrategy) MatchRoute(fqdn string) (string, bool) {
+	_, pattern, ok := s.routes.Match(fqdn)
+	return pattern, ok
 }
 
 func (s *SimpleStrategy) UpdateRoute(fqdn, target string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.routes[fqdn] = target
+	if err := s.SetRoute(Route{FQDN: fqdn, Type: StrategySimple, Target: target}); err != nil {
+		log.Printf("Ignoring invalid simple route %s -> %s: %v", fqdn, target, err)
+	}
+}
+
+// SetRoute stores a route with either a single Target or a list of weighted
+// Targets. It fails if the pattern, targets or algorithm are invalid.
+func (s *SimpleStrategy) SetRoute(route Route) error {
+	if err := ValidatePattern(route.FQDN); err != nil {
+		return err
+	}
+
+	targets := route.Targets
+	if len(targets) == 0 {
+		if route.Target == "" {
+			return errors.New("route has no target")
+		}
+		targets = []WeightedTarget{{Address: route.Target}}
+	}
+
+	balanced, err := newBalancedRoute(targets, route.Algorithm)
+	if err != nil {
+		return err
+	}
+	s.routes.Set(route.FQDN, balanced)
+	return nil
+}
+
+// RemoveRoute deletes the route for the FQDN and reports whether it existed.
+func (s *SimpleStrategy) RemoveRoute(fqdn string) bool {
+	return s.routes.Delete(fqdn)
+}
+
+// Routes returns a snapshot of all configured routes.
+func (s *SimpleStrategy) Routes() []Route {
+	entries := s.routes.Entries()
+
+	routes := make([]Route, 0, len(entries))
+	for fqdn, balanced := range entries {
+		route := Route{FQDN: fqdn, Type: StrategySimple}
+		if len(balanced.targets) == 1 {
+			route.Target = balanced.targets[0].Address
+		} else {
+			route.Targets = balanced.targets
+			route.Algorithm = balanced.algorithm
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Targets returns every distinct target address used by a route, sorted.
+func (s *SimpleStrategy) Targets() []string {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, balanced := range s.routes.Entries() {
+		for _, t := range balanced.targets {
+			if !seen[t.Address] {
+				seen[t.Address] = true
+				targets = append(targets, t.Address)
+			}
+		}
+	}
+	sort.Strings(targets)
+	return targets
 }
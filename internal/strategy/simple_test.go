@@ -23,3 +23,29 @@ func TestSimpleStrategy(t *testing.T) {
 		t.Error("Expected error for unknown FQDN")
 	}
 }
+
+func TestSimpleStrategyRemoveRoute(t *testing.T) {
+	s := NewSimpleStrategy()
+	s.UpdateRoute("a.com", "1.2.3.4:5000")
+	s.UpdateRoute("b.com", "1.2.3.4:6000")
+
+	if routes := s.Routes(); len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+
+	if !s.RemoveRoute("a.com") {
+		t.Error("Expected a.com to be removed")
+	}
+	if s.RemoveRoute("a.com") {
+		t.Error("Expected second removal of a.com to report false")
+	}
+
+	if _, err := s.Resolve(context.Background(), "a.com"); err == nil {
+		t.Error("Expected error resolving removed route")
+	}
+
+	routes := s.Routes()
+	if len(routes) != 1 || routes[0].FQDN != "b.com" || routes[0].Type != StrategySimple {
+		t.Errorf("Unexpected routes after removal: %+v", routes)
+	}
+}
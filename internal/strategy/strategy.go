@@ -2,6 +2,7 @@ package strategy
 
 import (
 	"context"
+	"net"
 )
 
 type StrategyType string
@@ -9,18 +10,52 @@ type StrategyType string
 const (
 	StrategySimple StrategyType = "simple"
 	StrategyAgones StrategyType = "agones"
+	StrategyGeoIP  StrategyType = "geoip"
 )
 
 type Route struct {
 	FQDN   string       `json:"fqdn"`
 	Type   StrategyType `json:"type"`
-	Target string       `json:"target"` // For simple: ip:port. For agones: fleet name.
+	Target string       `json:"target,omitempty"` // For simple: ip:port. For agones: fleet name.
+
+	// Simple routes may instead list several weighted targets, balanced by Algorithm.
+	Targets   []WeightedTarget `json:"targets,omitempty"`
+	Algorithm Algorithm        `json:"algorithm,omitempty"`
+
+	// GeoIP routes pick a target by client location, falling back to Target.
+	Geo *GeoTargets `json:"geo,omitempty"`
+}
+
+// Request describes the connection a target is being resolved for.
+type Request struct {
+	ClientAddr net.Addr
+	SNI        string
+}
+
+type requestKey struct{}
+
+// WithRequest attaches the connection being resolved to a resolve context.
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the request attached by WithRequest.
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+	return req, ok
 }
 
 type RoutingStrategy interface {
 	Resolve(ctx context.Context, fqdn string) (string, error)
 }
 
+// RouteMatcher is implemented by strategies that can report which of their
+// route patterns an FQDN matches, for labelling metrics without using the
+// client-chosen name.
+type RouteMatcher interface {
+	MatchRoute(fqdn string) (string, bool)
+}
+
 type StrategyManager struct {
 	strategies map[StrategyType]RoutingStrategy
 }
@@ -3,18 +3,61 @@ package sync
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ewancrowle/porter/internal/acl"
 	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
 	"github.com/ewancrowle/porter/internal/strategy"
 	"github.com/redis/go-redis/v9"
 )
 
+type Action string
+
+const (
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// routeEvent is the Pub/Sub payload. Messages without an action are treated
+// as updates so older instances remain compatible.
+type routeEvent struct {
+	Action Action `json:"action,omitempty"`
+	strategy.Route
+}
+
+// aclEvent is the Pub/Sub payload of access policy changes. An empty FQDN
+// addresses the global rules.
+type aclEvent struct {
+	Action Action    `json:"action"`
+	FQDN   string    `json:"fqdn,omitempty"`
+	Rules  acl.Rules `json:"rules"`
+}
+
+const (
+	aclGlobalKey = "porter:acl:global"
+	aclRoutesKey = "porter:acl:routes"
+)
+
 type RedisSync struct {
-	client  *redis.Client
-	channel string
-	simple  *strategy.SimpleStrategy
-	agones  *strategy.AgonesStrategy
+	client     *redis.Client
+	cfg        *config.Config
+	channel    string
+	aclChannel string
+	simple     *strategy.SimpleStrategy
+	agones     *strategy.AgonesStrategy
+	geoip      *strategy.GeoIPStrategy // nil unless geoip routes are synced
+	policy     *acl.Policy             // nil unless access policy changes are synced
+
+	mu         sync.Mutex
+	pubsub     *redis.PubSub
+	subscribed atomic.Bool // Subscription confirmed by Redis
+	loaded     atomic.Bool // LoadInitialRoutes succeeded
 }
 
 func NewRedisSync(cfg *config.Config, simple *strategy.SimpleStrategy, agones *strategy.AgonesStrategy) *RedisSync {
@@ -29,13 +72,33 @@ func NewRedisSync(cfg *config.Config, simple *strategy.SimpleStrategy, agones *s
 	})
 
 	return &RedisSync{
-		client:  client,
-		channel: cfg.Redis.Channel,
-		simple:  simple,
-		agones:  agones,
+		client:     client,
+		cfg:        cfg,
+		channel:    cfg.Redis.Channel,
+		aclChannel: cfg.Redis.ACLChannel,
+		simple:     simple,
+		agones:     agones,
 	}
 }
 
+// SetGeoIPStrategy persists and syncs geoip routes too. It must be called
+// before LoadInitialRoutes and Subscribe.
+func (s *RedisSync) SetGeoIPStrategy(geoip *strategy.GeoIPStrategy) {
+	if s == nil {
+		return
+	}
+	s.geoip = geoip
+}
+
+// SetAccessPolicy shares access policy changes through Redis. It must be
+// called before LoadInitialRoutes and Subscribe.
+func (s *RedisSync) SetAccessPolicy(policy *acl.Policy) {
+	if s == nil {
+		return
+	}
+	s.policy = policy
+}
+
 func (s *RedisSync) LoadInitialRoutes(ctx context.Context) error {
 	if s == nil {
 		return nil
@@ -44,16 +107,25 @@ func (s *RedisSync) LoadInitialRoutes(ctx context.Context) error {
 	// Load Simple routes from a Redis Hash "porter:routes:simple"
 	simpleRoutes, err := s.client.HGetAll(ctx, "porter:routes:simple").Result()
 	if err != nil {
+		metrics.RedisErrors.WithLabelValues("load").Inc()
 		return err
 	}
-	for fqdn, target := range simpleRoutes {
-		s.simple.UpdateRoute(fqdn, target)
-		log.Printf("Loaded route from Redis: %s -> %s (simple)", fqdn, target)
+	for fqdn, value := range simpleRoutes {
+		route, err := decodeSimpleRoute(fqdn, value)
+		if err == nil {
+			err = s.simple.SetRoute(route)
+		}
+		if err != nil {
+			log.Printf("Warning: skipping route %s from Redis: %v", fqdn, err)
+			continue
+		}
+		log.Printf("Loaded route from Redis: %s -> %s (simple)", fqdn, value)
 	}
 
 	// Load Agones routes from a Redis Hash "porter:routes:agones"
 	agonesRoutes, err := s.client.HGetAll(ctx, "porter:routes:agones").Result()
 	if err != nil {
+		metrics.RedisErrors.WithLabelValues("load").Inc()
 		return err
 	}
 	for fqdn, fleet := range agonesRoutes {
@@ -61,6 +133,103 @@ func (s *RedisSync) LoadInitialRoutes(ctx context.Context) error {
 		log.Printf("Loaded route from Redis: %s -> %s (agones)", fqdn, fleet)
 	}
 
+	// Load GeoIP routes from a Redis Hash "porter:routes:geoip"
+	if s.geoip != nil {
+		geoRoutes, err := s.client.HGetAll(ctx, "porter:routes:geoip").Result()
+		if err != nil {
+			metrics.RedisErrors.WithLabelValues("load").Inc()
+			return err
+		}
+		for fqdn, value := range geoRoutes {
+			route, err := decodeGeoRoute(fqdn, value)
+			if err == nil {
+				err = s.geoip.SetRoute(route)
+			}
+			if err != nil {
+				log.Printf("Warning: skipping route %s from Redis: %v", fqdn, err)
+				continue
+			}
+			log.Printf("Loaded route from Redis: %s (geoip)", fqdn)
+		}
+	}
+
+	if err := s.loadACL(ctx); err != nil {
+		metrics.RedisErrors.WithLabelValues("load").Inc()
+		return err
+	}
+
+	s.loaded.Store(true)
+	return nil
+}
+
+// loadACL replaces the configured access rules with those stored in Redis.
+func (s *RedisSync) loadACL(ctx context.Context) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	global, err := s.client.Get(ctx, aclGlobalKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if err == nil {
+		var rules acl.Rules
+		if err := json.Unmarshal([]byte(global), &rules); err == nil {
+			err = s.policy.SetGlobal(rules)
+		}
+		if err != nil {
+			log.Printf("Warning: skipping global access rules from Redis: %v", err)
+		} else {
+			log.Printf("Loaded global access rules from Redis")
+		}
+	}
+
+	routes, err := s.client.HGetAll(ctx, aclRoutesKey).Result()
+	if err != nil {
+		return err
+	}
+	for fqdn, value := range routes {
+		var rules acl.Rules
+		if err := json.Unmarshal([]byte(value), &rules); err == nil {
+			err = s.policy.SetRoute(fqdn, rules)
+		}
+		if err != nil {
+			log.Printf("Warning: skipping access rules for %s from Redis: %v", fqdn, err)
+			continue
+		}
+		log.Printf("Loaded access rules from Redis: %s", fqdn)
+	}
+	return nil
+}
+
+// RoutesLoaded reports whether LoadInitialRoutes has succeeded.
+func (s *RedisSync) RoutesLoaded() bool {
+	return s == nil || s.loaded.Load()
+}
+
+// Check reports whether Redis is reachable and the route update subscription
+// is active.
+func (s *RedisSync) Check(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis unreachable: %w", err)
+	}
+	if !s.subscribed.Load() {
+		return fmt.Errorf("not subscribed to channel %s", s.channel)
+	}
+
+	s.mu.Lock()
+	pubsub := s.pubsub
+	s.mu.Unlock()
+	if pubsub == nil {
+		return errors.New("subscription closed")
+	}
+	if err := pubsub.Ping(ctx); err != nil {
+		return fmt.Errorf("subscription connection: %w", err)
+	}
 	return nil
 }
 
@@ -69,19 +238,159 @@ func (s *RedisSync) PublishUpdate(ctx context.Context, route strategy.Route) err
 		return nil
 	}
 
-	data, err := json.Marshal(route)
+	data, err := json.Marshal(routeEvent{Action: ActionUpdate, Route: route})
+	if err != nil {
+		return err
+	}
+
+	value, err := encodeRouteValue(route)
 	if err != nil {
 		return err
 	}
 
 	// Persist in Hash
 	key := "porter:routes:" + string(route.Type)
-	if err := s.client.HSet(ctx, key, route.FQDN, route.Target).Err(); err != nil {
+	if err := s.client.HSet(ctx, key, route.FQDN, value).Err(); err != nil {
+		metrics.RedisErrors.WithLabelValues("publish").Inc()
 		return err
 	}
 
 	// Publish message
-	return s.client.Publish(ctx, s.channel, data).Err()
+	return s.publish(ctx, data)
+}
+
+// targetsValue is the hash value of a route with several weighted targets.
+// Routes with a single target keep storing the bare target string.
+type targetsValue struct {
+	Targets   []strategy.WeightedTarget `json:"targets"`
+	Algorithm strategy.Algorithm        `json:"algorithm,omitempty"`
+}
+
+// geoValue is the hash value of a geoip route.
+type geoValue struct {
+	Target string               `json:"target,omitempty"`
+	Geo    *strategy.GeoTargets `json:"geo,omitempty"`
+}
+
+func encodeRouteValue(route strategy.Route) (string, error) {
+	if route.Type == strategy.StrategyGeoIP {
+		data, err := json.Marshal(geoValue{Target: route.Target, Geo: route.Geo})
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if len(route.Targets) == 0 {
+		return route.Target, nil
+	}
+	data, err := json.Marshal(targetsValue{Targets: route.Targets, Algorithm: route.Algorithm})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeSimpleRoute(fqdn, value string) (strategy.Route, error) {
+	route := strategy.Route{FQDN: fqdn, Type: strategy.StrategySimple}
+	if !strings.HasPrefix(value, "{") {
+		route.Target = value
+		return route, nil
+	}
+
+	var v targetsValue
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return route, err
+	}
+	route.Targets = v.Targets
+	route.Algorithm = v.Algorithm
+	return route, nil
+}
+
+func decodeGeoRoute(fqdn, value string) (strategy.Route, error) {
+	var v geoValue
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return strategy.Route{}, err
+	}
+	return strategy.Route{FQDN: fqdn, Type: strategy.StrategyGeoIP, Target: v.Target, Geo: v.Geo}, nil
+}
+
+func (s *RedisSync) publish(ctx context.Context, data []byte) error {
+	return s.publishTo(ctx, s.channel, data)
+}
+
+func (s *RedisSync) publishTo(ctx context.Context, channel string, data []byte) error {
+	if err := s.client.Publish(ctx, channel, data).Err(); err != nil {
+		metrics.RedisErrors.WithLabelValues("publish").Inc()
+		return err
+	}
+	return nil
+}
+
+// PublishDelete removes the route from its persistence hash and notifies
+// other instances to drop it.
+func (s *RedisSync) PublishDelete(ctx context.Context, route strategy.Route) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(routeEvent{Action: ActionDelete, Route: route})
+	if err != nil {
+		return err
+	}
+
+	key := "porter:routes:" + string(route.Type)
+	if err := s.client.HDel(ctx, key, route.FQDN).Err(); err != nil {
+		metrics.RedisErrors.WithLabelValues("publish").Inc()
+		return err
+	}
+
+	return s.publish(ctx, data)
+}
+
+// PublishACL persists the access rules of an FQDN pattern, or the global
+// rules if fqdn is empty, and notifies other instances.
+func (s *RedisSync) PublishACL(ctx context.Context, fqdn string, rules acl.Rules) error {
+	if s == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(aclEvent{Action: ActionUpdate, FQDN: fqdn, Rules: rules})
+	if err != nil {
+		return err
+	}
+
+	if fqdn == "" {
+		err = s.client.Set(ctx, aclGlobalKey, value, 0).Err()
+	} else {
+		err = s.client.HSet(ctx, aclRoutesKey, fqdn, value).Err()
+	}
+	if err != nil {
+		metrics.RedisErrors.WithLabelValues("publish").Inc()
+		return err
+	}
+	return s.publishTo(ctx, s.aclChannel, data)
+}
+
+// PublishACLDelete removes the access rules of an FQDN pattern and notifies
+// other instances to drop them.
+func (s *RedisSync) PublishACLDelete(ctx context.Context, fqdn string) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(aclEvent{Action: ActionDelete, FQDN: fqdn})
+	if err != nil {
+		return err
+	}
+	if err := s.client.HDel(ctx, aclRoutesKey, fqdn).Err(); err != nil {
+		metrics.RedisErrors.WithLabelValues("publish").Inc()
+		return err
+	}
+	return s.publishTo(ctx, s.aclChannel, data)
 }
 
 func (s *RedisSync) Subscribe(ctx context.Context) {
@@ -89,22 +398,121 @@ func (s *RedisSync) Subscribe(ctx context.Context) {
 		return
 	}
 
-	pubsub := s.client.Subscribe(ctx, s.channel)
-	defer pubsub.Close()
+	channels := []string{s.channel}
+	if s.policy != nil {
+		channels = append(channels, s.aclChannel)
+	}
+	pubsub := s.client.Subscribe(ctx, channels...)
+	s.mu.Lock()
+	s.pubsub = pubsub
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.pubsub = nil
+		s.mu.Unlock()
+		s.subscribed.Store(false)
+		pubsub.Close()
+	}()
 
-	ch := pubsub.Channel()
-	for msg := range ch {
-		var route strategy.Route
-		if err := json.Unmarshal([]byte(msg.Payload), &route); err != nil {
-			log.Printf("Error unmarshaling sync message: %v", err)
-			continue
+	// Wait for the subscription to be confirmed so failures are visible.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		metrics.RedisErrors.WithLabelValues("subscribe").Inc()
+		log.Printf("Error subscribing to Redis channel %s: %v", s.channel, err)
+	} else {
+		s.subscribed.Store(true)
+	}
+
+	// Subscriptions are delivered too, confirming resubscribes after a
+	// reconnect.
+	ch := pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				if msg.Kind == "subscribe" {
+					s.subscribed.Store(true)
+				}
+			case *redis.Message:
+				if msg.Channel == s.aclChannel && s.policy != nil {
+					s.handleACLMessage(msg)
+				} else {
+					s.handleMessage(msg)
+				}
+			}
 		}
+	}
+}
+
+func (s *RedisSync) handleMessage(msg *redis.Message) {
+	var event routeEvent
+	if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+		metrics.RedisErrors.WithLabelValues("subscribe").Inc()
+		log.Printf("Error unmarshaling sync message: %v", err)
+		return
+	}
+	route := event.Route
 
-		log.Printf("Syncing route update from Redis: %s -> %s (%s)", route.FQDN, route.Target, route.Type)
+	if event.Action == ActionDelete {
+		log.Printf("Syncing route deletion from Redis: %s (%s)", route.FQDN, route.Type)
 		if route.Type == strategy.StrategySimple {
-			s.simple.UpdateRoute(route.FQDN, route.Target)
+			s.simple.RemoveRoute(route.FQDN)
 		} else if route.Type == strategy.StrategyAgones {
-			s.agones.UpdateRoute(route.FQDN, route.Target)
+			s.agones.RemoveRoute(route.FQDN)
+		} else if route.Type == strategy.StrategyGeoIP && s.geoip != nil {
+			s.geoip.RemoveRoute(route.FQDN)
+		}
+		return
+	}
+
+	log.Printf("Syncing route update from Redis: %s -> %s (%s)", route.FQDN, route.Target, route.Type)
+	if route.Type == strategy.StrategySimple {
+		if err := s.simple.SetRoute(route); err != nil {
+			log.Printf("Error applying synced route %s: %v", route.FQDN, err)
+		}
+	} else if route.Type == strategy.StrategyAgones {
+		s.agones.UpdateRoute(route.FQDN, route.Target)
+	} else if route.Type == strategy.StrategyGeoIP && s.geoip != nil {
+		if err := s.geoip.SetRoute(route); err != nil {
+			log.Printf("Error applying synced route %s: %v", route.FQDN, err)
 		}
 	}
 }
+
+func (s *RedisSync) handleACLMessage(msg *redis.Message) {
+	var event aclEvent
+	if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+		metrics.RedisErrors.WithLabelValues("subscribe").Inc()
+		log.Printf("Error unmarshaling access policy message: %v", err)
+		return
+	}
+
+	var err error
+	switch {
+	case event.FQDN == "":
+		log.Printf("Syncing global access rules from Redis")
+		err = s.policy.SetGlobal(event.Rules)
+	case event.Action == ActionDelete:
+		log.Printf("Syncing access rules deletion from Redis: %s", event.FQDN)
+		s.policy.RemoveRoute(event.FQDN)
+	default:
+		log.Printf("Syncing access rules from Redis: %s", event.FQDN)
+		err = s.policy.SetRoute(event.FQDN, event.Rules)
+	}
+	if err != nil {
+		log.Printf("Error applying synced access rules: %v", err)
+	}
+}
+
+// Close closes the Redis client. Subscribe must have returned first.
+func (s *RedisSync) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.client.Close()
+}
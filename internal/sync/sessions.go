@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/relay"
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionDirectory stores relay sessions in Redis so that any Porter replica
+// can adopt a session when a client's packets reach it. Each connection ID is
+// a key "porter:sessions:<hex id>" holding the JSON session entry.
+type SessionDirectory struct {
+	client sessionClient
+	ttl    time.Duration
+}
+
+// sessionClient is the part of the Redis client the session directory uses.
+type sessionClient interface {
+	Pipeline() redis.Pipeliner
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+}
+
+// SessionDirectory returns the session directory, or nil if session sharing
+// is disabled.
+func (s *RedisSync) SessionDirectory() *SessionDirectory {
+	if s == nil || !s.cfg.Redis.Sessions.Enabled {
+		return nil
+	}
+	return &SessionDirectory{client: s.client, ttl: s.cfg.Redis.Sessions.TTL}
+}
+
+func sessionKey(id string) string {
+	return "porter:sessions:" + hex.EncodeToString([]byte(id))
+}
+
+func (d *SessionDirectory) Store(ctx context.Context, ids []string, entry relay.SessionEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	pipe := d.client.Pipeline()
+	for _, id := range ids {
+		pipe.Set(ctx, sessionKey(id), data, d.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		metrics.RedisErrors.WithLabelValues("session_store").Inc()
+		return err
+	}
+	return nil
+}
+
+func (d *SessionDirectory) Lookup(ctx context.Context, ids []string) (string, *relay.SessionEntry, error) {
+	if len(ids) == 0 {
+		return "", nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKey(id)
+	}
+	values, err := d.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		metrics.RedisErrors.WithLabelValues("session_lookup").Inc()
+		return "", nil, err
+	}
+
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var entry relay.SessionEntry
+		if err := json.Unmarshal([]byte(str), &entry); err != nil {
+			metrics.RedisErrors.WithLabelValues("session_lookup").Inc()
+			return "", nil, err
+		}
+		return ids[i], &entry, nil
+	}
+	return "", nil, nil
+}
+
+func (d *SessionDirectory) Delete(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKey(id)
+	}
+	if err := d.client.Del(ctx, keys...).Err(); err != nil {
+		metrics.RedisErrors.WithLabelValues("session_delete").Inc()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ewancrowle/porter/internal/config"
+	"github.com/ewancrowle/porter/internal/metrics"
+	"github.com/ewancrowle/porter/internal/relay"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory stand-in for the commands the session directory
+// sends, recording the TTL of every key.
+type fakeRedis struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error // Returned by every command if set
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+}
+
+func (f *fakeRedis) Pipeline() redis.Pipeliner {
+	return &fakePipeline{redis: f}
+}
+
+func (f *fakeRedis) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	if f.err != nil {
+		return redis.NewSliceResult(nil, f.err)
+	}
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		if value, ok := f.values[key]; ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+func (f *fakeRedis) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	if f.err != nil {
+		return redis.NewIntResult(0, f.err)
+	}
+	var n int64
+	for _, key := range keys {
+		if _, ok := f.values[key]; ok {
+			delete(f.values, key)
+			delete(f.ttls, key)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+// fakePipeline queues SET commands until Exec. Other commands are not
+// implemented.
+type fakePipeline struct {
+	redis.Pipeliner
+	redis *fakeRedis
+	sets  []func()
+}
+
+func (p *fakePipeline) Set(ctx context.Context, key string, value any, ttl time.Duration) *redis.StatusCmd {
+	data := string(value.([]byte))
+	p.sets = append(p.sets, func() {
+		p.redis.values[key] = data
+		p.redis.ttls[key] = ttl
+	})
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (p *fakePipeline) Exec(ctx context.Context) ([]redis.Cmder, error) {
+	if p.redis.err != nil {
+		return nil, p.redis.err
+	}
+	for _, set := range p.sets {
+		set()
+	}
+	p.sets = nil
+	return nil, nil
+}
+
+func testEntry() relay.SessionEntry {
+	return relay.SessionEntry{
+		Session:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Target:    "10.0.0.5:7777",
+		SNI:       "game.example.com",
+		CIDLength: 8,
+	}
+}
+
+func TestSessionDirectoryStore(t *testing.T) {
+	fake := newFakeRedis()
+	d := &SessionDirectory{client: fake, ttl: 10 * time.Minute}
+	ctx := context.Background()
+
+	ids := []string{"\x01\x02\x03\x04", "\xaa\xbb"}
+	if err := d.Store(ctx, ids, testEntry()); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	for _, key := range []string{"porter:sessions:01020304", "porter:sessions:aabb"} {
+		if _, ok := fake.values[key]; !ok {
+			t.Errorf("Expected key %s, have %v", key, fake.values)
+		}
+		if got := fake.ttls[key]; got != 10*time.Minute {
+			t.Errorf("TTL of %s = %v, want 10m", key, got)
+		}
+	}
+
+	// Storing again refreshes the TTL of every key.
+	fake.ttls["porter:sessions:01020304"] = time.Second
+	if err := d.Store(ctx, ids[:1], testEntry()); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if got := fake.ttls["porter:sessions:01020304"]; got != 10*time.Minute {
+		t.Errorf("TTL after refresh = %v, want 10m", got)
+	}
+}
+
+func TestSessionDirectoryLookup(t *testing.T) {
+	fake := newFakeRedis()
+	d := &SessionDirectory{client: fake, ttl: time.Minute}
+	ctx := context.Background()
+
+	if id, entry, err := d.Lookup(ctx, []string{"\x01\x02"}); id != "" || entry != nil || err != nil {
+		t.Errorf("Lookup() of a missing key = %q, %v, %v, want nothing", id, entry, err)
+	}
+	if id, entry, err := d.Lookup(ctx, nil); id != "" || entry != nil || err != nil {
+		t.Errorf("Lookup() without IDs = %q, %v, %v, want nothing", id, entry, err)
+	}
+
+	if err := d.Store(ctx, []string{"\x03\x04"}, testEntry()); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	id, entry, err := d.Lookup(ctx, []string{"\x01\x02", "\x03\x04"})
+	if err != nil || id != "\x03\x04" || entry == nil || !reflect.DeepEqual(*entry, testEntry()) {
+		t.Errorf("Lookup() = %x, %+v, %v, want %x, %+v", id, entry, err, "\x03\x04", testEntry())
+	}
+
+	fake.values["porter:sessions:0506"] = "not json"
+	before := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("session_lookup"))
+	if _, _, err := d.Lookup(ctx, []string{"\x05\x06"}); err == nil {
+		t.Error("Expected a corrupt entry to fail")
+	}
+	if got := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("session_lookup")) - before; got != 1 {
+		t.Errorf("Counted %v lookup errors, want 1", got)
+	}
+}
+
+func TestSessionDirectoryDelete(t *testing.T) {
+	fake := newFakeRedis()
+	d := &SessionDirectory{client: fake, ttl: time.Minute}
+	ctx := context.Background()
+
+	ids := []string{"\x01\x02", "\x03\x04"}
+	if err := d.Store(ctx, append(ids, "\x05\x06"), testEntry()); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if err := d.Delete(ctx, ids); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if len(fake.values) != 1 {
+		t.Errorf("Keys after Delete() = %v, want only porter:sessions:0506", fake.values)
+	}
+	if id, _, _ := d.Lookup(ctx, ids); id != "" {
+		t.Errorf("Lookup() after Delete() found %x", id)
+	}
+
+	fake.err = errors.New("connection refused")
+	before := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("session_delete"))
+	if err := d.Delete(ctx, ids); err == nil {
+		t.Error("Expected Delete() to fail")
+	}
+	if got := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues("session_delete")) - before; got != 1 {
+		t.Errorf("Counted %v delete errors, want 1", got)
+	}
+}
+
+func TestRedisSyncSessionDirectory(t *testing.T) {
+	var s *RedisSync
+	if d := s.SessionDirectory(); d != nil {
+		t.Error("Expected no directory without Redis")
+	}
+
+	cfg := &config.Config{}
+	s = &RedisSync{cfg: cfg, client: redis.NewClient(&redis.Options{})}
+	if d := s.SessionDirectory(); d != nil {
+		t.Error("Expected no directory with session sharing disabled")
+	}
+
+	cfg.Redis.Sessions.Enabled = true
+	cfg.Redis.Sessions.TTL = 5 * time.Minute
+	d := s.SessionDirectory()
+	if d == nil || d.ttl != 5*time.Minute {
+		t.Errorf("SessionDirectory() = %+v, want a directory with a 5m TTL", d)
+	}
+}
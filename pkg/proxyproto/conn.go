@@ -0,0 +1,103 @@
+package proxyproto
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// PacketConn wraps a socket that receives datagrams relayed by Porter. It
+// strips PROXY headers and remembers the client address announced for each
+// relay address, so that a header sent only with the first datagram of a
+// session applies to the datagrams that follow. Replies must still be sent
+// to the relay address returned by ReadFrom.
+type PacketConn struct {
+	net.PacketConn
+
+	trusted []netip.Prefix // Relays allowed to send headers, empty trusts every sender
+
+	mu      sync.RWMutex
+	clients map[string]*net.UDPAddr // Relay address -> client address
+}
+
+func NewPacketConn(conn net.PacketConn) *PacketConn {
+	return &PacketConn{
+		PacketConn: conn,
+		clients:    make(map[string]*net.UDPAddr),
+	}
+}
+
+// SetTrustedRelays only accepts PROXY headers from senders within the
+// prefixes, normally the addresses of the Porter replicas. Without it any
+// sender that reaches the socket can claim any client address. It must be
+// called before ReadFrom.
+func (c *PacketConn) SetTrustedRelays(relays ...netip.Prefix) {
+	c.trusted = relays
+}
+
+// trustedRelay reports whether the sender may send PROXY headers.
+func (c *PacketConn) trustedRelay(addr net.Addr) bool {
+	if len(c.trusted) == 0 {
+		return true
+	}
+	var ip netip.Addr
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		ip = udp.AddrPort().Addr()
+	} else if addrPort, err := netip.ParseAddrPort(addr.String()); err == nil {
+		ip = addrPort.Addr()
+	}
+	ip = ip.Unmap()
+	for _, prefix := range c.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFrom reads the next datagram, with any PROXY header removed. The
+// returned address is the relay's. Datagrams with malformed headers, or with
+// headers from untrusted senders, are skipped.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+
+		header, payload, err := Parse(p[:n])
+		if errors.Is(err, ErrNoHeader) {
+			return n, addr, nil
+		}
+		if err != nil || !c.trustedRelay(addr) {
+			continue
+		}
+
+		if header.Source != nil {
+			c.mu.Lock()
+			c.clients[addr.String()] = header.Source
+			c.mu.Unlock()
+		}
+		return copy(p, payload), addr, nil
+	}
+}
+
+// ClientAddr returns the client address announced for datagrams from the
+// relay address, or the relay address itself if none was announced.
+func (c *PacketConn) ClientAddr(relay net.Addr) net.Addr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if client, ok := c.clients[relay.String()]; ok {
+		return client
+	}
+	return relay
+}
+
+// Forget drops the client address of a relay address. Servers should call it
+// when the connection from that address closes.
+func (c *PacketConn) Forget(relay net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, relay.String())
+}
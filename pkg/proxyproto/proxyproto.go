@@ -0,0 +1,130 @@
+// Package proxyproto encodes and decodes PROXY protocol version 2 headers on
+// UDP datagrams.
+//
+// Porter can prepend a header carrying the player's address to the datagrams
+// it relays. Game servers written in Go can wrap their socket in a PacketConn
+// to strip the headers and look up the player's address:
+//
+//	conn := proxyproto.NewPacketConn(udpConn)
+//	conn.SetTrustedRelays(porterPrefix)
+//	n, relayAddr, err := conn.ReadFrom(buf)
+//	player := conn.ClientAddr(relayAddr)
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Signature starts every version 2 header.
+var Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	CommandLocal byte = 0x0
+	CommandProxy byte = 0x1
+
+	version2 = 0x2
+
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+
+	transportDgram = 0x2
+)
+
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Header is a decoded PROXY protocol header. Source and Destination are nil
+// for LOCAL headers and for address families other than IPv4 and IPv6.
+type Header struct {
+	Command     byte
+	Source      *net.UDPAddr
+	Destination *net.UDPAddr
+}
+
+// AppendHeader appends a PROXY header for a datagram sent by src to dst. IPv4
+// and IPv4-mapped IPv6 sources produce an IPv4 header.
+func AppendHeader(b []byte, src, dst *net.UDPAddr) []byte {
+	b = append(b, Signature...)
+	b = append(b, version2<<4|CommandProxy)
+
+	if src4 := src.IP.To4(); src4 != nil {
+		dst4 := dst.IP.To4()
+		if dst4 == nil {
+			dst4 = net.IPv4zero.To4()
+		}
+		b = append(b, familyInet<<4|transportDgram)
+		b = binary.BigEndian.AppendUint16(b, 12)
+		b = append(b, src4...)
+		b = append(b, dst4...)
+	} else {
+		dst6 := dst.IP.To16()
+		if dst6 == nil {
+			dst6 = net.IPv6zero
+		}
+		b = append(b, familyInet6<<4|transportDgram)
+		b = binary.BigEndian.AppendUint16(b, 36)
+		b = append(b, src.IP.To16()...)
+		b = append(b, dst6...)
+	}
+
+	b = binary.BigEndian.AppendUint16(b, uint16(src.Port))
+	return binary.BigEndian.AppendUint16(b, uint16(dst.Port))
+}
+
+// Parse splits a datagram into its PROXY header and payload. It returns
+// ErrNoHeader if the datagram does not start with the signature.
+func Parse(data []byte) (*Header, []byte, error) {
+	if len(data) < len(Signature) || !bytes.Equal(data[:len(Signature)], Signature) {
+		return nil, data, ErrNoHeader
+	}
+	if len(data) < 16 {
+		return nil, nil, errors.New("truncated PROXY header")
+	}
+
+	verCmd, family := data[12], data[13]
+	if verCmd>>4 != version2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	length := int(binary.BigEndian.Uint16(data[14:16]))
+	if len(data) < 16+length {
+		return nil, nil, errors.New("truncated PROXY header")
+	}
+	body, payload := data[16:16+length], data[16+length:]
+
+	header := &Header{Command: verCmd & 0x0f}
+	switch header.Command {
+	case CommandLocal:
+		return header, payload, nil
+	case CommandProxy:
+	default:
+		return nil, nil, fmt.Errorf("unknown PROXY command %d", header.Command)
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case familyInet:
+		ipLen = net.IPv4len
+	case familyInet6:
+		ipLen = net.IPv6len
+	default:
+		// Unspecified or UNIX addresses carry nothing we can use.
+		return header, payload, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, errors.New("PROXY header address block too short")
+	}
+
+	header.Source = &net.UDPAddr{
+		IP:   net.IP(bytes.Clone(body[:ipLen])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+	}
+	header.Destination = &net.UDPAddr{
+		IP:   net.IP(bytes.Clone(body[ipLen : 2*ipLen])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+	}
+	return header, payload, nil
+}
@@ -0,0 +1,172 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAppendHeaderIPv4(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+
+	got := hex.EncodeToString(AppendHeader(nil, src, dst))
+	want := "0d0a0d0a000d0a515549540a" + "21" + "12" + "000c" +
+		"cb007107" + "0a000001" + "c822" + "01bb"
+	if got != want {
+		t.Errorf("AppendHeader() = %s, want %s", got, want)
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     *net.UDPAddr
+		dst     *net.UDPAddr
+		wantDst string
+	}{
+		{"ipv4", &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 5000}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}, "10.0.0.1:443"},
+		{"ipv6", &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 5000}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, "[2001:db8::1]:443"},
+		{"ipv4 source, unspecified ipv6 listener", &net.UDPAddr{IP: net.ParseIP("::ffff:203.0.113.7"), Port: 5000}, &net.UDPAddr{IP: net.IPv6unspecified, Port: 443}, "0.0.0.0:443"},
+		{"ipv6 source, ipv4 listener", &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 5000}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}, "10.0.0.1:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datagram := AppendHeader(nil, tt.src, tt.dst)
+			datagram = append(datagram, "payload"...)
+
+			header, payload, err := Parse(datagram)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != "payload" {
+				t.Errorf("payload = %q", payload)
+			}
+			if header.Command != CommandProxy || !header.Source.IP.Equal(tt.src.IP) || header.Source.Port != tt.src.Port {
+				t.Errorf("header = %+v, want source %s", header, tt.src)
+			}
+			if header.Destination.String() != tt.wantDst {
+				t.Errorf("destination = %s, want %s", header.Destination, tt.wantDst)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, payload, err := Parse([]byte("plain datagram")); !errors.Is(err, ErrNoHeader) || string(payload) != "plain datagram" {
+		t.Errorf("Parse(plain) = %q, %v, want ErrNoHeader", payload, err)
+	}
+
+	valid := AppendHeader(nil, &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2})
+	invalid := map[string][]byte{
+		"truncated":     valid[:20],
+		"short header":  valid[:14],
+		"version 1":     append(append([]byte{}, valid[:12]...), append([]byte{0x11}, valid[13:]...)...),
+		"bad command":   append(append([]byte{}, valid[:12]...), append([]byte{0x22}, valid[13:]...)...),
+		"short address": append(append([]byte{}, valid[:14]...), 0x00, 0x04, 1, 2, 3, 4),
+	}
+	for name, data := range invalid {
+		if _, _, err := Parse(data); err == nil || errors.Is(err, ErrNoHeader) {
+			t.Errorf("%s: Parse() error = %v, want a header error", name, err)
+		}
+	}
+
+	local := append(append([]byte{}, Signature...), 0x20, 0x00, 0x00, 0x00)
+	header, payload, err := Parse(append(local, "x"...))
+	if err != nil || header.Command != CommandLocal || header.Source != nil || string(payload) != "x" {
+		t.Errorf("Parse(LOCAL) = %+v, %q, %v", header, payload, err)
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewPacketConn(server)
+	defer conn.Close()
+
+	relay, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	player := &net.UDPAddr{IP: net.ParseIP("198.51.100.20"), Port: 40000}
+	first := AppendHeader(nil, player, server.LocalAddr().(*net.UDPAddr))
+	relay.Write(append(first, "first"...))
+	relay.Write([]byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00}) // Truncated signature, not a header
+	relay.Write(append(bytes.Clone(Signature), 0x21)) // Malformed header, skipped
+	relay.Write([]byte("second"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	for _, want := range []string{"first", "\r\n\r\n\x00", "second"} {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("ReadFrom() = %q, want %q", buf[:n], want)
+		}
+		if addr.String() != relay.LocalAddr().String() {
+			t.Errorf("ReadFrom() address = %s, want the relay's %s", addr, relay.LocalAddr())
+		}
+		if client := conn.ClientAddr(addr); client.String() != player.String() {
+			t.Errorf("ClientAddr() = %s, want %s", client, player)
+		}
+	}
+
+	conn.Forget(relay.LocalAddr())
+	if client := conn.ClientAddr(relay.LocalAddr()); client.String() != relay.LocalAddr().String() {
+		t.Errorf("ClientAddr() after Forget = %s, want the relay address", client)
+	}
+}
+
+func TestPacketConnTrustedRelays(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewPacketConn(server)
+	conn.SetTrustedRelays(netip.MustParsePrefix("10.0.0.0/8"))
+	defer conn.Close()
+
+	sender, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	spoofed := &net.UDPAddr{IP: net.ParseIP("198.51.100.20"), Port: 40000}
+	sender.Write(append(AppendHeader(nil, spoofed, server.LocalAddr().(*net.UDPAddr)), "spoofed"...))
+	sender.Write([]byte("plain"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "plain" {
+		t.Errorf("ReadFrom() = %q, want the header from an untrusted sender skipped", buf[:n])
+	}
+	if client := conn.ClientAddr(addr); client.String() != sender.LocalAddr().String() {
+		t.Errorf("ClientAddr() = %s, want the sender's own address", client)
+	}
+
+	conn.SetTrustedRelays(netip.MustParsePrefix("127.0.0.0/8"))
+	sender.Write(append(AppendHeader(nil, spoofed, server.LocalAddr().(*net.UDPAddr)), "relayed"...))
+	n, addr, err = conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "relayed" {
+		t.Fatalf("ReadFrom() = %q, %v, want the trusted relay's datagram", buf[:n], err)
+	}
+	if client := conn.ClientAddr(addr); client.String() != spoofed.String() {
+		t.Errorf("ClientAddr() = %s, want %s", client, spoofed)
+	}
+}
@@ -0,0 +1,76 @@
+// Package retryoffload encodes and decodes the tokens of a no-shared-state
+// QUIC Retry service (draft-ietf-quic-retry-offload).
+//
+// Porter can answer new connections with a Retry on behalf of its backends,
+// then forward the client's retried Initial, token included. The backend
+// does not validate the token itself, Porter already has, but must echo the
+// Retry in its transport parameters or the client aborts the handshake:
+//
+//	if retryoffload.IsRetryToken(token) {
+//		odcid, _, err := retryoffload.ParseToken(token)
+//		// original_destination_connection_id = odcid
+//		// retry_source_connection_id = the Initial's DCID
+//	}
+//
+// A backend behind a Retry service must not send Retry packets of its own,
+// and must set the first bit of the tokens it issues in NEW_TOKEN frames, so
+// the service can tell them apart from its own.
+package retryoffload
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Lengths of the original destination connection ID a token can carry. A
+// client's first Initial has a DCID of at least 8 bytes (RFC 9000, Section
+// 7.2).
+const (
+	MinConnIDLength = 8
+	MaxConnIDLength = 20
+)
+
+// NewTokenBit is set in the first byte of every NEW_TOKEN token and clear in
+// Retry tokens.
+const NewTokenBit = 0x80
+
+var (
+	ErrNotRetryToken = errors.New("not a retry token")
+	ErrInvalidToken  = errors.New("invalid retry token")
+)
+
+// IsRetryToken reports whether a token from a client's Initial was issued in
+// a Retry, rather than by the server in a NEW_TOKEN frame.
+func IsRetryToken(token []byte) bool {
+	return len(token) > 0 && token[0]&NewTokenBit == 0
+}
+
+// AppendToken appends a Retry token carrying the client's original
+// destination connection ID and the service's opaque data:
+//
+//	Token Type (1) = 0
+//	ODCIL (7)
+//	Original Destination Connection ID (8..20 bytes)
+//	Opaque Data (..)
+func AppendToken(b, odcid, opaque []byte) ([]byte, error) {
+	if len(odcid) < MinConnIDLength || len(odcid) > MaxConnIDLength {
+		return b, fmt.Errorf("original destination connection ID of %d bytes", len(odcid))
+	}
+	b = append(b, byte(len(odcid)))
+	b = append(b, odcid...)
+	return append(b, opaque...), nil
+}
+
+// ParseToken splits a Retry token into the original destination connection
+// ID and the service's opaque data. It returns ErrNotRetryToken for NEW_TOKEN
+// tokens. The returned slices alias token.
+func ParseToken(token []byte) (odcid, opaque []byte, err error) {
+	if !IsRetryToken(token) {
+		return nil, nil, ErrNotRetryToken
+	}
+	n := int(token[0])
+	if n < MinConnIDLength || n > MaxConnIDLength || len(token) < 1+n {
+		return nil, nil, ErrInvalidToken
+	}
+	return token[1 : 1+n], token[1+n:], nil
+}
@@ -0,0 +1,51 @@
+package retryoffload
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestAppendToken(t *testing.T) {
+	odcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	token, err := AppendToken(nil, odcid, []byte{0xaa, 0xbb})
+	if err != nil {
+		t.Fatalf("AppendToken() error = %v", err)
+	}
+	if got, want := hex.EncodeToString(token), "08"+"0102030405060708"+"aabb"; got != want {
+		t.Errorf("AppendToken() = %s, want %s", got, want)
+	}
+
+	gotODCID, opaque, err := ParseToken(token)
+	if err != nil || !bytes.Equal(gotODCID, odcid) || !bytes.Equal(opaque, []byte{0xaa, 0xbb}) {
+		t.Errorf("ParseToken() = %x, %x, %v", gotODCID, opaque, err)
+	}
+
+	for _, n := range []int{0, 7, 21} {
+		if _, err := AppendToken(nil, make([]byte, n), nil); err == nil {
+			t.Errorf("Expected a %d byte connection ID to be rejected", n)
+		}
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token []byte
+		want  error
+	}{
+		{"empty", nil, ErrNotRetryToken},
+		{"new token", []byte{NewTokenBit | 8, 1, 2, 3, 4, 5, 6, 7, 8}, ErrNotRetryToken},
+		{"short connection ID", []byte{4, 1, 2, 3, 4}, ErrInvalidToken},
+		{"long connection ID", append([]byte{21}, make([]byte, 21)...), ErrInvalidToken},
+		{"truncated", []byte{8, 1, 2, 3}, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseToken(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("ParseToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
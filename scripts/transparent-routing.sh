@@ -0,0 +1,29 @@
+#!/bin/sh
+# Sets up the policy routing needed by Porter routes with transparent: true.
+# Backend replies addressed to players are marked when they match one of
+# Porter's transparent sockets and delivered locally instead of forwarded.
+#
+# Usage: transparent-routing.sh [up|down]
+set -e
+
+MARK=${MARK:-1}
+TABLE=${TABLE:-100}
+
+run() {
+	action=$1
+	case $action in
+	up) rule=-A; ip=add ;;
+	down) rule=-D; ip=del ;;
+	*) echo "usage: $0 [up|down]" >&2; exit 2 ;;
+	esac
+
+	iptables -t mangle $rule PREROUTING -p udp -m socket --transparent -j MARK --set-mark "$MARK"
+	ip rule $ip fwmark "$MARK" lookup "$TABLE"
+	ip route $ip local 0.0.0.0/0 dev lo table "$TABLE"
+
+	ip6tables -t mangle $rule PREROUTING -p udp -m socket --transparent -j MARK --set-mark "$MARK"
+	ip -6 rule $ip fwmark "$MARK" lookup "$TABLE"
+	ip -6 route $ip local ::/0 dev lo table "$TABLE"
+}
+
+run "${1:-up}"